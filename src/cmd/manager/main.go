@@ -10,8 +10,10 @@ import (
 	"fmt"
 	"os"
 	"slices"
+	"sync"
 	"time"
 
+	"github.com/dranilew/minecraft-server-manager/src/lib/backup"
 	"github.com/dranilew/minecraft-server-manager/src/lib/common"
 	"github.com/dranilew/minecraft-server-manager/src/lib/logger"
 	"github.com/dranilew/minecraft-server-manager/src/lib/monitor"
@@ -30,18 +32,29 @@ var (
 	// extraScriptsInterval is the minimum interval at which all extra scripts
 	// for all running servers are executed.
 	extraScriptsInterval = flag.String("min_script_interval", "1m", "Interval at which the manager executes configured extra scripts for all running servers.")
+	// scheduleInterval is the interval at which the manager checks for
+	// scheduled restarts.
+	scheduleInterval = flag.String("schedule_interval", "10s", "Interval at which the manager checks for scheduled server restarts.")
+	// watchdogInterval is the interval at which the manager checks whether
+	// servers are hung.
+	watchdogInterval = flag.String("watchdog_interval", "30s", "Interval at which the manager checks the health of all running servers.")
+	// idleInterval is the interval at which the manager puts idle servers to
+	// sleep.
+	idleInterval = flag.String("idle_interval", "1m", "Interval at which the manager stops servers that had no players online for longer than their idle policy allows.")
 )
 
-func init() {
-	flag.Parse()
-}
-
 func main() {
+	flag.Parse()
 	if err := logger.Init("minecraft-server-manager"); err != nil {
 		fmt.Printf("Failed to initialize loggers: %v\n", err)
 		os.Exit(1)
 	}
 
+	// Validate the process backend before anything uses it.
+	if err := server.InitBackend(); err != nil {
+		logger.Fatalf("Invalid --backend: %v", err)
+	}
+
 	// Set up command monitoring pipeline for use with mcctl.
 	if err := monitor.Setup(context.Background()); err != nil {
 		logger.Fatalf("Failed to setup command pipeline: %v", err)
@@ -54,10 +67,18 @@ func main() {
 	logger.Printf("ServerStatus: %+v", common.ServerStatuses)
 	logger.Printf("BackupStatus: %+v", common.BackupStatuses)
 
+	// Start the servers that should run in order, in the background.
+	if err := server.Autostart(context.Background()); err != nil {
+		logger.Printf("Failed to autostart servers: %v", err)
+	}
+
 	// Start to recover and monitor servers.
 	go recoverServers()
 	go writeStatus()
 	go runExtraScripts()
+	go scheduleRestarts()
+	go runWatchdog()
+	go runIdleShutdown()
 
 	// Notify systemd that this is ready.
 	opts := run.Options{
@@ -95,6 +116,71 @@ func runExtraScripts() {
 	}
 }
 
+// scheduleRestarts restarts servers according to their restart schedules.
+func scheduleRestarts() {
+	interval, err := time.ParseDuration(*scheduleInterval)
+	if err != nil {
+		logger.Fatalf("Failed to parse schedule interval duration: %v", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	done := make(chan bool)
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := handleSchedules(); err != nil {
+				logger.Printf("Failed to handle restart schedules: %v", err)
+			}
+		}
+	}
+}
+
+// runWatchdog checks the health of all running servers, and restarts servers
+// that are hung.
+func runWatchdog() {
+	interval, err := time.ParseDuration(*watchdogInterval)
+	if err != nil {
+		logger.Fatalf("Failed to parse watchdog interval duration: %v", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	done := make(chan bool)
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := handleHealth(); err != nil {
+				logger.Printf("Failed to check server health: %v", err)
+			}
+		}
+	}
+}
+
+// runIdleShutdown puts servers to sleep that had no players online for a
+// while.
+func runIdleShutdown() {
+	interval, err := time.ParseDuration(*idleInterval)
+	if err != nil {
+		logger.Fatalf("Failed to parse idle interval duration: %v", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	done := make(chan bool)
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := handleIdle(); err != nil {
+				logger.Printf("Failed to stop idle servers: %v", err)
+			}
+		}
+	}
+}
+
 // recoverServers attempts to recover any servers that aren't running, but
 // should be running.
 func recoverServers() {
@@ -140,8 +226,8 @@ func writeStatus() {
 	}
 }
 
-// handleStatus only tries to unlock backups for a server if any players are
-// detected online on a server.
+// handleStatus advances the lifecycle states of the servers, and only tries to
+// unlock backups for a server if any players are detected online on a server.
 func handleStatus() error {
 	ctx := context.Background()
 	runningServers, err := server.GetRunningServers(ctx)
@@ -150,28 +236,34 @@ func handleStatus() error {
 	}
 
 	var errs []error
-	var changed bool
-	for _, srv := range runningServers {
-		// Ignore if the server shouldn't be running.
-		common.ServerStatusesMu.Lock()
-		if !common.ServerStatuses[srv].ShouldRun {
-			common.ServerStatusesMu.Unlock()
-			continue
-		}
-		common.ServerStatusesMu.Unlock()
+	if err := server.UpdateStates(ctx, runningServers); err != nil {
+		errs = append(errs, err)
+	}
+	if err := server.ReloadManifests(runningServers); err != nil {
+		errs = append(errs, err)
+	}
+	if err := server.ServeSleeping(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	if err := server.EnforceCgroups(ctx, runningServers); err != nil {
+		errs = append(errs, err)
+	}
 
+	var changed, idleChanged bool
+	for _, srv := range runningServers {
 		// Get the server's previous status. If it doesn't exist, then it isn't
-		// registered, so ignore it.
+		// registered, so ignore it. Also ignore it if it shouldn't be running.
 		common.ServerStatusesMu.Lock()
 		s, ok := common.ServerStatuses[srv]
-		if !ok {
+		if !ok || !s.ShouldRun {
 			common.ServerStatusesMu.Unlock()
 			continue
 		}
 		// Get the server's current status.
 		online, err := status.Online(ctx, uint16(s.Port))
 		if err != nil {
-			if time.Since(s.StartTime) < time.Minute {
+			// Servers don't answer pings until they are ready.
+			if s.State != common.StateRunning {
 				common.ServerStatusesMu.Unlock()
 				continue
 			}
@@ -179,10 +271,13 @@ func handleStatus() error {
 			errs = append(errs, fmt.Errorf("Error fetching %q server status: %v", srv, err))
 			continue
 		}
+		maintenance := s.Maintenance != nil
 		common.ServerStatusesMu.Unlock()
+		idleChanged = server.RecordPlayers(srv, online) || idleChanged
 
-		// Unlock backups if a player is online.
-		if online > 0 {
+		// Unlock backups if a player is online, unless staff are working on
+		// the server.
+		if online > 0 && !maintenance {
 			common.BackupStatusesMu.Lock()
 			if !common.BackupStatuses[srv] {
 				logger.Debugf("Players found online for %v, enabling backups", srv)
@@ -196,6 +291,11 @@ func handleStatus() error {
 	}
 
 	// Only update if something has changed.
+	if idleChanged {
+		if err := common.UpdateServerStatus(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to update server status: %v", err))
+		}
+	}
 	if changed {
 		if err := common.UpdateBackupStatus(); err != nil {
 			return fmt.Errorf("failed to update backup status: %v", err)
@@ -212,9 +312,20 @@ func handleCrash() error {
 		return err
 	}
 	var startServers []string
-	for k, v := range common.ServerStatuses {
-		// If server should run but isn't, we start it again.
-		if v.ShouldRun && !slices.Contains(runningServers, k) {
+	for _, k := range registeredServers() {
+		common.ServerStatusesMu.Lock()
+		v, ok := common.ServerStatuses[k]
+		if !ok {
+			// The server was unregistered in the meantime.
+			common.ServerStatusesMu.Unlock()
+			continue
+		}
+		stopped := v.ShouldRun && v.State != common.StateFailed && v.State != common.StateSleeping && !slices.Contains(runningServers, k)
+		common.ServerStatusesMu.Unlock()
+
+		// If server should run but isn't, we start it again unless it is
+		// crashing in a loop, or waiting to be autostarted.
+		if stopped && !server.Booting(k) && server.ShouldRecover(k) {
 			startServers = append(startServers, k)
 		}
 		// Sometimes server is still running despite having crashed.
@@ -227,6 +338,148 @@ func handleCrash() error {
 	return server.Start(ctx, startServers...)
 }
 
+// registeredServers returns the names of all registered servers.
+func registeredServers() []string {
+	common.ServerStatusesMu.Lock()
+	defer common.ServerStatusesMu.Unlock()
+	var servers []string
+	for k := range common.ServerStatuses {
+		servers = append(servers, k)
+	}
+	return servers
+}
+
+// handleIdle takes a final backup of the servers that had no players online
+// for longer than their idle policy allows, and puts them to sleep.
+func handleIdle() error {
+	ctx := context.Background()
+	finalBackup := func(ctx context.Context, srv, bucket string) error {
+		return backup.Create(ctx, backup.CreateRequest{Force: true, Bucket: bucket, Servers: []string{srv}})
+	}
+	var errs []error
+	for _, srv := range server.IdleServers() {
+		if err := server.Sleep(ctx, srv, finalBackup); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// handleSchedules restarts running servers whose scheduled restart is due.
+// Restarts of servers that aren't running are skipped.
+func handleSchedules() error {
+	ctx := context.Background()
+	runningServers, err := server.GetRunningServers(ctx)
+	if err != nil {
+		return err
+	}
+
+	// dueRestart is a scheduled restart that is due.
+	type dueRestart struct {
+		name     string
+		port     int
+		schedule common.RestartSchedule
+	}
+
+	// advance moves the server's next restart to the next activation.
+	// The caller must hold the lock.
+	now := time.Now()
+	var errs []error
+	advance := func(srv string, s *common.ServerStatus) {
+		next, err := server.NextRestart(s.RestartSchedule, now)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid restart schedule for %q: %v", srv, err))
+		}
+		s.NextRestart = next
+	}
+
+	var due []dueRestart
+	var changed bool
+	common.ServerStatusesMu.Lock()
+	for k, v := range common.ServerStatuses {
+		if v.RestartSchedule == nil {
+			continue
+		}
+		if v.NextRestart.IsZero() {
+			advance(k, v)
+			changed = true
+			continue
+		}
+		if now.Before(v.NextRestart) {
+			continue
+		}
+		// Restarts are paused during maintenance.
+		if !v.ShouldRun || v.Maintenance != nil || !slices.Contains(runningServers, k) {
+			advance(k, v)
+			changed = true
+			continue
+		}
+		due = append(due, dueRestart{name: k, port: v.Port, schedule: *v.RestartSchedule})
+	}
+	common.ServerStatusesMu.Unlock()
+
+	for _, d := range due {
+		online, err := status.Online(ctx, uint16(d.port))
+		if err != nil {
+			online = 0
+		}
+		if online > 0 && d.schedule.WaitForEmpty {
+			logger.Debugf("Delaying scheduled restart of %q until no players are online", d.name)
+			continue
+		}
+
+		common.ServerStatusesMu.Lock()
+		if s, ok := common.ServerStatuses[d.name]; ok {
+			advance(d.name, s)
+		}
+		common.ServerStatusesMu.Unlock()
+		changed = true
+
+		if online > 0 && d.schedule.SkipIfOnline {
+			logger.Printf("Skipping scheduled restart of %q, %d players are online", d.name, online)
+			continue
+		}
+		logger.Printf("Restarting %q on schedule", d.name)
+		go func() {
+			if err := server.Restart(ctx, server.StopRequest{Servers: []string{d.name}}); err != nil {
+				logger.Printf("Failed scheduled restart of %q: %v", d.name, err)
+			}
+		}()
+	}
+
+	// Only update if something has changed.
+	if changed {
+		if err := common.UpdateServerStatus(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to update server status: %v", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// handleHealth checks the health of all running servers concurrently.
+func handleHealth() error {
+	ctx := context.Background()
+	runningServers, err := server.GetRunningServers(ctx)
+	if err != nil {
+		return err
+	}
+
+	var mu sync.Mutex
+	var errs []error
+	var wg sync.WaitGroup
+	for _, srv := range runningServers {
+		wg.Go(func() {
+			if err := server.CheckHealth(ctx, srv); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("failed to check health of %q: %v", srv, err))
+				mu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
 // handleExtraScripts runs extra scripts for every single server as specified in their configuration files.
 func handleExtraScripts() error {
 	ctx := context.Background()
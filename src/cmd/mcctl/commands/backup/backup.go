@@ -82,9 +82,9 @@ func createBackup(cmd *cobra.Command, args []string) error {
 	servers := potentialServers
 	if !force {
 		servers = nil // Reset servers list since we're not forcing.
-		for _, server := range potentialServers {
-			if backup, ok := common.BackupStatuses[server]; ok && backup {
-				servers = append(servers, server)
+		for _, srv := range potentialServers {
+			if played, ok := common.BackupStatuses[srv]; ok && server.WantsBackup(srv, played, false) {
+				servers = append(servers, srv)
 			}
 		}
 	}
@@ -0,0 +1,109 @@
+// Package player is the command for managing the players of the servers.
+package player
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/monitor"
+	"github.com/dranilew/minecraft-server-manager/src/lib/server"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// servers are the servers to change.
+	servers []string
+	// tag changes all servers with the tag.
+	tag string
+	// all changes all servers.
+	all bool
+	// remove removes players from the whitelist or as operators.
+	remove bool
+	// reason is the reason of a ban.
+	reason string
+	// syncLists makes the whitelists of the servers identical.
+	syncLists bool
+)
+
+// New returns a new command for managing players.
+func New() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "player",
+		Short: "Manages players",
+		Long: `Manages the whitelists, operators and bans of one or more servers.
+
+Running servers are changed through the console. The whitelist.json, ops.json and banned-players.json of stopped servers are changed directly, looking up UUIDs in the usercache.json of all servers. Servers in offline mode fall back to the offline UUID of players that never joined.`,
+	}
+
+	whitelistCmd := &cobra.Command{
+		Use:   "whitelist [players]",
+		Short: "Whitelists players",
+		Long:  "Adds players to the whitelist, or removes them with --remove. With --sync, the whitelists of the servers are made identical, containing every player whitelisted on any of them.",
+		RunE:  managePlayers,
+	}
+	whitelistCmd.Flags().BoolVar(&remove, "remove", false, "Remove the players from the whitelist.")
+	whitelistCmd.Flags().BoolVar(&syncLists, "sync", false, "Make the whitelists of the servers identical.")
+
+	opCmd := &cobra.Command{
+		Use:   "op <players>",
+		Short: "Makes players operators",
+		Long:  "Makes players operators, or removes them as operators with --remove.",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  managePlayers,
+	}
+	opCmd.Flags().BoolVar(&remove, "remove", false, "Remove the players as operators.")
+
+	banCmd := &cobra.Command{
+		Use:   "ban <players>",
+		Short: "Bans players",
+		Long:  "Bans players.",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  managePlayers,
+	}
+	banCmd.Flags().StringVar(&reason, "reason", "", "Reason of the ban.")
+
+	pardonCmd := &cobra.Command{
+		Use:   "pardon <players>",
+		Short: "Pardons players",
+		Long:  "Removes the ban of players.",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  managePlayers,
+	}
+
+	for _, c := range []*cobra.Command{whitelistCmd, opCmd, banCmd, pardonCmd} {
+		c.Flags().StringSliceVar(&servers, "server", nil, "Server to change. Can be repeated.")
+		c.Flags().StringVar(&tag, "tag", "", "Change all servers with this tag.")
+		c.Flags().BoolVar(&all, "all", false, "Change all servers.")
+		cmd.AddCommand(c)
+	}
+	return cmd
+}
+
+// managePlayers sends the player request to the manager.
+func managePlayers(cmd *cobra.Command, args []string) error {
+	req := server.PlayerRequest{
+		Action:  server.PlayerAction(cmd.Name()),
+		Players: args,
+		Remove:  remove,
+		Reason:  reason,
+		Servers: servers,
+		Tag:     tag,
+		All:     all,
+		Sync:    syncLists,
+	}
+	if len(req.Servers) == 0 && !req.All && req.Tag == "" {
+		return fmt.Errorf("no servers given, pass --server, --tag or --all")
+	}
+	if len(req.Players) == 0 && !req.Sync {
+		return fmt.Errorf("no players given")
+	}
+	reqJson, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request %v: %v", req, err)
+	}
+	commandReq := strings.Join([]string{"player", string(reqJson)}, " ")
+	return monitor.StreamCommand(cmd.Context(), []byte(commandReq), func(line string) {
+		fmt.Println(line)
+	})
+}
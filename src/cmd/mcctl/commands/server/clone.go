@@ -0,0 +1,87 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/backup"
+	"github.com/dranilew/minecraft-server-manager/src/lib/monitor"
+	"github.com/dranilew/minecraft-server-manager/src/lib/server"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// cloneWorld copies the world of the source server.
+	cloneWorld bool
+	// cloneNoWorld leaves out the world of the source server.
+	cloneNoWorld bool
+	// cloneFromBackup is the backup to restore the world of the clone from.
+	cloneFromBackup string
+	// cloneBucket is the location of the backups of the source server.
+	cloneBucket string
+)
+
+func newCloneCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "clone <src> <dst>",
+		Short: "Clones a server",
+		Long: `Copies a server into a new server, for example to test modpack updates.
+
+The clone gets a free block of ports, and is registered without starting it. Logs, crash reports and world locks are not copied. A world restored from a backup is placed in the world directory of the source, as set by its level-name.`,
+		Args: cobra.ExactArgs(2),
+		RunE: cloneServer,
+	}
+	cmd.Flags().BoolVar(&cloneWorld, "world", true, "Copy the world of the source server.")
+	cmd.Flags().BoolVar(&cloneNoWorld, "no-world", false, "Leave out the world, so that the clone generates a new one.")
+	cmd.Flags().StringVar(&cloneFromBackup, "from-backup", "", "Restore the world from a backup instead of copying it. With --bucket, this is the generation id of a backup of the source server, or latest. Otherwise, this is a backup zip, or a gs:// URL with an optional #<generation> suffix.")
+	cmd.Flags().StringVar(&cloneBucket, "bucket", "", "The GCS bucket and location of the backups of the source server, as given to backup create. This should contain gs://.")
+	cmd.MarkFlagsMutuallyExclusive("world", "no-world")
+	cmd.MarkFlagsMutuallyExclusive("no-world", "from-backup")
+	return cmd
+}
+
+// cloneServer sends the request to clone the server to the manager.
+func cloneServer(cmd *cobra.Command, args []string) error {
+	req := server.CloneRequest{
+		Source:     args[0],
+		Server:     args[1],
+		NoWorld:    cloneNoWorld || !cloneWorld,
+		FromBackup: cloneFromBackup,
+	}
+	if cloneBucket != "" && req.FromBackup == "" {
+		return fmt.Errorf("--bucket is only used with --from-backup")
+	}
+	switch {
+	case cloneBucket != "":
+		// Select the generation of the backup of the source in the bucket.
+		var generation string
+		if req.FromBackup != "latest" {
+			if _, err := strconv.ParseInt(req.FromBackup, 10, 64); err != nil {
+				return fmt.Errorf("invalid backup generation %q: %v", req.FromBackup, err)
+			}
+			generation = req.FromBackup
+		}
+		url, err := backup.URL(cloneBucket, req.Source, generation)
+		if err != nil {
+			return err
+		}
+		req.FromBackup = url
+	case req.FromBackup != "" && !strings.HasPrefix(req.FromBackup, "gs://"):
+		path, err := filepath.Abs(req.FromBackup)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %q: %v", req.FromBackup, err)
+		}
+		req.FromBackup = path
+	}
+	reqJson, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request %v: %v", req, err)
+	}
+	commandReq := strings.Join([]string{"server", "clone", string(reqJson)}, " ")
+	return monitor.StreamCommand(cmd.Context(), []byte(commandReq), func(line string) {
+		fmt.Println(line)
+	})
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/monitor"
+	"github.com/dranilew/minecraft-server-manager/src/lib/server"
+	"github.com/spf13/cobra"
+)
+
+// configRestart restarts the server if a changed property requires it.
+var configRestart bool
+
+func newConfigCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config <server> get|set|unset|diff [key] [value]",
+		Short: "Edits server.properties",
+		Long: `Reads or changes the server.properties of a server, preserving comments and ordering.
+
+get prints the value of a property, or all properties if no key is given.
+set changes a property, and unset removes it so that the default is used.
+diff shows the properties that differ from the vanilla defaults.`,
+		Args: cobra.RangeArgs(2, 4),
+		RunE: serverConfig,
+	}
+	cmd.Flags().BoolVar(&configRestart, "restart", false, "Restart the server if it is running and the change requires a restart.")
+	return cmd
+}
+
+// serverConfig runs the config subcommand.
+func serverConfig(cmd *cobra.Command, args []string) error {
+	srv, action := args[0], args[1]
+	var key string
+	if len(args) > 2 {
+		key = args[2]
+	}
+	switch action {
+	case "get":
+		if len(args) > 3 {
+			return fmt.Errorf("get takes at most a key")
+		}
+		return getConfig(srv, key)
+	case "diff":
+		if len(args) > 3 {
+			return fmt.Errorf("diff takes at most a key")
+		}
+		return diffConfig(srv, key)
+	case "set":
+		if len(args) != 4 {
+			return fmt.Errorf("set takes a key and a value")
+		}
+		return sendConfigRequest(cmd, server.ConfigRequest{Server: srv, Key: key, Value: args[3], Restart: configRestart})
+	case "unset":
+		if len(args) != 3 {
+			return fmt.Errorf("unset takes a key")
+		}
+		return sendConfigRequest(cmd, server.ConfigRequest{Server: srv, Key: key, Unset: true, Restart: configRestart})
+	default:
+		return fmt.Errorf("unknown action %q, must be one of get, set, unset or diff", action)
+	}
+}
+
+// getConfig prints the value of the property, or all properties.
+func getConfig(srv, key string) error {
+	props, err := server.LoadProperties(srv)
+	if err != nil {
+		return err
+	}
+	if key != "" {
+		value, ok := props.Get(key)
+		if !ok {
+			return fmt.Errorf("%s is not set", key)
+		}
+		fmt.Println(value)
+		return nil
+	}
+	for _, k := range props.Keys() {
+		value, _ := props.Get(k)
+		fmt.Printf("%s=%s\n", k, value)
+	}
+	return nil
+}
+
+// diffConfig prints the properties that differ from the defaults.
+func diffConfig(srv, key string) error {
+	diffs, err := server.DiffProperties(srv, key)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 5, 1, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tDEFAULT\tVALUE")
+	for _, d := range diffs {
+		def := d.Default
+		if !d.HasDefault {
+			def = "-"
+		}
+		fmt.Fprintln(w, strings.Join([]string{d.Key, def, d.Value}, "\t"))
+	}
+	return w.Flush()
+}
+
+// sendConfigRequest sends the config change to the manager, and prints its progress.
+func sendConfigRequest(cmd *cobra.Command, req server.ConfigRequest) error {
+	reqJson, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request %v: %v", req, err)
+	}
+	commandReq := strings.Join([]string{"server", "config", string(reqJson)}, " ")
+	return monitor.StreamCommand(cmd.Context(), []byte(commandReq), func(line string) {
+		fmt.Println(line)
+	})
+}
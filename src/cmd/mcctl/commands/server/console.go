@@ -0,0 +1,89 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/logger"
+	"github.com/dranilew/minecraft-server-manager/src/lib/monitor"
+	"github.com/dranilew/minecraft-server-manager/src/lib/server"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// consoleHistory is the number of previous log lines shown when attaching.
+	consoleHistory int
+)
+
+func newConsoleCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "console <server>",
+		Short: "Attaches to a server console",
+		Long:  "Attaches to the console of a running server through the manager. Every entered line is run as a console command. Press Ctrl-D to detach without stopping the server.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  serverConsole,
+	}
+	cmd.Flags().IntVar(&consoleHistory, "history", 20, "Number of previous log lines to show when attaching.")
+	return cmd
+}
+
+// serverConsole opens an interactive console session through the manager.
+func serverConsole(cmd *cobra.Command, args []string) error {
+	req := server.ConsoleRequest{
+		Server:  args[0],
+		History: consoleHistory,
+	}
+	reqJson, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request %v: %v", req, err)
+	}
+	commandReq := strings.Join([]string{"server", "console", string(reqJson)}, " ")
+
+	// Without a terminal, forward stdin line by line.
+	fd := int(os.Stdin.Fd())
+	if !isTerminal(fd) {
+		input := make(chan string)
+		go func() {
+			defer close(input)
+			scanner := bufio.NewScanner(os.Stdin)
+			for scanner.Scan() {
+				input <- scanner.Text()
+			}
+		}()
+		return monitor.InteractiveCommand(cmd.Context(), []byte(commandReq), input, func(line string) {
+			fmt.Println(line)
+		})
+	}
+
+	restore, err := makeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("failed to put terminal into raw mode: %v", err)
+	}
+	defer restore()
+	editor := newLineEditor(os.Stdin, os.Stdout, "> ")
+	defer func() {
+		if err := editor.SaveHistory(); err != nil {
+			logger.Debugf("Failed to save console history: %v", err)
+		}
+	}()
+
+	input := make(chan string)
+	go func() {
+		defer close(input)
+		for {
+			line, err := editor.ReadLine()
+			if err != nil {
+				if !errors.Is(err, errDetach) {
+					editor.Println(fmt.Sprintf("Failed to read input: %v", err))
+				}
+				return
+			}
+			input <- line
+		}
+	}()
+	return monitor.InteractiveCommand(cmd.Context(), []byte(commandReq), input, editor.Println)
+}
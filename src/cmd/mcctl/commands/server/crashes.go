@@ -0,0 +1,80 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/server"
+	"github.com/spf13/cobra"
+)
+
+// maxExceptionWidth is the width at which exceptions are cut off when
+// listing crashes.
+const maxExceptionWidth = 80
+
+func newCrashesCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "crashes <server> [number]",
+		Short: "Shows server crashes",
+		Long:  "Lists the crashes of a server, newest first, or shows the details of a single crash, like its stack trace and suspected mods.",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE:  serverCrashes,
+	}
+}
+
+// serverCrashes lists the crash history of the server, or shows a single crash.
+func serverCrashes(_ *cobra.Command, args []string) error {
+	history, err := server.CrashHistory(args[0])
+	if err != nil {
+		return err
+	}
+	slices.Reverse(history)
+
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 || n > len(history) {
+			return fmt.Errorf("no crash %q for server %q, it has %d crashes", args[1], args[0], len(history))
+		}
+		crash := history[n-1]
+		fmt.Printf("File:           %s\n", crash.File)
+		fmt.Printf("Time:           %s\n", formatTime(crash.Time))
+		fmt.Printf("Description:    %s\n", crash.Description)
+		fmt.Printf("Exception:      %s\n", crash.Exception)
+		fmt.Printf("Suspected mods: %s\n", formatMods(crash.SuspectedMods))
+		if len(crash.Frames) > 0 {
+			fmt.Println("Stack trace:")
+			for _, frame := range crash.Frames {
+				fmt.Printf("\tat %s\n", frame)
+			}
+		}
+		return nil
+	}
+
+	if len(history) == 0 {
+		fmt.Printf("Server %q has not crashed\n", args[0])
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 5, 1, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tTIME\tDESCRIPTION\tSUSPECTED\tEXCEPTION")
+	for i, crash := range history {
+		exception := crash.Exception
+		if len(exception) > maxExceptionWidth {
+			exception = exception[:maxExceptionWidth-3] + "..."
+		}
+		lineFields := []string{strconv.Itoa(i + 1), formatTime(crash.Time), crash.Description, formatMods(crash.SuspectedMods), exception}
+		fmt.Fprintln(w, strings.Join(lineFields, "\t"))
+	}
+	return w.Flush()
+}
+
+// formatMods formats the mods for display, or returns "-" if there are none.
+func formatMods(mods []string) string {
+	if len(mods) == 0 {
+		return "-"
+	}
+	return strings.Join(mods, ", ")
+}
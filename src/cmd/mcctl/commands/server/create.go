@@ -0,0 +1,56 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/monitor"
+	"github.com/dranilew/minecraft-server-manager/src/lib/server"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// createLoader is the type of the source of the new server.
+	createLoader string
+	// createAcceptEULA accepts the minecraft EULA for the new server.
+	createAcceptEULA bool
+)
+
+func newCreateCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "create <server> <source>",
+		Short: "Creates a new server",
+		Long: `Creates a new server from a server pack zip, or a vanilla, Fabric, Forge or NeoForge installer or server jar on disk.
+
+The server is installed, given a run.sh script and a free block of ports, and registered without starting it.`,
+		Args: cobra.ExactArgs(2),
+		RunE: createServer,
+	}
+	cmd.Flags().StringVar(&createLoader, "loader", "", fmt.Sprintf("Type of the source, one of %v. Detected from the source if not set.", server.Loaders))
+	cmd.Flags().BoolVar(&createAcceptEULA, "accept-eula", false, "Accept the Minecraft EULA (https://aka.ms/MinecraftEULA) for the server.")
+	return cmd
+}
+
+// createServer sends the request to create the server to the manager.
+func createServer(cmd *cobra.Command, args []string) error {
+	source, err := filepath.Abs(args[1])
+	if err != nil {
+		return fmt.Errorf("failed to resolve %q: %v", args[1], err)
+	}
+	req := server.CreateRequest{
+		Server:     args[0],
+		Source:     source,
+		Loader:     createLoader,
+		AcceptEULA: createAcceptEULA,
+	}
+	reqJson, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request %v: %v", req, err)
+	}
+	commandReq := strings.Join([]string{"server", "create", string(reqJson)}, " ")
+	return monitor.StreamCommand(cmd.Context(), []byte(commandReq), func(line string) {
+		fmt.Println(line)
+	})
+}
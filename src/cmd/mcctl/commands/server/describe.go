@@ -0,0 +1,50 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/common"
+	"github.com/dranilew/minecraft-server-manager/src/lib/server"
+	"github.com/spf13/cobra"
+)
+
+func newDescribeCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "describe <server>",
+		Short: "Shows the configuration of a server",
+		Long: `Shows the effective configuration of a server and where each setting comes from.
+
+Settings in the server.yaml in the server directory take precedence over the ones set with mcctl, which take precedence over the defaults of the manager. The manager reloads server.yaml when it changes, and keeps the last valid settings if it is invalid.`,
+		Args: cobra.ExactArgs(1),
+		RunE: describeServer,
+	}
+}
+
+// describeServer prints the effective configuration of the server.
+func describeServer(_ *cobra.Command, args []string) error {
+	if err := common.InitStatuses(); err != nil {
+		return fmt.Errorf("error initializing server status map: %v", err)
+	}
+	desc, err := server.Describe(args[0])
+	if err != nil {
+		return err
+	}
+	if desc.Manifest == "" {
+		fmt.Printf("Server %q has no %s\n\n", desc.Server, server.ManifestFile)
+	} else {
+		fmt.Printf("Manifest: %s\n", desc.Manifest)
+		if desc.ManifestError != "" {
+			fmt.Printf("Manifest is invalid, the manager keeps the last valid settings: %s\n", desc.ManifestError)
+		}
+		fmt.Println()
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 5, 1, 2, ' ', 0)
+	fmt.Fprintln(w, "SETTING\tVALUE\tSOURCE")
+	for _, setting := range desc.Settings {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", setting.Name, setting.Value, setting.Source)
+	}
+	return w.Flush()
+}
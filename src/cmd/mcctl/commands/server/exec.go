@@ -0,0 +1,99 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/monitor"
+	"github.com/dranilew/minecraft-server-manager/src/lib/server"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// execAll runs the command on all running servers.
+	execAll bool
+	// execTag runs the command on all running servers with the tag.
+	execTag string
+	// tagRemove removes tags instead of adding them.
+	tagRemove bool
+)
+
+func newExecCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "exec <servers|--all|--tag tag> -- <command>",
+		Short: "Runs a console command",
+		Long:  "Runs a console command on one or more servers, and prints the output and result of each server.",
+		RunE:  serverExec,
+	}
+	cmd.Flags().BoolVar(&execAll, "all", false, "Run the command on all running servers.")
+	cmd.Flags().StringVar(&execTag, "tag", "", "Run the command on all running servers with this tag.")
+	return cmd
+}
+
+func newTagCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "tag <server> <tags>",
+		Short: "Tags a server",
+		Long:  "Adds tags to a server, which can be used to select groups of servers.",
+		Args:  cobra.MinimumNArgs(2),
+		RunE:  serverTag,
+	}
+	cmd.Flags().BoolVar(&tagRemove, "remove", false, "Remove the tags instead of adding them.")
+	return cmd
+}
+
+// serverExec sends the exec request to the manager and prints the result of
+// every server.
+func serverExec(cmd *cobra.Command, args []string) error {
+	dash := cmd.ArgsLenAtDash()
+	if dash < 0 || dash == len(args) {
+		return fmt.Errorf("no command given, pass the command after --")
+	}
+	req := server.ExecRequest{
+		Servers: args[:dash],
+		All:     execAll,
+		Tag:     execTag,
+		Command: strings.Join(args[dash:], " "),
+	}
+	if len(req.Servers) == 0 && !req.All && req.Tag == "" {
+		return fmt.Errorf("no servers given")
+	}
+	reqJson, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request %v: %v", req, err)
+	}
+	commandReq := strings.Join([]string{"server", "exec", string(reqJson)}, " ")
+
+	return monitor.StreamCommand(cmd.Context(), []byte(commandReq), func(output string) {
+		var result server.ExecResult
+		if err := json.Unmarshal([]byte(output), &result); err != nil {
+			fmt.Println(output)
+			return
+		}
+		fmt.Printf("==> %s <==\n", result.Server)
+		if result.Output != "" {
+			fmt.Println(result.Output)
+		}
+		if result.Error != "" {
+			fmt.Printf("FAILED: %s\n\n", result.Error)
+		} else {
+			fmt.Print("OK\n\n")
+		}
+	})
+}
+
+// serverTag sends the tag request to the manager.
+func serverTag(cmd *cobra.Command, args []string) error {
+	req := server.TagRequest{
+		Server: args[0],
+		Tags:   args[1:],
+		Remove: tagRemove,
+	}
+	reqJson, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request %v: %v", req, err)
+	}
+	commandReq := strings.Join([]string{"server", "tag", string(reqJson)}, " ")
+	return monitor.SendCommand(cmd.Context(), []byte(commandReq))
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/common"
+	"github.com/dranilew/minecraft-server-manager/src/lib/monitor"
+	"github.com/dranilew/minecraft-server-manager/src/lib/server"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// jvmJava is the java binary of the JVM settings.
+	jvmJava string
+	// jvmMinHeap is the initial heap size of the JVM settings.
+	jvmMinHeap string
+	// jvmMaxHeap is the maximum heap size of the JVM settings.
+	jvmMaxHeap string
+	// jvmGC is the garbage collector preset of the JVM settings.
+	jvmGC string
+	// jvmArgs are the extra arguments of the JVM settings.
+	jvmArgs []string
+	// jvmReset stops managing the JVM settings.
+	jvmReset bool
+)
+
+func newJVMCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "jvm <server> show|set",
+		Short: "Manages JVM settings",
+		Long: `Shows or sets the heap size, garbage collector, extra arguments and java binary of a server.
+
+The settings are rendered into user_jvm_args.txt for loaders that read it, like Forge and NeoForge, and otherwise into the run.sh of servers running a server jar. set replaces all settings, and unset values use the defaults. Changes take effect on the next start. The files are kept as run.sh.orig and user_jvm_args.txt.orig before they are first rewritten, and restored with --reset.`,
+		Args: cobra.ExactArgs(2),
+		RunE: serverJVM,
+	}
+	cmd.Flags().StringVar(&jvmJava, "java", "", "Path of the java binary. Defaults to the manager's java binary.")
+	cmd.Flags().StringVar(&jvmMinHeap, "min-heap", "", "Initial heap size, like 4G.")
+	cmd.Flags().StringVar(&jvmMaxHeap, "max-heap", "", "Maximum heap size, like 8G.")
+	cmd.Flags().StringVar(&jvmGC, "gc", "", fmt.Sprintf("Garbage collector preset, one of %v.", server.GCPresets))
+	cmd.Flags().StringArrayVar(&jvmArgs, "arg", nil, "Extra JVM argument. Can be repeated.")
+	cmd.Flags().BoolVar(&jvmReset, "reset", false, "Stop managing the JVM settings, restoring the run.sh and user_jvm_args.txt from before they were first rewritten.")
+	return cmd
+}
+
+// serverJVM runs the jvm subcommand.
+func serverJVM(cmd *cobra.Command, args []string) error {
+	switch args[1] {
+	case "show":
+		return showJVM(args[0])
+	case "set":
+		return setJVM(cmd, args[0])
+	default:
+		return fmt.Errorf("unknown action %q, must be show or set", args[1])
+	}
+}
+
+// showJVM prints the JVM settings of the server.
+func showJVM(srv string) error {
+	if err := common.InitStatuses(); err != nil {
+		return fmt.Errorf("error initializing server status map: %v", err)
+	}
+	common.ServerStatusesMu.Lock()
+	status, ok := common.ServerStatuses[srv]
+	var settings *common.JVMSettings
+	var source string
+	if ok {
+		settings, source = server.EffectiveJVM(status)
+	}
+	common.ServerStatusesMu.Unlock()
+	if !ok {
+		return fmt.Errorf("server %q is not registered", srv)
+	}
+	if settings == nil {
+		fmt.Printf("JVM settings of server %q are not managed, it runs its run.sh as is\n", srv)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 5, 1, 2, ' ', 0)
+	fmt.Fprintf(w, "JAVA\t%s\n", server.JavaPath(*settings))
+	fmt.Fprintf(w, "MIN HEAP\t%s\n", cmp.Or(settings.MinHeap, "-"))
+	fmt.Fprintf(w, "MAX HEAP\t%s\n", cmp.Or(settings.MaxHeap, "-"))
+	fmt.Fprintf(w, "GC\t%s\n", cmp.Or(settings.GC, "-"))
+	fmt.Fprintf(w, "EXTRA ARGS\t%s\n", cmp.Or(strings.Join(settings.ExtraArgs, " "), "-"))
+	fmt.Fprintf(w, "ARGS\t%s\n", cmp.Or(strings.Join(server.JVMArgs(*settings), " "), "-"))
+	fmt.Fprintf(w, "SOURCE\t%s\n", source)
+	return w.Flush()
+}
+
+// setJVM sends the JVM settings request to the manager.
+func setJVM(cmd *cobra.Command, srv string) error {
+	req := server.JVMRequest{
+		Server: srv,
+		Reset:  jvmReset,
+		Settings: common.JVMSettings{
+			Java:      jvmJava,
+			MinHeap:   jvmMinHeap,
+			MaxHeap:   jvmMaxHeap,
+			GC:        jvmGC,
+			ExtraArgs: jvmArgs,
+		},
+	}
+	if !jvmReset {
+		if err := server.ValidateJVM(req.Settings); err != nil {
+			return err
+		}
+	}
+	reqJson, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request %v: %v", req, err)
+	}
+	commandReq := strings.Join([]string{"server", "jvm", string(reqJson)}, " ")
+	return monitor.StreamCommand(cmd.Context(), []byte(commandReq), func(line string) {
+		fmt.Println(line)
+	})
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/monitor"
+	"github.com/dranilew/minecraft-server-manager/src/lib/server"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// limitsMemoryMax is the hard memory limit.
+	limitsMemoryMax string
+	// limitsMemoryHigh is the memory throttling limit.
+	limitsMemoryHigh string
+	// limitsCPUWeight is the relative share of CPU time.
+	limitsCPUWeight int
+	// limitsPidsMax is the maximum number of processes and threads.
+	limitsPidsMax int
+	// limitsReset removes all resource limits.
+	limitsReset bool
+)
+
+func newLimitsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "limits <server>",
+		Short: "Sets server resource limits",
+		Long: `Sets the cgroup v2 resource limits of a server, so that a runaway server can't take the whole host down. Unset limits use the kernel defaults.
+
+Limits of running servers are applied right away. The memory usage and OOM kills of each server are shown by mcctl server info.`,
+		Args: cobra.ExactArgs(1),
+		RunE: setLimits,
+	}
+	cmd.Flags().StringVar(&limitsMemoryMax, "memory-max", "", "Hard memory limit, like 10G, above which the server is OOM-killed.")
+	cmd.Flags().StringVar(&limitsMemoryHigh, "memory-high", "", "Memory limit, like 9G, above which the server is throttled.")
+	cmd.Flags().IntVar(&limitsCPUWeight, "cpu-weight", 0, "Relative share of CPU time, from 1 to 10000. The default is 100.")
+	cmd.Flags().IntVar(&limitsPidsMax, "pids-max", 0, "Maximum number of processes and threads.")
+	cmd.Flags().BoolVar(&limitsReset, "reset", false, "Remove all resource limits.")
+	return cmd
+}
+
+// setLimits sends the resource limits request to the manager.
+func setLimits(cmd *cobra.Command, args []string) error {
+	req := server.LimitsRequest{
+		Server: args[0],
+		Reset:  limitsReset,
+	}
+	req.Limits.MemoryMax = limitsMemoryMax
+	req.Limits.MemoryHigh = limitsMemoryHigh
+	req.Limits.CPUWeight = limitsCPUWeight
+	req.Limits.PidsMax = limitsPidsMax
+	if err := server.ValidateLimits(req.Limits); err != nil {
+		return err
+	}
+	reqJson, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request %v: %v", req, err)
+	}
+	commandReq := strings.Join([]string{"server", "limits", string(reqJson)}, " ")
+	return monitor.SendCommand(cmd.Context(), []byte(commandReq))
+}
@@ -0,0 +1,286 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	// maxHistory is the maximum number of history entries kept.
+	maxHistory = 500
+	// historyFile is the file in the user's home directory storing history.
+	historyFile = ".mcctl_history"
+)
+
+const (
+	keyCtrlA     = 0x01
+	keyCtrlC     = 0x03
+	keyCtrlD     = 0x04
+	keyCtrlE     = 0x05
+	keyCtrlK     = 0x0b
+	keyCtrlU     = 0x15
+	keyCtrlW     = 0x17
+	keyEnter     = '\r'
+	keyNewline   = '\n'
+	keyEscape    = 0x1b
+	keyBackspace = 0x7f
+	keyCtrlH     = 0x08
+)
+
+// errDetach is returned by ReadLine when the user detaches with Ctrl-D.
+var errDetach = errors.New("detached")
+
+// lineEditor reads lines from a terminal in raw mode, with cursor movement and
+// history. Output can be printed while a line is being edited, in which case
+// the line being edited is redrawn below the output.
+type lineEditor struct {
+	in     *bufio.Reader
+	out    io.Writer
+	prompt string
+
+	mu   sync.Mutex
+	line []rune
+	pos  int
+
+	history []string
+	// histPos is the history entry being shown. len(history) is the line
+	// being edited.
+	histPos int
+	// pending is the line being edited before browsing the history.
+	pending []rune
+}
+
+func newLineEditor(in io.Reader, out io.Writer, prompt string) *lineEditor {
+	e := &lineEditor{
+		in:     bufio.NewReader(in),
+		out:    out,
+		prompt: prompt,
+	}
+	e.history = loadHistory()
+	e.histPos = len(e.history)
+	return e
+}
+
+// Println prints the line above the line being edited.
+func (e *lineEditor) Println(line string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	fmt.Fprintf(e.out, "\r\x1b[K%s\r\n", strings.ReplaceAll(line, "\n", "\r\n"))
+	e.redraw()
+}
+
+// redraw redraws the prompt and the line being edited. The caller must hold
+// the lock.
+func (e *lineEditor) redraw() {
+	fmt.Fprintf(e.out, "\r\x1b[K%s%s", e.prompt, string(e.line))
+	if back := len(e.line) - e.pos; back > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dD", back)
+	}
+}
+
+// ReadLine reads a single line. errDetach is returned if the user presses
+// Ctrl-D on an empty line.
+func (e *lineEditor) ReadLine() (string, error) {
+	e.mu.Lock()
+	e.redraw()
+	e.mu.Unlock()
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		// Read the rest of escape sequences before taking the lock, so that
+		// output can be printed while waiting for it.
+		var seq string
+		if r == keyEscape {
+			seq = e.readEscape()
+		}
+
+		e.mu.Lock()
+		line, done, err := e.handleKey(r, seq)
+		e.mu.Unlock()
+		if err != nil || done {
+			return line, err
+		}
+	}
+}
+
+// handleKey applies the key to the line being edited. seq is the escape
+// sequence following an escape key. The caller must hold the lock.
+func (e *lineEditor) handleKey(r rune, seq string) (string, bool, error) {
+	switch r {
+	case keyEnter, keyNewline:
+		line := string(e.line)
+		e.line, e.pos = nil, 0
+		fmt.Fprint(e.out, "\r\n")
+		e.addHistory(line)
+		return line, true, nil
+	case keyCtrlD:
+		if len(e.line) == 0 {
+			fmt.Fprint(e.out, "\r\n")
+			return "", false, errDetach
+		}
+		e.delete()
+	case keyCtrlC:
+		e.line, e.pos = nil, 0
+		e.histPos = len(e.history)
+		fmt.Fprint(e.out, "^C\r\n")
+	case keyCtrlA:
+		e.pos = 0
+	case keyCtrlE:
+		e.pos = len(e.line)
+	case keyCtrlK:
+		e.line = e.line[:e.pos]
+	case keyCtrlU:
+		e.line = slices.Delete(e.line, 0, e.pos)
+		e.pos = 0
+	case keyCtrlW:
+		start := e.pos
+		for start > 0 && e.line[start-1] == ' ' {
+			start--
+		}
+		for start > 0 && e.line[start-1] != ' ' {
+			start--
+		}
+		e.line = slices.Delete(e.line, start, e.pos)
+		e.pos = start
+	case keyBackspace, keyCtrlH:
+		if e.pos > 0 {
+			e.pos--
+			e.delete()
+		}
+	case keyEscape:
+		e.handleEscape(seq)
+	default:
+		if r < ' ' {
+			return "", false, nil
+		}
+		e.line = slices.Insert(e.line, e.pos, r)
+		e.pos++
+	}
+	e.redraw()
+	return "", false, nil
+}
+
+// readEscape reads the escape sequence following an escape key, like the
+// arrow keys. An empty string is returned for anything else.
+func (e *lineEditor) readEscape() string {
+	r, _, err := e.in.ReadRune()
+	if err != nil || (r != '[' && r != 'O') {
+		return ""
+	}
+	// Read the parameters up to the final byte of the sequence.
+	var seq []rune
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return ""
+		}
+		seq = append(seq, r)
+		if r >= 0x40 && r <= 0x7e {
+			return string(seq)
+		}
+	}
+}
+
+// handleEscape handles an escape sequence read by readEscape. The caller
+// must hold the lock.
+func (e *lineEditor) handleEscape(seq string) {
+	switch seq {
+	case "A":
+		e.showHistory(e.histPos - 1)
+	case "B":
+		e.showHistory(e.histPos + 1)
+	case "C":
+		if e.pos < len(e.line) {
+			e.pos++
+		}
+	case "D":
+		if e.pos > 0 {
+			e.pos--
+		}
+	case "H", "1~":
+		e.pos = 0
+	case "F", "4~":
+		e.pos = len(e.line)
+	case "3~":
+		e.delete()
+	}
+}
+
+// delete deletes the character under the cursor. The caller must hold the lock.
+func (e *lineEditor) delete() {
+	if e.pos < len(e.line) {
+		e.line = slices.Delete(e.line, e.pos, e.pos+1)
+	}
+}
+
+// showHistory replaces the line being edited with the history entry. The
+// caller must hold the lock.
+func (e *lineEditor) showHistory(i int) {
+	if i < 0 || i > len(e.history) {
+		return
+	}
+	if e.histPos == len(e.history) {
+		e.pending = e.line
+	}
+	e.histPos = i
+	if i == len(e.history) {
+		e.line = e.pending
+	} else {
+		e.line = []rune(e.history[i])
+	}
+	e.pos = len(e.line)
+}
+
+// addHistory adds the line to the history. The caller must hold the lock.
+func (e *lineEditor) addHistory(line string) {
+	if strings.TrimSpace(line) != "" && (len(e.history) == 0 || e.history[len(e.history)-1] != line) {
+		e.history = append(e.history, line)
+		if len(e.history) > maxHistory {
+			e.history = e.history[len(e.history)-maxHistory:]
+		}
+	}
+	e.histPos = len(e.history)
+	e.pending = nil
+}
+
+// historyPath returns the location of the history file.
+func historyPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, historyFile)
+}
+
+// loadHistory loads the history from the history file.
+func loadHistory() []string {
+	path := historyPath()
+	if path == "" {
+		return nil
+	}
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	return strings.FieldsFunc(string(contents), func(r rune) bool { return r == '\n' })
+}
+
+// SaveHistory writes the history to the history file.
+func (e *lineEditor) SaveHistory() error {
+	path := historyPath()
+	if path == "" {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return os.WriteFile(path, []byte(strings.Join(e.history, "\n")+"\n"), 0600)
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/monitor"
+	"github.com/dranilew/minecraft-server-manager/src/lib/server"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// maintenanceMessage is the message non-staff players are kicked with.
+	maintenanceMessage string
+	// maintenanceKeepWhitelist switches on the existing whitelist instead of
+	// only allowing operators.
+	maintenanceKeepWhitelist bool
+)
+
+func newMaintenanceCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:       "maintenance <server> on|off",
+		Short:     "Turns maintenance mode on or off",
+		ValidArgs: []string{"on", "off"},
+		Long: `Closes a server to everyone but staff, for example during modpack updates.
+
+Maintenance mode switches on the whitelist with only the operators of the server on it, kicks everyone else with the message, and uses the message as the MOTD. Scheduled restarts and unlocking backups are paused. Turning maintenance mode off restores the whitelist and server.properties as they were.`,
+		Args: cobra.ExactArgs(2),
+		RunE: setMaintenance,
+	}
+	cmd.Flags().StringVar(&maintenanceMessage, "message", "", "Message non-staff players are kicked with, also used as the MOTD.")
+	cmd.Flags().BoolVar(&maintenanceKeepWhitelist, "keep-whitelist", false, "Switch on the existing whitelist instead of only allowing operators.")
+	return cmd
+}
+
+// setMaintenance sends the request to turn maintenance mode on or off to the
+// manager.
+func setMaintenance(cmd *cobra.Command, args []string) error {
+	req := server.MaintenanceRequest{
+		Server:        args[0],
+		Message:       maintenanceMessage,
+		KeepWhitelist: maintenanceKeepWhitelist,
+	}
+	switch args[1] {
+	case "on":
+		req.Enable = true
+	case "off":
+		if maintenanceMessage != "" || maintenanceKeepWhitelist {
+			return fmt.Errorf("--message and --keep-whitelist only apply when turning maintenance mode on")
+		}
+	default:
+		return fmt.Errorf("invalid mode %q, must be on or off", args[1])
+	}
+	reqJson, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request %v: %v", req, err)
+	}
+	commandReq := strings.Join([]string{"server", "maintenance", string(reqJson)}, " ")
+	return monitor.StreamCommand(cmd.Context(), []byte(commandReq), func(line string) {
+		fmt.Println(line)
+	})
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/common"
+	"github.com/dranilew/minecraft-server-manager/src/lib/monitor"
+	"github.com/dranilew/minecraft-server-manager/src/lib/server"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// setQueryPort is the new query port.
+	setQueryPort int
+	// setRCONPort is the new RCON port.
+	setRCONPort int
+)
+
+func newSetPortCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "set-port <server> [port]",
+		Short: "Reassigns server ports",
+		Long:  "Reassigns the game, query and RCON ports of a stopped server. Ports that aren't given are left unchanged.",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE:  setPorts,
+	}
+	cmd.Flags().IntVar(&setQueryPort, "query", 0, "New query port.")
+	cmd.Flags().IntVar(&setRCONPort, "rcon", 0, "New RCON port.")
+	return cmd
+}
+
+func newPortsCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "ports",
+		Short: "Lists server ports",
+		Long:  "Lists the game, query and RCON ports of all servers, along with ports used by more than one server.",
+		Args:  cobra.NoArgs,
+		RunE:  listPorts,
+	}
+}
+
+// setPorts sends the request to reassign the ports to the manager.
+func setPorts(cmd *cobra.Command, args []string) error {
+	req := server.SetPortsRequest{
+		Server: args[0],
+		Ports:  server.Ports{Query: setQueryPort, RCON: setRCONPort},
+	}
+	if len(args) == 2 {
+		port, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid port %q: %v", args[1], err)
+		}
+		req.Ports.Game = port
+	}
+	if req.Ports == (server.Ports{}) {
+		return fmt.Errorf("no ports given")
+	}
+	reqJson, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request %v: %v", req, err)
+	}
+	commandReq := strings.Join([]string{"server", "set-port", string(reqJson)}, " ")
+	return monitor.SendCommand(cmd.Context(), []byte(commandReq))
+}
+
+// listPorts prints the ports of all servers.
+func listPorts(*cobra.Command, []string) error {
+	if err := common.InitStatuses(); err != nil {
+		return fmt.Errorf("error initializing server status map: %v", err)
+	}
+
+	common.ServerStatusesMu.Lock()
+	var statuses []*common.ServerStatus
+	for _, v := range common.ServerStatuses {
+		statuses = append(statuses, v)
+	}
+	conflicts := server.PortConflicts()
+	common.ServerStatusesMu.Unlock()
+	slices.SortFunc(statuses, func(a *common.ServerStatus, b *common.ServerStatus) int {
+		return a.Port - b.Port
+	})
+
+	// formatPort formats the port for display, marking conflicting ports.
+	formatPort := func(port int) string {
+		if port == 0 {
+			return "-"
+		}
+		if slices.ContainsFunc(conflicts, func(c server.PortConflict) bool { return c.Port == port }) {
+			return fmt.Sprintf("%d!", port)
+		}
+		return strconv.Itoa(port)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 5, 1, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tGAME\tQUERY\tRCON")
+	for _, v := range statuses {
+		ports := server.PortsOf(v)
+		fmt.Fprintln(w, strings.Join([]string{v.Name, formatPort(ports.Game), formatPort(ports.Query), formatPort(ports.RCON)}, "\t"))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	for _, c := range conflicts {
+		fmt.Printf("Port %d is used by %s\n", c.Port, strings.Join(c.Servers, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/monitor"
+	"github.com/dranilew/minecraft-server-manager/src/lib/server"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// archiveBucket is the location to which to save the final backup.
+	archiveBucket string
+	// archiveSkipBackup skips the final backup.
+	archiveSkipBackup bool
+)
+
+func newRegisterCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "register <servers>",
+		Short: "Registers servers",
+		Long:  "Registers servers in the modpack directory with the manager without starting them, and gives each a free block of ports.",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  sendRequest,
+	}
+}
+
+func newUnregisterCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "unregister <servers>",
+		Short: "Unregisters servers",
+		Long:  "Removes stopped servers from the manager, including their backup status. Their directories are left in place.",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  sendRequest,
+	}
+}
+
+func newArchiveCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "archive <server>",
+		Short: "Archives a server",
+		Long:  "Stops the server, takes a final backup, moves it to the archive directory and unregisters it.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  archiveServer,
+	}
+	cmd.Flags().StringVar(&archiveBucket, "bucket", "", "The GCS bucket and location to which to store the final backup. This should contain gs://.")
+	cmd.Flags().BoolVar(&archiveSkipBackup, "skip-backup", false, "Skip the final backup.")
+	return cmd
+}
+
+// archiveServer sends the request to archive the server to the manager.
+func archiveServer(cmd *cobra.Command, args []string) error {
+	if archiveBucket == "" && !archiveSkipBackup {
+		return fmt.Errorf("--bucket is required for the final backup, unless --skip-backup is set")
+	}
+	req := server.ArchiveRequest{
+		Server:     args[0],
+		Bucket:     archiveBucket,
+		SkipBackup: archiveSkipBackup,
+	}
+	reqJson, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request %v: %v", req, err)
+	}
+	commandReq := strings.Join([]string{"server", "archive", string(reqJson)}, " ")
+	return monitor.StreamCommand(cmd.Context(), []byte(commandReq), func(line string) {
+		fmt.Println(line)
+	})
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/monitor"
+	"github.com/dranilew/minecraft-server-manager/src/lib/server"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// scheduleTimeZone is the time zone of the restart schedule.
+	scheduleTimeZone string
+	// scheduleSkipIfOnline skips scheduled restarts while players are online.
+	scheduleSkipIfOnline bool
+	// scheduleWaitForEmpty delays scheduled restarts until no players are online.
+	scheduleWaitForEmpty bool
+	// scheduleClear removes the restart schedule.
+	scheduleClear bool
+)
+
+func newScheduleCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "schedule <server> [cron]",
+		Short: "Schedules server restarts",
+		Long:  `Restarts the server on a cron schedule, like "0 4 * * *", using its stop policy. Pass --clear to remove the schedule.`,
+		Args:  cobra.RangeArgs(1, 2),
+		RunE:  setSchedule,
+	}
+	cmd.Flags().StringVar(&scheduleTimeZone, "tz", "", "Time zone in which the schedule is evaluated, like Europe/Berlin. Defaults to the manager's local time zone.")
+	cmd.Flags().BoolVar(&scheduleSkipIfOnline, "skip-if-online", false, "Skip a scheduled restart if players are online.")
+	cmd.Flags().BoolVar(&scheduleWaitForEmpty, "wait-for-empty", false, "Delay a scheduled restart until no players are online.")
+	cmd.Flags().BoolVar(&scheduleClear, "clear", false, "Remove the restart schedule.")
+	return cmd
+}
+
+// setSchedule sends the schedule request to the manager.
+func setSchedule(cmd *cobra.Command, args []string) error {
+	req := server.ScheduleRequest{
+		Server: args[0],
+		Clear:  scheduleClear,
+	}
+	if !scheduleClear {
+		if len(args) < 2 {
+			return fmt.Errorf("no cron expression given")
+		}
+		if scheduleSkipIfOnline && scheduleWaitForEmpty {
+			return fmt.Errorf("--skip-if-online and --wait-for-empty are mutually exclusive")
+		}
+		req.Schedule.Cron = args[1]
+		req.Schedule.TimeZone = scheduleTimeZone
+		req.Schedule.SkipIfOnline = scheduleSkipIfOnline
+		req.Schedule.WaitForEmpty = scheduleWaitForEmpty
+	}
+	reqJson, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request %v: %v", req, err)
+	}
+	commandReq := strings.Join([]string{"server", "schedule", string(reqJson)}, " ")
+	return monitor.SendCommand(cmd.Context(), []byte(commandReq))
+}
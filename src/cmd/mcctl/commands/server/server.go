@@ -2,13 +2,17 @@
 package server
 
 import (
+	"cmp"
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
+	"os/signal"
 	"slices"
 	"strconv"
 	"strings"
 	"text/tabwriter"
+	"time"
 
 	"github.com/dranilew/minecraft-server-manager/src/lib/common"
 	"github.com/dranilew/minecraft-server-manager/src/lib/monitor"
@@ -16,6 +20,15 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var (
+	// logsFollow keeps streaming new log lines.
+	logsFollow bool
+	// logsSince only shows log lines logged within the duration.
+	logsSince time.Duration
+	// logsGrep only shows log lines matching the pattern.
+	logsGrep string
+)
+
 func New() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "server",
@@ -28,6 +41,28 @@ func New() *cobra.Command {
 	cmd.AddCommand(newRestartCommand())
 	cmd.AddCommand(newStopCommand())
 	cmd.AddCommand(newInfoCommand())
+	cmd.AddCommand(newDescribeCommand())
+	cmd.AddCommand(newLogsCommand())
+	cmd.AddCommand(newConsoleCommand())
+	cmd.AddCommand(newExecCommand())
+	cmd.AddCommand(newTagCommand())
+	cmd.AddCommand(newStopPolicyCommand())
+	cmd.AddCommand(newScheduleCommand())
+	cmd.AddCommand(newResetFailedCommand())
+	cmd.AddCommand(newCrashesCommand())
+	cmd.AddCommand(newWaitCommand())
+	cmd.AddCommand(newSetPortCommand())
+	cmd.AddCommand(newPortsCommand())
+	cmd.AddCommand(newConfigCommand())
+	cmd.AddCommand(newJVMCommand())
+	cmd.AddCommand(newLimitsCommand())
+	cmd.AddCommand(newUserCommand())
+	cmd.AddCommand(newMaintenanceCommand())
+	cmd.AddCommand(newCreateCommand())
+	cmd.AddCommand(newCloneCommand())
+	cmd.AddCommand(newRegisterCommand())
+	cmd.AddCommand(newUnregisterCommand())
+	cmd.AddCommand(newArchiveCommand())
 	return cmd
 }
 
@@ -41,19 +76,33 @@ func newStartCommand() *cobra.Command {
 }
 
 func newRestartCommand() *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "restart <servers>",
 		Short: "Restarts a server",
-		Long:  "Restarts all listed servers.",
-		RunE:  sendRequest,
+		Long:  "Restarts all listed servers, warning online players according to each server's stop policy.",
+		RunE:  sendStopRequest,
 	}
+	cmd.Flags().BoolVar(&stopNow, "now", false, "Skip the countdown warning online players.")
+	return cmd
 }
 
 func newStopCommand() *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "stop <servers>",
 		Short: "Stops a sever",
-		Long:  "Stops all listed servers.",
+		Long:  "Stops all listed servers, warning online players according to each server's stop policy.",
+		RunE:  sendStopRequest,
+	}
+	cmd.Flags().BoolVar(&stopNow, "now", false, "Skip the countdown warning online players.")
+	return cmd
+}
+
+func newResetFailedCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "reset-failed <servers>",
+		Short: "Resets failed servers",
+		Long:  "Clears the failed state and restart count of all listed servers, so that the manager recovers them again.",
+		Args:  cobra.MinimumNArgs(1),
 		RunE:  sendRequest,
 	}
 }
@@ -67,6 +116,20 @@ func newInfoCommand() *cobra.Command {
 	}
 }
 
+func newLogsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "logs <server>",
+		Short: "Shows server logs",
+		Long:  "Shows the console logs of a server, including rotated logs, optionally following new lines as they are logged.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  serverLogs,
+	}
+	cmd.Flags().BoolVarP(&logsFollow, "follow", "f", false, "Keep streaming new lines as they are logged.")
+	cmd.Flags().DurationVar(&logsSince, "since", 0, "Only show lines logged within this duration, like 10m.")
+	cmd.Flags().StringVar(&logsGrep, "grep", "", "Only show lines matching this regular expression.")
+	return cmd
+}
+
 func listServers(*cobra.Command, []string) error {
 	srvs, err := server.GetRunningServers(context.Background())
 	if err != nil {
@@ -83,7 +146,7 @@ func serverInfo(*cobra.Command, []string) error {
 
 	w := tabwriter.NewWriter(os.Stdout, 5, 1, 2, ' ', 0)
 	var result []string
-	result = append(result, "NAME\tPORT\tSHOULDRUN\tSTARTTIME")
+	result = append(result, "NAME\tPORT\tSHOULDRUN\tSTATE\tSTARTTIME\tTAGS\tNEXTRESTART\tRESTARTS\tNEXTRETRY\tHEALTH\tIDLE\tMEMORY\tOOMKILLS")
 
 	// Get the slice of all server statuses.
 	var statuses []*common.ServerStatus
@@ -100,7 +163,12 @@ func serverInfo(*cobra.Command, []string) error {
 
 	// Formulate the output.
 	for _, v := range statuses {
-		lineFields := []string{v.Name, strconv.Itoa(v.Port), strconv.FormatBool(v.ShouldRun), v.StartTime.String()}
+		lineFields := []string{v.Name, strconv.Itoa(v.Port), strconv.FormatBool(v.ShouldRun), formatState(v), v.StartTime.String(), strings.Join(server.Tags(v), ","), formatTime(v.NextRestart), strconv.Itoa(len(v.Restarts)), formatTime(v.NextRetry), cmp.Or(v.Health, "-"), formatIdle(v)}
+		if usage, err := server.ReadCgroupUsage(v.Name); err == nil {
+			lineFields = append(lineFields, formatMemory(usage), strconv.Itoa(usage.OOMKills))
+		} else {
+			lineFields = append(lineFields, "-", "-")
+		}
 		line := strings.Join(lineFields, "\t")
 		result = append(result, line)
 	}
@@ -111,6 +179,82 @@ func serverInfo(*cobra.Command, []string) error {
 	return nil
 }
 
+// serverLogs streams the server logs from the manager.
+func serverLogs(cmd *cobra.Command, args []string) error {
+	req := server.LogsRequest{
+		Server: args[0],
+		Follow: logsFollow,
+		Since:  logsSince,
+		Grep:   logsGrep,
+	}
+	reqJson, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request %v: %v", req, err)
+	}
+	commandReq := strings.Join([]string{"server", "logs", string(reqJson)}, " ")
+
+	// Follow until interrupted.
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+	defer stop()
+	err = monitor.StreamCommand(ctx, []byte(commandReq), func(line string) {
+		fmt.Println(line)
+	})
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// formatTime formats the time for display, or returns "-" for the zero time.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format("2006-01-02 15:04:05 MST")
+}
+
+// formatIdle formats the time since which no players have been online.
+func formatIdle(status *common.ServerStatus) string {
+	if status.IdleSince.IsZero() {
+		return "-"
+	}
+	return time.Since(status.IdleSince).Round(time.Second).String()
+}
+
+// formatMemory formats the memory usage of the cgroup, along with its limit.
+func formatMemory(usage server.CgroupUsage) string {
+	if usage.MemoryMax == 0 {
+		return formatBytes(usage.Memory)
+	}
+	return fmt.Sprintf("%s/%s", formatBytes(usage.Memory), formatBytes(usage.MemoryMax))
+}
+
+// formatBytes formats the number of bytes with a binary unit, like 1.5G.
+func formatBytes(n int64) string {
+	const units = "KMGTPE"
+	if n < 1024 {
+		return fmt.Sprintf("%dB", n)
+	}
+	value, unit := float64(n), -1
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f%c", value, units[unit])
+}
+
+// formatState formats the state of the server along with the time spent in it.
+func formatState(status *common.ServerStatus) string {
+	if status.State == "" {
+		return "-"
+	}
+	state := fmt.Sprintf("%s (%s)", status.State, time.Since(status.StateSince).Round(time.Second))
+	if status.Maintenance != nil {
+		state += " [maintenance]"
+	}
+	return state
+}
+
 // sendRequest sends a request to the command socket.
 func sendRequest(cmd *cobra.Command, args []string) error {
 	reqArgs := append([]string{"server", cmd.Name()}, args...)
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/monitor"
+	"github.com/dranilew/minecraft-server-manager/src/lib/server"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// stopNow skips the countdown when stopping servers.
+	stopNow bool
+	// policyWarnings are the warning times of the stop policy.
+	policyWarnings string
+	// policyTimeout is the kill timeout of the stop policy.
+	policyTimeout time.Duration
+	// policyReset resets the stop policy to the defaults.
+	policyReset bool
+)
+
+func newStopPolicyCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "stop-policy <server>",
+		Short: "Sets the stop policy of a server",
+		Long:  "Sets the times at which online players are warned before the server stops, and how long to wait for the server to exit before force-killing it. Only the given values change, and values never set use the manager's defaults.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  setStopPolicy,
+	}
+	cmd.Flags().StringVar(&policyWarnings, "warn", "", "Comma-separated times before stopping at which online players are warned, like 5m,1m,10s. An empty value disables warnings.")
+	cmd.Flags().DurationVar(&policyTimeout, "timeout", 0, "Time to wait for the server to exit before force-killing it.")
+	cmd.Flags().BoolVar(&policyReset, "reset", false, "Reset the stop policy to the manager's defaults.")
+	return cmd
+}
+
+// sendStopRequest sends a stop or restart request to the manager.
+func sendStopRequest(cmd *cobra.Command, args []string) error {
+	req := server.StopRequest{
+		Servers: args,
+		Now:     stopNow,
+	}
+	reqJson, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request %v: %v", req, err)
+	}
+	commandReq := strings.Join([]string{"server", cmd.Name(), string(reqJson)}, " ")
+
+	// The countdown may take longer than the default timeout, which the
+	// manager allows for stop requests.
+	return monitor.StreamCommand(cmd.Context(), []byte(commandReq), func(string) {})
+}
+
+// setStopPolicy sends the stop policy request to the manager.
+func setStopPolicy(cmd *cobra.Command, args []string) error {
+	req := server.StopPolicyRequest{
+		Server: args[0],
+		Reset:  policyReset,
+	}
+	if cmd.Flags().Changed("warn") {
+		warnings, err := server.ParseWarnings(policyWarnings)
+		if err != nil {
+			return err
+		}
+		req.Policy.Warnings = warnings
+	}
+	if cmd.Flags().Changed("timeout") {
+		req.Policy.Timeout = policyTimeout
+	}
+	reqJson, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request %v: %v", req, err)
+	}
+	commandReq := strings.Join([]string{"server", "stop-policy", string(reqJson)}, " ")
+	return monitor.SendCommand(cmd.Context(), []byte(commandReq))
+}
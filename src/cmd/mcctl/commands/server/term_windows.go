@@ -0,0 +1,17 @@
+//go:build windows
+
+package server
+
+import (
+	"fmt"
+)
+
+// isTerminal is not supported on Windows.
+func isTerminal(int) bool {
+	return false
+}
+
+// makeRaw is not supported on Windows.
+func makeRaw(int) (func(), error) {
+	return nil, fmt.Errorf("not implemented on windows")
+}
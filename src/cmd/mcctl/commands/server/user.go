@@ -0,0 +1,60 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/monitor"
+	"github.com/dranilew/minecraft-server-manager/src/lib/server"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// userCreate creates the user if it doesn't exist.
+	userCreate bool
+	// userClear makes the server run as the manager's user again.
+	userClear bool
+)
+
+func newUserCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "user <server> [user]",
+		Short: "Sets the user a server runs as",
+		Long: `Sets the system user a server and its extra scripts run as, and gives the user ownership of the server directory.
+
+With --create, the user is created as a system user without a login shell if it doesn't exist. Its name defaults to mc-<server>.`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: setUser,
+	}
+	cmd.Flags().BoolVar(&userCreate, "create", false, "Create the user as a system user if it doesn't exist.")
+	cmd.Flags().BoolVar(&userClear, "clear", false, "Run the server as the manager's user again.")
+	cmd.MarkFlagsMutuallyExclusive("create", "clear")
+	return cmd
+}
+
+// setUser sends the request to change the user of the server to the manager.
+func setUser(cmd *cobra.Command, args []string) error {
+	req := server.UserRequest{
+		Server: args[0],
+		Create: userCreate,
+		Clear:  userClear,
+	}
+	if len(args) > 1 {
+		req.User = args[1]
+	}
+	if req.Clear && req.User != "" {
+		return fmt.Errorf("a user can't be given with --clear")
+	}
+	if !req.Clear && !req.Create && req.User == "" {
+		return fmt.Errorf("a user is required, unless --create or --clear is set")
+	}
+	reqJson, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request %v: %v", req, err)
+	}
+	commandReq := strings.Join([]string{"server", "user", string(reqJson)}, " ")
+	return monitor.StreamCommand(cmd.Context(), []byte(commandReq), func(line string) {
+		fmt.Println(line)
+	})
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/common"
+	"github.com/dranilew/minecraft-server-manager/src/lib/server"
+	"github.com/spf13/cobra"
+)
+
+// waitPollInterval is the interval at which the server state is polled.
+const waitPollInterval = time.Second
+
+var (
+	// waitState is the state to wait for.
+	waitState string
+	// waitTimeout is how long to wait for the state.
+	waitTimeout time.Duration
+)
+
+func newWaitCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "wait <server>",
+		Short: "Waits for a server state",
+		Long:  fmt.Sprintf("Waits until the server reaches the given state, one of %v. Fails if the server fails or the timeout expires.", server.States),
+		Args:  cobra.ExactArgs(1),
+		RunE:  waitForState,
+	}
+	cmd.Flags().StringVar(&waitState, "state", string(common.StateRunning), "State to wait for.")
+	cmd.Flags().DurationVar(&waitTimeout, "timeout", 5*time.Minute, "Time to wait for the state. Zero waits forever.")
+	return cmd
+}
+
+// waitForState polls the server information until the server is in the
+// requested state.
+func waitForState(cmd *cobra.Command, args []string) error {
+	want, err := server.ParseState(waitState)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+	defer stop()
+	if waitTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, waitTimeout)
+		defer cancel()
+	}
+
+	ticker := time.NewTicker(waitPollInterval)
+	defer ticker.Stop()
+	for {
+		if err := common.InitStatuses(); err != nil {
+			return fmt.Errorf("error initializing server status map: %v", err)
+		}
+		common.ServerStatusesMu.Lock()
+		status, ok := common.ServerStatuses[args[0]]
+		var state common.State
+		if ok {
+			state = status.State
+		}
+		common.ServerStatusesMu.Unlock()
+
+		switch {
+		case !ok:
+			return fmt.Errorf("server %q is not registered", args[0])
+		case state == want:
+			return nil
+		case state == common.StateFailed:
+			return fmt.Errorf("server %q failed", args[0])
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("server %q did not become %s within %s, it is %s", args[0], want, waitTimeout, state)
+		case <-ticker.C:
+		}
+	}
+}
@@ -5,16 +5,19 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"os"
 
 	"github.com/dranilew/minecraft-server-manager/src/cmd/mcctl/commands/backup"
+	"github.com/dranilew/minecraft-server-manager/src/cmd/mcctl/commands/player"
 	"github.com/dranilew/minecraft-server-manager/src/cmd/mcctl/commands/server"
 	"github.com/dranilew/minecraft-server-manager/src/lib/logger"
 	"github.com/spf13/cobra"
 )
 
 func main() {
+	flag.Parse()
 	ctx := context.Background()
 	rootCmd := &cobra.Command{
 		Use:   "mcctl",
@@ -27,6 +30,7 @@ func main() {
 
 	rootCmd.AddCommand(backup.New())
 	rootCmd.AddCommand(server.New())
+	rootCmd.AddCommand(player.New())
 
 	if err := logger.Init("mcctl", os.Stdout); err != nil {
 		fmt.Printf("Failed to initialize logger: %v\n", err)
@@ -10,6 +10,8 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -37,6 +39,8 @@ type CreateRequest struct {
 var (
 	// storageClient is the client used to interact with GCS.
 	storageClient *storage.Client
+	// bucketRegex matches gs:// URLs, capturing the bucket and the path.
+	bucketRegex = regexp.MustCompile("gs://([^/]+)/?(.*)")
 )
 
 func init() {
@@ -73,11 +77,68 @@ func Create(ctx context.Context, req CreateRequest) error {
 	return errors.Join(errs...)
 }
 
+// Fetch downloads the backup at the gs:// URL to a temporary file, and returns
+// its path. The caller must remove the file. A generation of the backup can
+// be selected with a "#<generation>" suffix, if the bucket keeps versions.
+func Fetch(ctx context.Context, url string) (string, error) {
+	url, generation, versioned := strings.Cut(url, "#")
+	match := bucketRegex.FindStringSubmatch(url)
+	if len(match) == 0 || match[2] == "" {
+		return "", fmt.Errorf("invalid backup %q: backup should be a gs:// URL of a backup file", url)
+	}
+	object := storageClient.Bucket(match[1]).Object(match[2])
+	if versioned {
+		gen, err := strconv.ParseInt(generation, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid generation %q: %v", generation, err)
+		}
+		object = object.Generation(gen)
+	}
+
+	reader, err := object.NewReader(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to read backup %q: %v", url, err)
+	}
+	defer reader.Close()
+	zipFile, err := os.CreateTemp("", "restore-*.zip")
+	if err != nil {
+		return "", fmt.Errorf("failed to create file for backup %q: %v", url, err)
+	}
+	defer zipFile.Close()
+	wrote, err := io.Copy(zipFile, reader)
+	if err != nil {
+		os.Remove(zipFile.Name())
+		return "", fmt.Errorf("failed to download backup %q: %v", url, err)
+	}
+	logger.Printf("Downloaded %d bytes from %q", wrote, url)
+	return zipFile.Name(), nil
+}
+
+// URL returns the gs:// URL of the backup of the server in the bucket, with
+// a "#<generation>" suffix if a generation is given.
+func URL(bucket, server, generation string) (string, error) {
+	match := bucketRegex.FindStringSubmatch(bucket)
+	if len(match) == 0 {
+		return "", fmt.Errorf("invalid bucket %q: bucket should be a valid gs:// URL", bucket)
+	}
+	url := fmt.Sprintf("gs://%s/%s", match[1], objectName(match[2], server))
+	if generation != "" {
+		url += "#" + generation
+	}
+	return url, nil
+}
+
 // backupName is the name of the backup.
 func backupName(server string) string {
 	return fmt.Sprintf("%s-backup.zip", server)
 }
 
+// objectName is the name of the backup object of the server under the
+// directory of the bucket.
+func objectName(dir, server string) string {
+	return filepath.Join(dir, server, backupName(server))
+}
+
 // shouldBackup indicates whether the given server should be backed up.
 func shouldBackup(force bool, srv string) bool {
 	common.BackupStatusesMu.Lock()
@@ -85,20 +146,16 @@ func shouldBackup(force bool, srv string) bool {
 
 	// Backup status might not exist if it's a new server.
 	// Automatically assume a backup should be made if it doesn't exist.
-	status, ok := common.BackupStatuses[srv]
+	played, ok := common.BackupStatuses[srv]
 	if !ok {
 		common.BackupStatuses[srv] = true
-		return true
+		played = true
 	}
-	return force || status
+	return server.WantsBackup(srv, played, force)
 }
 
 // createBackup creates a backup for the specific server.
 func createBackup(ctx context.Context, srv string, req CreateRequest) (bool, error) {
-	bucketRegex, err := regexp.Compile("gs://([^/]+)/?(.*)")
-	if err != nil {
-		return false, fmt.Errorf("failed to compile bucket regex: %v", err)
-	}
 	var match []string
 	if match = bucketRegex.FindStringSubmatch(req.Bucket); len(match) == 0 {
 		return false, fmt.Errorf("invalid destination %q: destination should not be empty and should be a valid gs:// URL", req.Bucket)
@@ -140,7 +197,7 @@ func createBackup(ctx context.Context, srv string, req CreateRequest) (bool, err
 	if !req.SkipUpload {
 		// First match is the name of the bucket.
 		// Second match is the destination folder.
-		objectWriter := storageClient.Bucket(match[1]).Object(filepath.Join(match[2], srv, backupName(srv))).NewWriter(ctx)
+		objectWriter := storageClient.Bucket(match[1]).Object(objectName(match[2], srv)).NewWriter(ctx)
 
 		// Copy the zip file into the object writer to upload to GCS.
 		wrote, err := io.Copy(objectWriter, zipFile)
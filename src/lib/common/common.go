@@ -14,10 +14,6 @@ import (
 	"github.com/dranilew/minecraft-server-manager/src/lib/logger"
 )
 
-func init() {
-	flag.Parse()
-}
-
 // ServerStatus represents the status of a server.
 type ServerStatus struct {
 	// Name is the name of the server/modpack.
@@ -26,14 +22,160 @@ type ServerStatus struct {
 	ShouldRun bool `json:"should-run"`
 	// Port is the port that the server is using.
 	Port int `json:"port"`
+	// QueryPort is the UDP port on which the server answers queries.
+	QueryPort int `json:"query-port,omitempty"`
+	// RCONPort is the port on which the server accepts RCON connections.
+	RCONPort int `json:"rcon-port,omitempty"`
 	// StartTime is the time the server started.
 	StartTime time.Time
+	// State is the lifecycle state of the server.
+	State State `json:"state,omitempty"`
+	// StateSince is the time at which the server entered its state.
+	StateSince time.Time `json:"state-since,omitzero"`
+	// Tags are labels used to select groups of servers.
+	Tags []string `json:"tags,omitempty"`
+	// StopPolicy overrides the default stop policy of the server.
+	StopPolicy *StopPolicy `json:"stop-policy,omitempty"`
+	// RestartSchedule is the schedule on which the server is restarted.
+	RestartSchedule *RestartSchedule `json:"restart-schedule,omitempty"`
+	// User is the system user the server runs as. The server runs as the
+	// manager's user if this isn't set.
+	User string `json:"user,omitempty"`
+	// JVM configures the Java virtual machine the server runs in. The run
+	// script of the server is used as is if this isn't set.
+	JVM *JVMSettings `json:"jvm,omitempty"`
+	// Limits are the resource limits of the cgroup of the server.
+	Limits *ResourceLimits `json:"limits,omitempty"`
+	// NextRestart is the time of the next scheduled restart.
+	NextRestart time.Time `json:"next-restart,omitzero"`
+	// Restarts are the times at which the server was restarted after stopping
+	// unexpectedly, within the crash window.
+	Restarts []time.Time `json:"restarts,omitempty"`
+	// NextRetry is the time at which the server is restarted after stopping
+	// unexpectedly.
+	NextRetry time.Time `json:"next-retry,omitzero"`
+	// Health is the health verdict of the watchdog.
+	Health string `json:"health,omitempty"`
+	// IdleSince is the time since which no players have been online on the
+	// running server.
+	IdleSince time.Time `json:"idle-since,omitzero"`
+	// UnhealthySince is the time since which the server has been unresponsive.
+	UnhealthySince time.Time `json:"unhealthy-since,omitzero"`
+	// Maintenance is set while the server is closed to everyone but staff.
+	Maintenance *Maintenance `json:"maintenance,omitempty"`
 	// Recover contains server recovery information. Do not store
 	// this because if the binary is stopped while a server is recovering,
 	// then this is permanently marked as true.
 	Recovering bool `json:"-"`
 }
 
+// State is the lifecycle state of a server.
+type State string
+
+const (
+	// StateStopped means the server isn't running, and isn't expected to.
+	StateStopped State = "stopped"
+	// StateStarting means the server was started, but isn't ready yet.
+	StateStarting State = "starting"
+	// StateRunning means the server is ready for players.
+	StateRunning State = "running"
+	// StateStopping means the server is shutting down.
+	StateStopping State = "stopping"
+	// StateCrashed means the server exited unexpectedly, and is waiting to
+	// be recovered.
+	StateCrashed State = "crashed"
+	// StateRecovering means the server is being restarted after crashing.
+	StateRecovering State = "recovering"
+	// StateFailed means the server crashed too often, and is no longer
+	// recovered until it is reset.
+	StateFailed State = "failed"
+	// StateSleeping means the server was stopped after no players were online
+	// for a while. It is expected to run again, but isn't recovered.
+	StateSleeping State = "sleeping"
+)
+
+// SetState changes the state of the server, and returns whether it changed.
+// The caller must hold the lock.
+func (s *ServerStatus) SetState(state State) bool {
+	if s.State == state {
+		return false
+	}
+	s.State = state
+	s.StateSince = time.Now()
+	return true
+}
+
+// StopPolicy configures how a server is stopped.
+type StopPolicy struct {
+	// Warnings are the times before stopping at which online players are warned.
+	Warnings []time.Duration `json:"warnings"`
+	// Timeout is the time to wait for the server to exit before force-killing it.
+	Timeout time.Duration `json:"timeout,omitempty"`
+}
+
+// RestartSchedule configures scheduled restarts of a server.
+type RestartSchedule struct {
+	// Cron is the cron expression on which the server is restarted.
+	Cron string `json:"cron"`
+	// TimeZone is the time zone in which the cron expression is evaluated.
+	// This defaults to the local time zone.
+	TimeZone string `json:"time-zone,omitempty"`
+	// SkipIfOnline skips a restart if players are online.
+	SkipIfOnline bool `json:"skip-if-online,omitempty"`
+	// WaitForEmpty delays a restart until no players are online.
+	WaitForEmpty bool `json:"wait-for-empty,omitempty"`
+}
+
+// Maintenance is the maintenance mode of a server, along with the settings to
+// restore when it is turned off.
+type Maintenance struct {
+	// Since is the time maintenance mode was turned on.
+	Since time.Time `json:"since"`
+	// Message is the message non-staff players are kicked with.
+	Message string `json:"message,omitempty"`
+	// StaffOnly indicates whether the whitelist was replaced by the staff,
+	// instead of switching on the existing whitelist.
+	StaffOnly bool `json:"staff-only,omitempty"`
+	// Properties are the previous values of the properties changed for
+	// maintenance.
+	Properties map[string]string `json:"properties,omitempty"`
+	// UnsetProperties are the properties changed for maintenance that weren't
+	// set before.
+	UnsetProperties []string `json:"unset-properties,omitempty"`
+	// WhitelistSaved indicates whether the whitelist was saved, and is
+	// restored when maintenance mode is turned off.
+	WhitelistSaved bool `json:"whitelist-saved,omitempty"`
+}
+
+// JVMSettings configures the Java virtual machine a server runs in.
+type JVMSettings struct {
+	// Java is the path of the java binary.
+	Java string `json:"java,omitempty" yaml:"java,omitempty"`
+	// MinHeap is the initial heap size, like 4G.
+	MinHeap string `json:"min-heap,omitempty" yaml:"min-heap,omitempty"`
+	// MaxHeap is the maximum heap size, like 8G.
+	MaxHeap string `json:"max-heap,omitempty" yaml:"max-heap,omitempty"`
+	// GC is the name of the garbage collector preset, like aikar.
+	GC string `json:"gc,omitempty" yaml:"gc,omitempty"`
+	// ExtraArgs are additional arguments passed to the JVM.
+	ExtraArgs []string `json:"extra-args,omitempty" yaml:"extra-args,omitempty"`
+}
+
+// ResourceLimits are the cgroup v2 resource limits of a server. Unset limits
+// are left at the kernel defaults.
+type ResourceLimits struct {
+	// MemoryMax is the hard memory limit, like 8G, above which the server is
+	// OOM-killed.
+	MemoryMax string `json:"memory-max,omitempty" yaml:"memory-max,omitempty"`
+	// MemoryHigh is the memory limit, like 7G, above which the server is
+	// throttled and its memory reclaimed.
+	MemoryHigh string `json:"memory-high,omitempty" yaml:"memory-high,omitempty"`
+	// CPUWeight is the relative share of CPU time, from 1 to 10000.
+	CPUWeight int `json:"cpu-weight,omitempty" yaml:"cpu-weight,omitempty"`
+	// PidsMax is the maximum number of processes and threads.
+	PidsMax int `json:"pids-max,omitempty" yaml:"pids-max,omitempty"`
+}
+
 const (
 	// ServerInfoFile is the file containing server information.
 	ServerInfoFile = "server.info"
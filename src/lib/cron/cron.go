@@ -0,0 +1,221 @@
+// Package cron parses cron expressions and computes their next activation.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSearch bounds the search for the next activation, which covers
+// expressions that never activate, like February 30th.
+const maxSearch = 5 * 366 * 24 * time.Hour
+
+var (
+	// macros are the supported shorthand expressions.
+	macros = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+	// monthNames maps month names to their numbers.
+	monthNames = map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}
+	// dayNames maps day names to their numbers.
+	dayNames = map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}
+)
+
+// Schedule is a parsed cron expression.
+type Schedule struct {
+	minutes, hours, days, months, weekdays uint64
+	// anyDay and anyWeekday indicate the day fields are unrestricted. When
+	// both day fields are restricted, either one matching is enough.
+	anyDay, anyWeekday bool
+}
+
+// field describes the bounds of a cron field.
+type field struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+// Parse parses a standard five field cron expression: minute, hour, day of
+// month, month and day of week. Lists, ranges, steps, month and day names,
+// and macros like @daily are supported.
+func Parse(expr string) (*Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if macro, ok := macros[strings.ToLower(expr)]; ok {
+		expr = macro
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q: expected 5 fields, got %d", expr, len(fields))
+	}
+
+	s := &Schedule{}
+	var err error
+	if s.minutes, err = parseField(fields[0], field{name: "minute", min: 0, max: 59}); err != nil {
+		return nil, err
+	}
+	if s.hours, err = parseField(fields[1], field{name: "hour", min: 0, max: 23}); err != nil {
+		return nil, err
+	}
+	if s.days, err = parseField(fields[2], field{name: "day of month", min: 1, max: 31}); err != nil {
+		return nil, err
+	}
+	if s.months, err = parseField(fields[3], field{name: "month", min: 1, max: 12, names: monthNames}); err != nil {
+		return nil, err
+	}
+	if s.weekdays, err = parseField(fields[4], field{name: "day of week", min: 0, max: 7, names: dayNames}); err != nil {
+		return nil, err
+	}
+	// Sunday is both 0 and 7.
+	if s.weekdays&(1<<7) != 0 {
+		s.weekdays |= 1
+	}
+	// Like cron, day fields starting with * are unrestricted, even with a step.
+	s.anyDay = strings.HasPrefix(fields[2], "*") || fields[2] == "?"
+	s.anyWeekday = strings.HasPrefix(fields[4], "*") || fields[4] == "?"
+	return s, nil
+}
+
+// parseField parses a single field into a bit set of the allowed values.
+func parseField(expr string, f field) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(expr, ",") {
+		rangeExpr, stepExpr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepExpr); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q in %s field", stepExpr, f.name)
+			}
+		}
+
+		var low, high int
+		switch {
+		case rangeExpr == "*" || rangeExpr == "?":
+			low, high = f.min, f.max
+		case strings.Contains(rangeExpr, "-"):
+			lowExpr, highExpr, _ := strings.Cut(rangeExpr, "-")
+			var err error
+			if low, err = parseValue(lowExpr, f); err != nil {
+				return 0, err
+			}
+			if high, err = parseValue(highExpr, f); err != nil {
+				return 0, err
+			}
+		default:
+			var err error
+			if low, err = parseValue(rangeExpr, f); err != nil {
+				return 0, err
+			}
+			high = low
+			// A single value with a step runs until the end of the range.
+			if hasStep {
+				high = f.max
+			}
+		}
+		if low > high {
+			return 0, fmt.Errorf("invalid range %q in %s field", rangeExpr, f.name)
+		}
+		for v := low; v <= high; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// parseValue parses a single number or name of a field.
+func parseValue(expr string, f field) (int, error) {
+	if v, ok := f.names[strings.ToLower(expr)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(expr)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q in %s field", expr, f.name)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("value %d out of range [%d, %d] in %s field", v, f.min, f.max, f.name)
+	}
+	return v, nil
+}
+
+// Next returns the first activation strictly after t, in the location of t.
+// The zero time is returned if the schedule never activates. Wall clock times
+// skipped when clocks are turned forward never activate, and times repeated
+// when clocks are turned back only activate the first time.
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(maxSearch)
+	for t.Before(limit) {
+		if s.months&(1<<int(t.Month())) == 0 {
+			t = after(t, time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location()))
+			continue
+		}
+		if !s.matchDay(t) {
+			t = after(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location()))
+			continue
+		}
+		if s.hours&(1<<t.Hour()) == 0 {
+			t = after(t, time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location()))
+			continue
+		}
+		if s.minutes&(1<<t.Minute()) == 0 || repeated(t) {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// after returns next if it is after t. Otherwise, the wall clock time of next
+// was skipped because clocks were turned forward, and time.Date moved it back,
+// so the end of the skipped period is returned instead.
+func after(t, next time.Time) time.Time {
+	if next.After(t) {
+		return next
+	}
+	_, end := next.ZoneBounds()
+	return end
+}
+
+// repeated returns whether the wall clock time of t already occurred before,
+// because clocks were turned back.
+func repeated(t time.Time) bool {
+	start, _ := t.ZoneBounds()
+	if start.IsZero() {
+		return false
+	}
+	_, offset := t.Zone()
+	_, prevOffset := start.Add(-time.Second).Zone()
+	shift := time.Duration(prevOffset-offset) * time.Second
+	return shift > 0 && t.Before(start.Add(shift))
+}
+
+// matchDay returns whether the day of t matches the day fields.
+func (s *Schedule) matchDay(t time.Time) bool {
+	day := s.days&(1<<t.Day()) != 0
+	weekday := s.weekdays&(1<<int(t.Weekday())) != 0
+	switch {
+	case s.anyDay && s.anyWeekday:
+		return true
+	case s.anyDay:
+		return weekday
+	case s.anyWeekday:
+		return day
+	default:
+		return day || weekday
+	}
+}
@@ -0,0 +1,171 @@
+package cron
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+	}{
+		{name: "too few fields", expr: "0 4 * *"},
+		{name: "too many fields", expr: "0 4 * * * *"},
+		{name: "minute out of range", expr: "60 4 * * *"},
+		{name: "hour out of range", expr: "0 24 * * *"},
+		{name: "day out of range", expr: "0 4 0 * *"},
+		{name: "month out of range", expr: "0 4 * 13 *"},
+		{name: "weekday out of range", expr: "0 4 * * 8"},
+		{name: "invalid step", expr: "*/0 * * * *"},
+		{name: "reversed range", expr: "0 4 * * fri-mon"},
+		{name: "unknown name", expr: "0 4 * foo *"},
+		{name: "unknown macro", expr: "@sometimes"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := Parse(tc.expr); err == nil {
+				t.Errorf("Parse(%q) succeeded, want error", tc.expr)
+			}
+		})
+	}
+}
+
+func TestNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("failed to load location: %v", err)
+	}
+	santiago, err := time.LoadLocation("America/Santiago")
+	if err != nil {
+		t.Fatalf("failed to load location: %v", err)
+	}
+	date := func(loc *time.Location, year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, loc)
+	}
+	utc := func(year int, month time.Month, day, hour, min int) time.Time {
+		return date(time.UTC, year, month, day, hour, min)
+	}
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want []time.Time
+	}{
+		{
+			name: "daily",
+			expr: "30 4 * * *",
+			from: utc(2026, time.January, 1, 5, 0),
+			want: []time.Time{utc(2026, time.January, 2, 4, 30), utc(2026, time.January, 3, 4, 30)},
+		},
+		{
+			name: "strictly after",
+			expr: "30 4 * * *",
+			from: utc(2026, time.January, 1, 4, 30),
+			want: []time.Time{utc(2026, time.January, 2, 4, 30)},
+		},
+		{
+			name: "seconds are truncated",
+			expr: "* * * * *",
+			from: utc(2026, time.January, 1, 4, 30).Add(59 * time.Second),
+			want: []time.Time{utc(2026, time.January, 1, 4, 31)},
+		},
+		{
+			name: "steps",
+			expr: "*/20 */12 * * *",
+			from: utc(2026, time.January, 1, 0, 0),
+			want: []time.Time{utc(2026, time.January, 1, 0, 20), utc(2026, time.January, 1, 0, 40), utc(2026, time.January, 1, 12, 0)},
+		},
+		{
+			name: "lists and ranges",
+			expr: "0 6,18 * * 1-5",
+			from: utc(2026, time.January, 2, 19, 0), // Friday
+			want: []time.Time{utc(2026, time.January, 5, 6, 0), utc(2026, time.January, 5, 18, 0)},
+		},
+		{
+			name: "names",
+			expr: "0 0 1 feb,MAR *",
+			from: utc(2026, time.January, 15, 0, 0),
+			want: []time.Time{utc(2026, time.February, 1, 0, 0), utc(2026, time.March, 1, 0, 0), utc(2027, time.February, 1, 0, 0)},
+		},
+		{
+			name: "sunday as 7",
+			expr: "0 12 * * 7",
+			from: utc(2026, time.January, 1, 0, 0),
+			want: []time.Time{utc(2026, time.January, 4, 12, 0)},
+		},
+		{
+			name: "day of month or day of week",
+			expr: "0 0 13 * fri",
+			from: utc(2026, time.February, 1, 0, 0),
+			want: []time.Time{utc(2026, time.February, 6, 0, 0), utc(2026, time.February, 13, 0, 0), utc(2026, time.February, 20, 0, 0)},
+		},
+		{
+			name: "macro",
+			expr: "@weekly",
+			from: utc(2026, time.January, 1, 0, 0),
+			want: []time.Time{utc(2026, time.January, 4, 0, 0), utc(2026, time.January, 11, 0, 0)},
+		},
+		{
+			name: "leap day",
+			expr: "0 0 29 2 *",
+			from: utc(2026, time.January, 1, 0, 0),
+			want: []time.Time{utc(2028, time.February, 29, 0, 0)},
+		},
+		{
+			name: "never",
+			expr: "0 0 30 2 *",
+			from: utc(2026, time.January, 1, 0, 0),
+			want: []time.Time{{}},
+		},
+		{
+			// Clocks are turned forward from 2:00 to 3:00 on March 8th.
+			name: "skipped by dst",
+			expr: "30 2 * * *",
+			from: date(newYork, 2026, time.March, 7, 12, 0),
+			want: []time.Time{date(newYork, 2026, time.March, 9, 2, 30)},
+		},
+		{
+			name: "after dst starts",
+			expr: "0 * * * *",
+			from: date(newYork, 2026, time.March, 8, 1, 30),
+			want: []time.Time{date(newYork, 2026, time.March, 8, 3, 0), date(newYork, 2026, time.March, 8, 4, 0)},
+		},
+		{
+			// Clocks are turned forward from 0:00 to 1:00 on September 6th.
+			name: "midnight skipped by dst",
+			expr: "@daily",
+			from: date(santiago, 2026, time.September, 5, 12, 0),
+			want: []time.Time{date(santiago, 2026, time.September, 7, 0, 0)},
+		},
+		{
+			// Clocks are turned back from 2:00 to 1:00 on November 1st.
+			name: "repeated by dst",
+			expr: "30 1 * * *",
+			from: date(newYork, 2026, time.October, 31, 12, 0),
+			want: []time.Time{date(newYork, 2026, time.November, 1, 1, 30), date(newYork, 2026, time.November, 2, 1, 30)},
+		},
+		{
+			name: "after dst ends",
+			expr: "0 * * * *",
+			from: date(newYork, 2026, time.November, 1, 0, 30),
+			want: []time.Time{date(newYork, 2026, time.November, 1, 1, 0), date(newYork, 2026, time.November, 1, 2, 0)},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := Parse(tc.expr)
+			if err != nil {
+				t.Fatalf("Parse(%q) failed: %v", tc.expr, err)
+			}
+			got := tc.from
+			for _, want := range tc.want {
+				got = s.Next(got)
+				if !got.Equal(want) {
+					t.Fatalf("Next() = %v, want %v", got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+//go:build linux
+
+package fileutil
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// preserveOwner gives the temporary file the owner of the file it replaces,
+// or of its directory if the file doesn't exist yet. This keeps servers that
+// run as their own user able to write their properties.
+func preserveOwner(tmp, path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		if info, err = os.Stat(filepath.Dir(path)); err != nil {
+			return err
+		}
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || (int(st.Uid) == os.Getuid() && int(st.Gid) == os.Getgid()) {
+		return nil
+	}
+	return os.Chown(tmp, int(st.Uid), int(st.Gid))
+}
@@ -0,0 +1,9 @@
+//go:build windows
+
+package fileutil
+
+// preserveOwner does nothing on Windows, where files inherit their access
+// from the directory.
+func preserveOwner(string, string) error {
+	return nil
+}
@@ -15,10 +15,6 @@ var (
 	Debug = flag.Bool("v", false, "Whether to log more than usual.")
 )
 
-func init() {
-	flag.Parse()
-}
-
 // Init initializes the loggers.
 func Init(tag string, extraLoggers ...io.Writer) error {
 	return initPlatformLogger(tag, extraLoggers)
@@ -3,6 +3,7 @@ package monitor
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -15,6 +16,11 @@ type Response struct {
 	Status int
 	// Message is the error message.
 	Message string
+	// Output is output produced by the command, if any.
+	Output string `json:",omitempty"`
+	// More indicates that this is a partial response, and more responses
+	// follow on the connection.
+	More bool `json:",omitempty"`
 }
 
 var (
@@ -58,38 +64,89 @@ func (r Response) Error() error {
 	return fmt.Errorf("Exit status %d: %s", r.Status, r.Message)
 }
 
-// SendCommand sends a command to the command socket.
+// SendCommand sends a command to the command socket, and waits for the
+// response until the server timeout elapses. Any output is discarded.
 func SendCommand(ctx context.Context, req []byte) error {
+	duration, err := time.ParseDuration(*timeoutString)
+	if err != nil {
+		return fmt.Errorf("invalid duration string %s: %v", *timeoutString, err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+	return StreamCommand(ctx, req, func(string) {})
+}
+
+// StreamCommand sends a command to the command socket, and calls fn with the
+// output of every response received until the final response. This does not
+// time out on its own, so that long-lived streams can be read.
+func StreamCommand(ctx context.Context, req []byte, fn func(output string)) error {
+	return InteractiveCommand(ctx, req, nil, fn)
+}
+
+// InteractiveCommand is like StreamCommand, but also sends every line
+// received on input to the command socket once the first response has been
+// received. The client's side of the connection is closed once input is
+// closed, which ends interactive commands.
+func InteractiveCommand(ctx context.Context, req []byte, input <-chan string, fn func(output string)) error {
 	// Connect to the command socket.
 	var dialer net.Dialer
 	conn, err := dialer.DialContext(ctx, "unix", *pipe)
 	if err != nil {
 		return fmt.Errorf("failed to dial pipe: %v", err)
 	}
+	defer conn.Close()
 
-	// Set a timeout for the connection.
-	duration, err := time.ParseDuration(*timeoutString)
-	if err != nil {
-		return fmt.Errorf("invalid duration string %s: %v", *timeoutString, err)
-	}
-	if err := conn.SetDeadline(time.Now().Add(duration)); err != nil {
-		return fmt.Errorf("failed to set deadline for connection: %v", err)
-	}
+	// Close the connection once the context is done to unblock reads.
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
 
-	// Write the request to the pipe.
+	// Write the request to the pipe, terminated by a newline.
+	req = append(req[:len(req):len(req)], '\n')
 	i, err := conn.Write(req)
 	if err != nil || i != len(req) {
 		return ConnError.Error()
 	}
 
-	// Read the response.
-	data, err := io.ReadAll(conn)
-	if err != nil {
-		return ConnError.Error()
+	// Read the responses.
+	decoder := json.NewDecoder(conn)
+	var started bool
+	for {
+		var resp Response
+		if err := decoder.Decode(&resp); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return ConnError.Error()
+			}
+			return fmt.Errorf("failed to unmarshal response: %v", err)
+		}
+		if resp.Output != "" {
+			fn(resp.Output)
+		}
+		if !resp.More {
+			return resp.Error()
+		}
+
+		// The server is ready for input once it has responded.
+		if input != nil && !started {
+			started = true
+			go writeInput(conn, input)
+		}
+	}
+}
+
+// writeInput writes every line from input to the connection, and closes the
+// writing side of the connection once input is closed.
+func writeInput(conn net.Conn, input <-chan string) {
+	for line := range input {
+		if _, err := io.WriteString(conn, line+"\n"); err != nil {
+			return
+		}
 	}
-	var resp Response
-	if err := json.Unmarshal(data, &resp); err != nil {
-		return fmt.Errorf("failed to unmarshal response: %v", err)
+	if unixConn, ok := conn.(*net.UnixConn); ok {
+		unixConn.CloseWrite()
 	}
-	return resp.Error()
 }
@@ -2,6 +2,8 @@
 package monitor
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -11,6 +13,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/dranilew/minecraft-server-manager/src/lib/backup"
@@ -42,10 +45,6 @@ type Monitor struct {
 	srv *Server
 }
 
-func init() {
-	flag.Parse()
-}
-
 // Setup starts an internally managed command server.
 func Setup(ctx context.Context) error {
 	timeout, err := time.ParseDuration(*timeoutString)
@@ -73,12 +72,11 @@ func Close(context.Context) {
 	}
 }
 
-// readFromConn reads data from a connection.
-func readFromConn(conn net.Conn) ([]byte, bool) {
-	b := make([]byte, 1024)
-	n, err := conn.Read(b)
+// readFromConn reads a newline-terminated request from a connection.
+func readFromConn(conn net.Conn, r *bufio.Reader) ([]byte, bool) {
+	b, err := r.ReadBytes('\n')
 	if err == nil {
-		return b[:n], true
+		return bytes.TrimSuffix(b, []byte("\n")), true
 	}
 	if errors.Is(err, os.ErrDeadlineExceeded) {
 		if e, err := json.Marshal(TimeoutError); err == nil {
@@ -145,17 +143,16 @@ func (s *Server) start(ctx context.Context) error {
 					return
 				}
 
-				message, ok := readFromConn(conn)
+				reader := bufio.NewReader(conn)
+				message, ok := readFromConn(conn, reader)
 				if !ok {
 					return
 				}
 				logger.Printf("Received command request: %s", string(message))
-				exeErr := NewExecutionError(handleMessage(message))
-				b, err := json.Marshal(exeErr)
-				if err != nil {
-					logger.Printf("Failed to marshal execution error: %v", err)
-				}
-				if n, err := conn.Write(b); err != nil || n != len(b) {
+				w := &ResponseWriter{conn: conn, reader: reader, timeout: s.timeout, done: make(chan struct{})}
+				exeErr := NewExecutionError(handleMessage(message, w))
+				close(w.done)
+				if err := w.write(exeErr); err != nil {
 					logger.Printf("Failed to write to connection on pipe %q: %v", s.pipe, err)
 				}
 			}(conn)
@@ -164,6 +161,80 @@ func (s *Server) start(ctx context.Context) error {
 	return nil
 }
 
+// ResponseWriter writes partial responses to the client of a request.
+type ResponseWriter struct {
+	conn net.Conn
+	// reader reads the request and the input that follows it.
+	reader  *bufio.Reader
+	timeout time.Duration
+	mu      sync.Mutex
+	input   chan string
+	// done is closed once the handler of the request has returned.
+	done chan struct{}
+	// longRunning indicates that the connection has no overall deadline.
+	longRunning bool
+}
+
+// write marshals and writes the response to the connection.
+func (w *ResponseWriter) write(resp Response) error {
+	b, err := json.Marshal(resp)
+	if err != nil {
+		return fmt.Errorf("failed to marshal response: %v", err)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.longRunning {
+		// Long-running responses have no overall deadline, so only bound
+		// each write.
+		w.conn.SetWriteDeadline(time.Now().Add(w.timeout))
+	}
+	if n, err := w.conn.Write(b); err != nil || n != len(b) {
+		return fmt.Errorf("failed to write response: %v", err)
+	}
+	return nil
+}
+
+// Write sends the output to the client as a partial response.
+func (w *ResponseWriter) Write(output string) error {
+	return w.write(Response{Output: output, More: true})
+}
+
+// LongRunning removes the connection deadline for handlers that may take
+// longer than the server timeout. Each write is still bounded by the timeout.
+func (w *ResponseWriter) LongRunning() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.longRunning = true
+	w.conn.SetDeadline(time.Time{})
+}
+
+// Stream prepares the connection for a long-lived response by removing the
+// connection deadline. The returned channel receives every line sent by the
+// client after the request, and is closed once the client disconnects or
+// closes its side of the connection, or the handler returns.
+func (w *ResponseWriter) Stream() <-chan string {
+	w.LongRunning()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.input != nil {
+		return w.input
+	}
+	w.input = make(chan string)
+	go func() {
+		defer close(w.input)
+		scanner := bufio.NewScanner(w.reader)
+		for scanner.Scan() {
+			// Nobody reads the input once the handler has returned.
+			select {
+			case w.input <- scanner.Text():
+			case <-w.done:
+				return
+			}
+		}
+	}()
+	return w.input
+}
+
 // Close signals the server to stop listening for commands and stop waiting on listen.
 func (s *Server) close() error {
 	if s.srv != nil {
@@ -172,25 +243,151 @@ func (s *Server) close() error {
 	return nil
 }
 
-// handleMessage handles the request received from the connection.
-func handleMessage(req []byte) error {
+// handleMessage handles the request received from the connection. Partial
+// responses are written to w.
+func handleMessage(req []byte, w *ResponseWriter) error {
 	ctx := context.Background()
 	reqString := string(req)
 
 	command, args, _ := strings.Cut(reqString, " ")
 	switch command {
 	case "server":
-		fields := strings.Split(args, " ")
-		switch fields[0] {
+		subcommand, args, _ := strings.Cut(args, " ")
+		switch subcommand {
 		case "stop":
-			return server.Stop(ctx, fields[1:]...)
+			var stopReq server.StopRequest
+			if err := json.Unmarshal([]byte(args), &stopReq); err != nil {
+				return fmt.Errorf("failed to unmarshal stop request: %v", err)
+			}
+			// The countdown and stop timeout may outlast the server timeout.
+			w.LongRunning()
+			return server.Stop(ctx, stopReq)
 		case "start":
-			return server.Start(ctx, fields[1:]...)
+			return server.Start(ctx, strings.Fields(args)...)
+		case "register":
+			return server.Register(strings.Fields(args)...)
+		case "unregister":
+			return server.Unregister(ctx, strings.Fields(args)...)
+		case "archive":
+			var archiveReq server.ArchiveRequest
+			if err := json.Unmarshal([]byte(args), &archiveReq); err != nil {
+				return fmt.Errorf("failed to unmarshal archive request: %v", err)
+			}
+			w.LongRunning()
+			return server.Archive(ctx, archiveReq, func(ctx context.Context, srv string) error {
+				return backup.Create(ctx, backup.CreateRequest{Force: true, Bucket: archiveReq.Bucket, Servers: []string{srv}})
+			}, w.Write)
+		case "reset-failed":
+			return server.ResetFailed(strings.Fields(args)...)
 		case "restart":
-			return server.Restart(ctx, fields[1:]...)
+			var restartReq server.StopRequest
+			if err := json.Unmarshal([]byte(args), &restartReq); err != nil {
+				return fmt.Errorf("failed to unmarshal restart request: %v", err)
+			}
+			w.LongRunning()
+			return server.Restart(ctx, restartReq)
+		case "stop-policy":
+			var policyReq server.StopPolicyRequest
+			if err := json.Unmarshal([]byte(args), &policyReq); err != nil {
+				return fmt.Errorf("failed to unmarshal stop policy request: %v", err)
+			}
+			return server.SetStopPolicy(policyReq)
+		case "logs":
+			var logsReq server.LogsRequest
+			if err := json.Unmarshal([]byte(args), &logsReq); err != nil {
+				return fmt.Errorf("failed to unmarshal logs request: %v", err)
+			}
+			return handleLogs(ctx, logsReq, w)
+		case "exec":
+			var execReq server.ExecRequest
+			if err := json.Unmarshal([]byte(args), &execReq); err != nil {
+				return fmt.Errorf("failed to unmarshal exec request: %v", err)
+			}
+			return handleExec(ctx, execReq, w)
+		case "tag":
+			var tagReq server.TagRequest
+			if err := json.Unmarshal([]byte(args), &tagReq); err != nil {
+				return fmt.Errorf("failed to unmarshal tag request: %v", err)
+			}
+			return server.Tag(tagReq)
+		case "config":
+			var configReq server.ConfigRequest
+			if err := json.Unmarshal([]byte(args), &configReq); err != nil {
+				return fmt.Errorf("failed to unmarshal config request: %v", err)
+			}
+			// Restarting to apply a change may outlast the server timeout.
+			w.LongRunning()
+			return server.Configure(ctx, configReq, w.Write)
+		case "create":
+			var createReq server.CreateRequest
+			if err := json.Unmarshal([]byte(args), &createReq); err != nil {
+				return fmt.Errorf("failed to unmarshal create request: %v", err)
+			}
+			// Installers may run for much longer than the server timeout.
+			w.LongRunning()
+			return server.Create(ctx, createReq, w.Write)
+		case "clone":
+			var cloneReq server.CloneRequest
+			if err := json.Unmarshal([]byte(args), &cloneReq); err != nil {
+				return fmt.Errorf("failed to unmarshal clone request: %v", err)
+			}
+			w.LongRunning()
+			return server.Clone(ctx, cloneReq, backup.Fetch, w.Write)
+		case "jvm":
+			var jvmReq server.JVMRequest
+			if err := json.Unmarshal([]byte(args), &jvmReq); err != nil {
+				return fmt.Errorf("failed to unmarshal jvm request: %v", err)
+			}
+			w.LongRunning()
+			return server.SetJVM(ctx, jvmReq, w.Write)
+		case "limits":
+			var limitsReq server.LimitsRequest
+			if err := json.Unmarshal([]byte(args), &limitsReq); err != nil {
+				return fmt.Errorf("failed to unmarshal limits request: %v", err)
+			}
+			return server.SetLimits(ctx, limitsReq)
+		case "user":
+			var userReq server.UserRequest
+			if err := json.Unmarshal([]byte(args), &userReq); err != nil {
+				return fmt.Errorf("failed to unmarshal user request: %v", err)
+			}
+			w.LongRunning()
+			return server.SetUser(ctx, userReq, w.Write)
+		case "maintenance":
+			var maintenanceReq server.MaintenanceRequest
+			if err := json.Unmarshal([]byte(args), &maintenanceReq); err != nil {
+				return fmt.Errorf("failed to unmarshal maintenance request: %v", err)
+			}
+			w.LongRunning()
+			return server.SetMaintenance(ctx, maintenanceReq, w.Write)
+		case "set-port":
+			var portsReq server.SetPortsRequest
+			if err := json.Unmarshal([]byte(args), &portsReq); err != nil {
+				return fmt.Errorf("failed to unmarshal set-port request: %v", err)
+			}
+			return server.SetPorts(ctx, portsReq)
+		case "schedule":
+			var scheduleReq server.ScheduleRequest
+			if err := json.Unmarshal([]byte(args), &scheduleReq); err != nil {
+				return fmt.Errorf("failed to unmarshal schedule request: %v", err)
+			}
+			return server.SetRestartSchedule(scheduleReq)
+		case "console":
+			var consoleReq server.ConsoleRequest
+			if err := json.Unmarshal([]byte(args), &consoleReq); err != nil {
+				return fmt.Errorf("failed to unmarshal console request: %v", err)
+			}
+			return handleConsole(ctx, consoleReq, w)
 		default:
-			return fmt.Errorf("unknown server request: %v", fields[0])
+			return fmt.Errorf("unknown server request: %v", subcommand)
+		}
+	case "player":
+		var playerReq server.PlayerRequest
+		if err := json.Unmarshal([]byte(args), &playerReq); err != nil {
+			return fmt.Errorf("failed to unmarshal player request: %v", err)
 		}
+		w.LongRunning()
+		return server.ManagePlayers(ctx, playerReq, w.Write)
 	case "backup":
 		var createReq backup.CreateRequest
 		if err := json.Unmarshal([]byte(args), &createReq); err != nil {
@@ -201,3 +398,66 @@ func handleMessage(req []byte) error {
 		return fmt.Errorf("unknown request: %v", command)
 	}
 }
+
+// handleLogs streams the requested logs to the client.
+func handleLogs(ctx context.Context, req server.LogsRequest, w *ResponseWriter) error {
+	if req.Follow {
+		// Stop following once the client disconnects.
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		input := w.Stream()
+		go func() {
+			for range input {
+			}
+			cancel()
+		}()
+	}
+	return server.Logs(ctx, req, w.Write)
+}
+
+// handleConsole proxies an interactive console session for the client. Log
+// lines are streamed to the client, and every line received from the client
+// is run as a console command. The session ends once the client detaches.
+func handleConsole(ctx context.Context, req server.ConsoleRequest, w *ResponseWriter) error {
+	session, err := server.Attach(ctx, req, w.Write)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	for line := range w.Stream() {
+		if err := session.Send(line); err != nil {
+			if err := w.Write(err.Error()); err != nil {
+				return nil
+			}
+		}
+	}
+	logger.Printf("Detached from console of server %q", req.Server)
+	return nil
+}
+
+// handleExec runs the console command, and writes the result of every server
+// to the client as JSON.
+func handleExec(ctx context.Context, req server.ExecRequest, w *ResponseWriter) error {
+	results, err := server.Exec(ctx, req)
+	if err != nil {
+		return err
+	}
+	var failed int
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+		}
+		b, err := json.Marshal(result)
+		if err != nil {
+			return fmt.Errorf("failed to marshal result: %v", err)
+		}
+		if err := w.Write(string(b)); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("command failed on %d of %d servers", failed, len(results))
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+// Package rcon is a client for the Source RCON protocol used by minecraft
+// servers to accept remote console commands.
+package rcon
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/server/properties"
+)
+
+const (
+	// packetTypeResponse is the type of a command response packet.
+	packetTypeResponse = 0
+	// packetTypeCommand is the type of a command packet.
+	packetTypeCommand = 2
+	// packetTypeLogin is the type of a login packet.
+	packetTypeLogin = 3
+	// authFailedID is the request ID returned by the server on a failed login.
+	authFailedID = -1
+	// maxPacketSize is the largest packet accepted from the server.
+	maxPacketSize = 4110
+	// defaultPort is the default RCON port used by minecraft.
+	defaultPort = 25575
+	// defaultTimeout is the timeout for a single command round trip.
+	defaultTimeout = 10 * time.Second
+)
+
+var (
+	// ErrDisabled is returned when RCON is not enabled for the server.
+	ErrDisabled = errors.New("rcon is not enabled")
+	// ErrAuth is returned when the server rejects the RCON password.
+	ErrAuth = errors.New("rcon authentication failed")
+)
+
+// Config is the RCON configuration of a server.
+type Config struct {
+	// Enabled indicates whether RCON is enabled.
+	Enabled bool
+	// Port is the RCON port.
+	Port int
+	// Password is the RCON password.
+	Password string
+}
+
+// Address returns the local address on which RCON is listening.
+func (c *Config) Address() string {
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(c.Port))
+}
+
+// LoadConfig reads the RCON configuration from the server.properties file in
+// the given server directory.
+func LoadConfig(serverDir string) (*Config, error) {
+	props, err := properties.Load(filepath.Join(serverDir, properties.FileName))
+	if err != nil {
+		return nil, err
+	}
+	conf := &Config{Port: defaultPort}
+	if enabled, _ := props.Get("enable-rcon"); enabled == "true" {
+		conf.Enabled = true
+	}
+	if value, ok := props.Get("rcon.port"); ok && value != "" {
+		port, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid rcon.port %q: %v", value, err)
+		}
+		conf.Port = port
+	}
+	conf.Password, _ = props.Get("rcon.password")
+
+	// Minecraft does not start RCON without a password.
+	if conf.Password == "" {
+		conf.Enabled = false
+	}
+	return conf, nil
+}
+
+// Client is a connection to an RCON server. It is safe for concurrent use.
+type Client struct {
+	mu     sync.Mutex
+	conn   net.Conn
+	nextID int32
+}
+
+// Dial connects and authenticates to the RCON server in the configuration.
+func Dial(ctx context.Context, conf *Config) (*Client, error) {
+	if !conf.Enabled {
+		return nil, ErrDisabled
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", conf.Address())
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial rcon: %w", err)
+	}
+	c := &Client{conn: conn, nextID: 1}
+	if err := c.login(ctx, conf.Password); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// login authenticates the connection.
+func (c *Client) login(ctx context.Context, password string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setDeadline(ctx)
+
+	id := c.id()
+	if err := writePacket(c.conn, id, packetTypeLogin, password); err != nil {
+		return err
+	}
+	respID, _, _, err := readPacket(c.conn)
+	if err != nil {
+		return err
+	}
+	if respID == authFailedID || respID != id {
+		return ErrAuth
+	}
+	return nil
+}
+
+// Command runs the command on the server and returns its output.
+func (c *Client) Command(ctx context.Context, command string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setDeadline(ctx)
+
+	// Responses longer than a single packet are split without any marker, so
+	// send an invalid packet after the command. Its response marks the end
+	// of the command output.
+	id := c.id()
+	endID := c.id()
+	if err := writePacket(c.conn, id, packetTypeCommand, command); err != nil {
+		return "", err
+	}
+	if err := writePacket(c.conn, endID, packetTypeResponse, ""); err != nil {
+		return "", err
+	}
+
+	var output strings.Builder
+	var responded bool
+	for {
+		respID, _, body, err := readPacket(c.conn)
+		if err != nil {
+			// Commands like stop close the connection right after responding.
+			if responded {
+				return output.String(), nil
+			}
+			return "", err
+		}
+		switch respID {
+		case id:
+			responded = true
+			output.WriteString(body)
+		case endID:
+			return output.String(), nil
+		case authFailedID:
+			return "", ErrAuth
+		}
+	}
+}
+
+// id returns the next request ID.
+func (c *Client) id() int32 {
+	id := c.nextID
+	c.nextID++
+	return id
+}
+
+// setDeadline sets the connection deadline from the context, or the default
+// timeout if the context has no deadline.
+func (c *Client) setDeadline(ctx context.Context) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultTimeout)
+	}
+	c.conn.SetDeadline(deadline)
+}
+
+// writePacket writes a single packet to the writer.
+func writePacket(w io.Writer, id int32, packetType int32, body string) error {
+	var buf bytes.Buffer
+	// Length excludes the length field itself, and includes the two
+	// terminating null bytes.
+	binary.Write(&buf, binary.LittleEndian, int32(4+4+len(body)+2))
+	binary.Write(&buf, binary.LittleEndian, id)
+	binary.Write(&buf, binary.LittleEndian, packetType)
+	buf.WriteString(body)
+	buf.Write([]byte{0, 0})
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write rcon packet: %w", err)
+	}
+	return nil
+}
+
+// readPacket reads a single packet from the reader.
+func readPacket(r io.Reader) (int32, int32, string, error) {
+	var length int32
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return 0, 0, "", fmt.Errorf("failed to read rcon packet: %w", err)
+	}
+	if length < 10 || length > maxPacketSize {
+		return 0, 0, "", fmt.Errorf("invalid rcon packet length %d", length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, 0, "", fmt.Errorf("failed to read rcon packet: %w", err)
+	}
+	id := int32(binary.LittleEndian.Uint32(payload[0:4]))
+	packetType := int32(binary.LittleEndian.Uint32(payload[4:8]))
+	body := bytes.TrimRight(payload[8:], "\x00")
+	return id, packetType, string(body), nil
+}
+
+// Command is a convenience function that connects to the RCON server of the
+// server in the given directory, runs a single command and disconnects.
+// ErrDisabled is returned if RCON is not enabled for the server.
+func Command(ctx context.Context, serverDir, command string) (string, error) {
+	conf, err := LoadConfig(serverDir)
+	if err != nil {
+		return "", err
+	}
+	client, err := Dial(ctx, conf)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+	return client.Command(ctx, command)
+}
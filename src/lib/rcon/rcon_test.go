@@ -0,0 +1,157 @@
+package rcon
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeServer accepts a single RCON connection, and serves it with handle.
+func fakeServer(t *testing.T, handle func(conn net.Conn)) *Config {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() failed: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		handle(conn)
+	}()
+	return &Config{Enabled: true, Port: l.Addr().(*net.TCPAddr).Port, Password: "secret"}
+}
+
+// acceptLogin reads the login packet, and accepts it if the password matches.
+func acceptLogin(t *testing.T, conn net.Conn) {
+	t.Helper()
+	id, packetType, body, err := readPacket(conn)
+	if err != nil {
+		t.Errorf("failed to read login packet: %v", err)
+		return
+	}
+	if packetType != packetTypeLogin {
+		t.Errorf("login packet type = %d, want %d", packetType, packetTypeLogin)
+	}
+	if body != "secret" {
+		id = authFailedID
+	}
+	writePacket(conn, id, packetTypeCommand, "")
+}
+
+func TestCommand(t *testing.T) {
+	conf := fakeServer(t, func(conn net.Conn) {
+		acceptLogin(t, conn)
+		id, packetType, body, err := readPacket(conn)
+		if err != nil {
+			t.Errorf("failed to read command packet: %v", err)
+			return
+		}
+		if packetType != packetTypeCommand || body != "list" {
+			t.Errorf("command packet = (%d, %q), want (%d, %q)", packetType, body, packetTypeCommand, "list")
+		}
+		endID, _, _, err := readPacket(conn)
+		if err != nil {
+			t.Errorf("failed to read end packet: %v", err)
+			return
+		}
+
+		// Split the output over several packets, and the first packet over
+		// several writes.
+		var first bytes.Buffer
+		writePacket(&first, id, packetTypeResponse, "There are 2 of a max of 20 players online: ")
+		conn.Write(first.Bytes()[:5])
+		conn.Write(first.Bytes()[5:])
+		writePacket(conn, id, packetTypeResponse, "Alice, Bob")
+		writePacket(conn, endID, packetTypeResponse, "Unknown request 0")
+	})
+
+	ctx := context.Background()
+	client, err := Dial(ctx, conf)
+	if err != nil {
+		t.Fatalf("Dial() failed: %v", err)
+	}
+	defer client.Close()
+	output, err := client.Command(ctx, "list")
+	if err != nil {
+		t.Fatalf("Command() failed: %v", err)
+	}
+	if want := "There are 2 of a max of 20 players online: Alice, Bob"; output != want {
+		t.Errorf("Command() = %q, want %q", output, want)
+	}
+}
+
+func TestDialAuthFailure(t *testing.T) {
+	conf := fakeServer(t, func(conn net.Conn) {
+		acceptLogin(t, conn)
+	})
+	conf.Password = "wrong"
+	if _, err := Dial(context.Background(), conf); !errors.Is(err, ErrAuth) {
+		t.Errorf("Dial() = %v, want %v", err, ErrAuth)
+	}
+}
+
+func TestDialDisabled(t *testing.T) {
+	if _, err := Dial(context.Background(), &Config{Port: defaultPort}); !errors.Is(err, ErrDisabled) {
+		t.Errorf("Dial() = %v, want %v", err, ErrDisabled)
+	}
+}
+
+func TestDialUnreachable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() failed: %v", err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	conf := &Config{Enabled: true, Port: port, Password: "secret"}
+	if _, err := Dial(context.Background(), conf); err == nil {
+		t.Errorf("Dial() to a closed port succeeded")
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name  string
+		props string
+		want  Config
+	}{
+		{
+			name:  "enabled",
+			props: "enable-rcon=true\nrcon.port=25600\nrcon.password=secret\n",
+			want:  Config{Enabled: true, Port: 25600, Password: "secret"},
+		},
+		{
+			name:  "disabled",
+			props: "enable-rcon=false\nrcon.password=secret\n",
+			want:  Config{Port: defaultPort, Password: "secret"},
+		},
+		{
+			name:  "no password",
+			props: "enable-rcon=true\n",
+			want:  Config{Port: defaultPort},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "server.properties"), []byte(tc.props), 0644); err != nil {
+				t.Fatalf("failed to write server.properties: %v", err)
+			}
+			conf, err := LoadConfig(dir)
+			if err != nil {
+				t.Fatalf("LoadConfig() failed: %v", err)
+			}
+			if *conf != tc.want {
+				t.Errorf("LoadConfig() = %+v, want %+v", *conf, tc.want)
+			}
+		})
+	}
+}
@@ -78,6 +78,19 @@ type Options struct {
 	// InheritEnv specifies whether to inherit the environment of the parent
 	// process. If not specified the exec.Command's default behavior is honored.
 	InheritEnv bool
+	// Credential specifies the user and groups to run the command as. If not
+	// specified the command runs as the current user.
+	Credential *Credential
+}
+
+// Credential represents the user and groups a command runs as.
+type Credential struct {
+	// UID is the user ID.
+	UID uint32
+	// GID is the primary group ID.
+	GID uint32
+	// Groups are the supplementary group IDs.
+	Groups []uint32
 }
 
 // ExecMode represents the command execution mode: i.e. blocking, non-blocking,
@@ -184,6 +197,9 @@ func streamOutput(ctx context.Context, opts Options) (*Result, error) {
 	if opts.InheritEnv {
 		cmd.Env = os.Environ()
 	}
+	if err := setCredential(cmd, opts.Credential); err != nil {
+		return nil, err
+	}
 
 	if err := cmd.Start(); err != nil {
 		return nil, fmt.Errorf("unable to start command: %w", err)
@@ -246,6 +262,9 @@ func splitOutput(ctx context.Context, opts Options) (*Result, error) {
 	if opts.InheritEnv {
 		cmd.Env = os.Environ()
 	}
+	if err := setCredential(cmd, opts.Credential); err != nil {
+		return nil, err
+	}
 
 	if err := cmd.Run(); err != nil {
 		return nil, errorWithOutput(err, stderr.String())
@@ -267,6 +286,9 @@ func combinedOutput(ctx context.Context, opts Options) (*Result, error) {
 	if opts.InheritEnv {
 		cmd.Env = os.Environ()
 	}
+	if err := setCredential(cmd, opts.Credential); err != nil {
+		return nil, err
+	}
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return nil, errorWithOutput(err, string(output))
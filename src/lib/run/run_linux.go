@@ -33,6 +33,9 @@ func start(ctx context.Context, opts Options) (*Result, error) {
 	if opts.InheritEnv {
 		cmd.Env = os.Environ()
 	}
+	if err := setCredential(cmd, opts.Credential); err != nil {
+		return nil, err
+	}
 
 	if err := writeToStdin(cmd, opts.Input); err != nil {
 		return nil, fmt.Errorf("failed to write input in start: %v", err)
@@ -44,3 +47,16 @@ func start(ctx context.Context, opts Options) (*Result, error) {
 
 	return &Result{OutputType: OutputNone, Pid: cmd.Process.Pid}, nil
 }
+
+// setCredential makes the command run as the user and groups of the
+// credential, if any.
+func setCredential(cmd *exec.Cmd, cred *Credential) error {
+	if cred == nil {
+		return nil
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{Uid: cred.UID, Gid: cred.GID, Groups: cred.Groups}
+	return nil
+}
@@ -25,6 +25,9 @@ func start(ctx context.Context, opts Options) (*Result, error) {
 	if opts.InheritEnv {
 		cmd.Env = os.Environ()
 	}
+	if err := setCredential(cmd, opts.Credential); err != nil {
+		return nil, err
+	}
 
 	if err := writeToStdin(cmd, ""); err != nil {
 		return nil, fmt.Errorf("failed to write input in start: %v", err)
@@ -36,3 +39,12 @@ func start(ctx context.Context, opts Options) (*Result, error) {
 
 	return &Result{OutputType: OutputNone, Pid: cmd.Process.Pid}, nil
 }
+
+// setCredential fails if a credential is given, as running commands as other
+// users is not supported on Windows.
+func setCredential(_ *exec.Cmd, cred *Credential) error {
+	if cred != nil {
+		return fmt.Errorf("running commands as another user is not implemented on windows")
+	}
+	return nil
+}
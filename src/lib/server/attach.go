@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/common"
+	"github.com/dranilew/minecraft-server-manager/src/lib/logger"
+)
+
+// ConsoleRequest is a request to attach to the console of a server.
+type ConsoleRequest struct {
+	// Server is the server whose console to attach to.
+	Server string
+	// History is the number of previous log lines to show when attaching.
+	History int
+}
+
+// ConsoleSession is an interactive session attached to the console of a
+// server. Detaching from the session does not affect the server.
+type ConsoleSession struct {
+	server string
+	out    func(string) error
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// Attach attaches to the console of the running server. The most recent log
+// lines, followed by every new log line, are passed to out until the session
+// is closed.
+func Attach(ctx context.Context, req ConsoleRequest, out func(string) error) (*ConsoleSession, error) {
+	runningServers, err := GetRunningServers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get running servers: %v", err)
+	}
+	if !slices.Contains(runningServers, req.Server) {
+		return nil, fmt.Errorf("server %q is not running", req.Server)
+	}
+
+	path := filepath.Join(common.ServerDirectory(req.Server), logsDir, latestLog)
+	history, offset, err := lastLines(path, req.History)
+	if err != nil {
+		return nil, err
+	}
+	if err := out(fmt.Sprintf("Attached to the console of %q. Press Ctrl-D to detach.", req.Server)); err != nil {
+		return nil, err
+	}
+	for _, line := range history {
+		if err := out(line); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	s := &ConsoleSession{server: req.Server, out: out, cancel: cancel}
+	s.wg.Go(func() {
+		if err := FollowLog(ctx, path, offset, out); err != nil {
+			logger.Debugf("Stopped following console of %q: %v", req.Server, err)
+		}
+	})
+	logger.Printf("Attached to console of server %q", req.Server)
+	return s, nil
+}
+
+// Send runs the command on the server's console. Command output is only
+// available when the command is sent over RCON, as it is not logged.
+func (s *ConsoleSession) Send(command string) error {
+	if command == "" {
+		return nil
+	}
+	output, err := RunCommand(context.Background(), s.server, command)
+	if err != nil {
+		return err
+	}
+	if output != "" {
+		return s.out(output)
+	}
+	return nil
+}
+
+// Close detaches from the console.
+func (s *ConsoleSession) Close() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// lastLines returns the last n complete lines of the file, and the offset
+// after the last complete line. A missing file has no lines.
+func lastLines(path string, n int) ([]string, int64, error) {
+	var lines []string
+	offset, err := readLog(path, 0, func(line string) error {
+		if n <= 0 {
+			return nil
+		}
+		if len(lines) == n {
+			lines = lines[1:]
+		}
+		lines = append(lines, line)
+		return nil
+	})
+	if err != nil {
+		if _, statErr := os.Stat(path); os.IsNotExist(statErr) {
+			return nil, 0, nil
+		}
+		return nil, 0, err
+	}
+	return lines, offset, nil
+}
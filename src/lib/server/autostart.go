@@ -0,0 +1,208 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/common"
+	"github.com/dranilew/minecraft-server-manager/src/lib/logger"
+)
+
+var (
+	// bootPollInterval is the interval at which servers started when the
+	// manager starts are checked for readiness.
+	bootPollInterval = time.Second
+	// maxConcurrentStarts is the maximum number of servers started at once
+	// when the manager starts.
+	maxConcurrentStarts = flag.Int("max-concurrent-starts", 1, "Maximum number of servers starting at once when the manager starts. The next server is started once a starting server is ready. 0 starts all servers at once.")
+	// bootStartTimeout is the time to wait for a server started when the
+	// manager starts to become ready.
+	bootStartTimeout = flag.Duration("boot-start-timeout", 10*time.Minute, "Time to wait for a server started when the manager starts to become ready, before starting the servers waiting on it anyway.")
+
+	// booting are the servers waiting to be started by Autostart.
+	booting   = make(map[string]bool)
+	bootingMu sync.Mutex
+)
+
+// bootServer is a server started when the manager starts.
+type bootServer struct {
+	// name is the name of the server.
+	name string
+	// priority orders the servers that can be started.
+	priority int
+	// dependsOn are the servers that must be ready first.
+	dependsOn []string
+}
+
+// Booting returns whether the server is waiting to be started by Autostart.
+// The manager doesn't recover these servers in the meantime.
+func Booting(server string) bool {
+	bootingMu.Lock()
+	defer bootingMu.Unlock()
+	return booting[server]
+}
+
+// Autostart starts the servers that should run when the manager starts. These
+// are the servers with autostart enabled in their manifest, and the servers
+// that were running unless autostart is disabled. Servers are started by
+// priority after the servers they depend on are ready, with at most
+// --max-concurrent-starts starting at once. The servers are started in the
+// background, and reported by Booting until they are.
+func Autostart(ctx context.Context) error {
+	runningServers, err := GetRunningServers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get running servers: %v", err)
+	}
+	var boot []bootServer
+	var changed bool
+	common.ServerStatusesMu.Lock()
+	for server, status := range common.ServerStatuses {
+		if slices.Contains(runningServers, server) {
+			continue
+		}
+		m := manifestOf(server)
+		if m == nil {
+			m = &Manifest{}
+		}
+		switch {
+		case m.Autostart != nil && !*m.Autostart:
+			if status.ShouldRun {
+				logger.Printf("Autostart of server %q is disabled, keeping it stopped", server)
+				status.ShouldRun = false
+				status.NextRetry = time.Time{}
+				status.SetState(common.StateStopped)
+				changed = true
+			}
+			continue
+		case m.Autostart == nil && (!status.ShouldRun || status.State == common.StateFailed || status.State == common.StateSleeping):
+			continue
+		}
+		boot = append(boot, bootServer{name: server, priority: m.Priority, dependsOn: m.DependsOn})
+	}
+	common.ServerStatusesMu.Unlock()
+	if changed {
+		if err := common.UpdateServerStatus(); err != nil {
+			return fmt.Errorf("failed to update server status: %v", err)
+		}
+	}
+	if len(boot) == 0 {
+		return nil
+	}
+
+	bootingMu.Lock()
+	for _, b := range boot {
+		booting[b.name] = true
+	}
+	bootingMu.Unlock()
+	go startInOrder(ctx, boot)
+	return nil
+}
+
+// startInOrder starts the servers by priority once the servers they depend on
+// are ready, keeping at most --max-concurrent-starts starting at once.
+func startInOrder(ctx context.Context, boot []bootServer) {
+	pending := make(map[string]bootServer)
+	for _, b := range boot {
+		pending[b.name] = b
+	}
+	starting := make(map[string]time.Time)
+	ticker := time.NewTicker(bootPollInterval)
+	defer ticker.Stop()
+	for {
+		for server, since := range starting {
+			if bootDone(server, since) {
+				delete(starting, server)
+			}
+		}
+
+		// Servers can start once none of their dependencies are left to start.
+		var next []bootServer
+		for _, b := range pending {
+			if !slices.ContainsFunc(b.dependsOn, func(dep string) bool {
+				_, isPending := pending[dep]
+				_, isStarting := starting[dep]
+				return isPending || isStarting
+			}) {
+				next = append(next, b)
+			}
+		}
+		slices.SortFunc(next, func(a, b bootServer) int {
+			return cmp.Or(cmp.Compare(b.priority, a.priority), cmp.Compare(a.name, b.name))
+		})
+		if len(next) == 0 && len(starting) == 0 && len(pending) > 0 {
+			// Only servers that depend on each other are left.
+			next = slices.SortedFunc(maps.Values(pending), func(a, b bootServer) int {
+				return cmp.Or(cmp.Compare(b.priority, a.priority), cmp.Compare(a.name, b.name))
+			})[:1]
+			logger.Printf("Servers %v depend on each other, starting %q first", slices.Sorted(maps.Keys(pending)), next[0].name)
+		}
+
+		for _, b := range next {
+			if *maxConcurrentStarts > 0 && len(starting) >= *maxConcurrentStarts {
+				break
+			}
+			delete(pending, b.name)
+			bootingMu.Lock()
+			delete(booting, b.name)
+			bootingMu.Unlock()
+			common.ServerStatusesMu.Lock()
+			_, registered := common.ServerStatuses[b.name]
+			common.ServerStatusesMu.Unlock()
+			if !registered {
+				continue
+			}
+			logger.Printf("Autostarting server %q", b.name)
+			if err := Start(ctx, b.name); err != nil {
+				logger.Printf("Failed to autostart server %q: %v", b.name, err)
+				continue
+			}
+			starting[b.name] = time.Now()
+		}
+
+		if len(pending) == 0 && len(starting) == 0 {
+			logger.Printf("Finished autostarting servers")
+			return
+		}
+		select {
+		case <-ctx.Done():
+			bootingMu.Lock()
+			clear(booting)
+			bootingMu.Unlock()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// bootDone returns whether the server started at the given time no longer
+// holds back the servers waiting on it. This is the case once it is ready, it
+// stopped or failed, or it didn't become ready in time.
+func bootDone(server string, since time.Time) bool {
+	common.ServerStatusesMu.Lock()
+	status, ok := common.ServerStatuses[server]
+	var state common.State
+	if ok {
+		state = status.State
+	}
+	common.ServerStatusesMu.Unlock()
+	switch {
+	case !ok:
+		return true
+	case state == common.StateRunning:
+		logger.Printf("Server %q is ready after %s", server, formatDuration(time.Since(since).Round(time.Second)))
+		return true
+	case state == common.StateStopped || state == common.StateFailed:
+		logger.Printf("Server %q is %s, starting the servers waiting on it", server, state)
+		return true
+	case time.Since(since) > *bootStartTimeout:
+		logger.Printf("Server %q did not become ready within %s, starting the servers waiting on it", server, formatDuration(*bootStartTimeout))
+		return true
+	}
+	return false
+}
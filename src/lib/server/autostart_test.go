@@ -0,0 +1,220 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/common"
+)
+
+// setupBoot registers stopped servers with the fake backend, and makes the
+// servers started when the manager starts be polled quickly.
+func setupBoot(t *testing.T, maxStarts int, servers ...string) *fakeBackend {
+	t.Helper()
+	fake := setupFakeServer(t, servers[0], "")
+	oldInterval, oldMaxStarts := bootPollInterval, *maxConcurrentStarts
+	bootPollInterval, *maxConcurrentStarts = 10*time.Millisecond, maxStarts
+	t.Cleanup(func() { bootPollInterval, *maxConcurrentStarts = oldInterval, oldMaxStarts })
+
+	for _, server := range servers {
+		dir := common.ServerDirectory(server)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("failed to create server directory: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "server.properties"), nil, 0644); err != nil {
+			t.Fatalf("failed to write server.properties: %v", err)
+		}
+		registerServer(t, server)
+		common.ServerStatusesMu.Lock()
+		status := common.ServerStatuses[server]
+		status.Port, status.QueryPort, status.RCONPort = closedPort(t), closedPort(t), closedPort(t)
+		status.State = common.StateStopped
+		common.ServerStatusesMu.Unlock()
+	}
+	return fake
+}
+
+// runBoot starts the servers in order in the background. The returned
+// function waits for it to finish.
+func runBoot(t *testing.T, boot ...bootServer) func() {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		startInOrder(ctx, boot)
+	}()
+	wait := func() {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("startInOrder() didn't finish")
+		}
+	}
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return wait
+}
+
+// waitStarted waits until the given number of servers were started, and
+// returns the started servers once no more are started for a while.
+func waitStarted(t *testing.T, fake *fakeBackend, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		fake.mu.Lock()
+		started := slices.Clone(fake.started)
+		fake.mu.Unlock()
+		if len(started) >= n {
+			// Give servers over the limit the chance to start.
+			time.Sleep(10 * bootPollInterval)
+			fake.mu.Lock()
+			defer fake.mu.Unlock()
+			return slices.Clone(fake.started)
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("started servers = %q, want %d servers started", started, n)
+		}
+		time.Sleep(bootPollInterval)
+	}
+}
+
+// setState moves the servers to the given state.
+func setState(state common.State, servers ...string) {
+	common.ServerStatusesMu.Lock()
+	defer common.ServerStatusesMu.Unlock()
+	for _, server := range servers {
+		common.ServerStatuses[server].SetState(state)
+	}
+}
+
+func TestStartInOrder(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxStarts int
+		boot      []bootServer
+		// waves are the servers started at once, each wave starting once the
+		// previous one is ready.
+		waves [][]string
+	}{
+		{
+			name:      "priority",
+			maxStarts: 1,
+			boot:      []bootServer{{name: "a"}, {name: "b", priority: 10}, {name: "c", priority: 5}},
+			waves:     [][]string{{"b"}, {"c"}, {"a"}},
+		},
+		{
+			name:      "concurrency limit",
+			maxStarts: 2,
+			boot:      []bootServer{{name: "a"}, {name: "b"}, {name: "c"}},
+			waves:     [][]string{{"a", "b"}, {"c"}},
+		},
+		{
+			name:      "unlimited",
+			maxStarts: 0,
+			boot:      []bootServer{{name: "a"}, {name: "b"}, {name: "c"}},
+			waves:     [][]string{{"a", "b", "c"}},
+		},
+		{
+			name:      "dependencies",
+			maxStarts: 0,
+			boot: []bootServer{
+				{name: "lobby", priority: 10, dependsOn: []string{"proxy"}},
+				{name: "survival", dependsOn: []string{"proxy", "lobby"}},
+				{name: "proxy"},
+				{name: "creative"},
+			},
+			waves: [][]string{{"creative", "proxy"}, {"lobby"}, {"survival"}},
+		},
+		{
+			name:      "dependency not booting",
+			maxStarts: 0,
+			boot:      []bootServer{{name: "a", dependsOn: []string{"elsewhere"}}},
+			waves:     [][]string{{"a"}},
+		},
+		{
+			name:      "cycle",
+			maxStarts: 0,
+			boot: []bootServer{
+				{name: "a", dependsOn: []string{"b"}},
+				{name: "b", priority: 1, dependsOn: []string{"a"}},
+				{name: "c", dependsOn: []string{"a"}},
+			},
+			waves: [][]string{{"b"}, {"a"}, {"c"}},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var names []string
+			for _, b := range tc.boot {
+				names = append(names, b.name)
+			}
+			fake := setupBoot(t, tc.maxStarts, names...)
+			wait := runBoot(t, tc.boot...)
+
+			var want []string
+			for _, wave := range tc.waves {
+				want = append(want, wave...)
+				started := waitStarted(t, fake, len(want))
+				if len(started) != len(want) {
+					t.Fatalf("started servers = %q, want %q", started, want)
+				}
+				if got := slices.Sorted(slices.Values(started[len(want)-len(wave):])); !slices.Equal(got, wave) {
+					t.Fatalf("started servers = %q, want %q next", started, wave)
+				}
+				setState(common.StateRunning, wave...)
+			}
+			wait()
+		})
+	}
+}
+
+func TestStartInOrderFailedDependency(t *testing.T) {
+	fake := setupBoot(t, 1, "proxy", "lobby")
+	wait := runBoot(t, bootServer{name: "lobby", dependsOn: []string{"proxy"}}, bootServer{name: "proxy"})
+	if started := waitStarted(t, fake, 1); !slices.Equal(started, []string{"proxy"}) {
+		t.Fatalf("started servers = %q, want [proxy]", started)
+	}
+	// Servers waiting on a server that failed are started anyway.
+	setState(common.StateFailed, "proxy")
+	if started := waitStarted(t, fake, 2); !slices.Equal(started, []string{"proxy", "lobby"}) {
+		t.Fatalf("started servers = %q, want [proxy lobby]", started)
+	}
+	setState(common.StateRunning, "lobby")
+	wait()
+}
+
+func TestBootDone(t *testing.T) {
+	setupBoot(t, 1, "test")
+	oldTimeout := *bootStartTimeout
+	*bootStartTimeout = time.Minute
+	t.Cleanup(func() { *bootStartTimeout = oldTimeout })
+
+	tests := []struct {
+		state common.State
+		since time.Duration
+		want  bool
+	}{
+		{state: common.StateStarting, since: time.Second, want: false},
+		{state: common.StateRecovering, since: time.Second, want: false},
+		{state: common.StateStarting, since: 2 * time.Minute, want: true},
+		{state: common.StateRunning, since: time.Second, want: true},
+		{state: common.StateStopped, since: time.Second, want: true},
+		{state: common.StateFailed, since: time.Second, want: true},
+	}
+	for _, tc := range tests {
+		setState(tc.state, "test")
+		if got := bootDone("test", time.Now().Add(-tc.since)); got != tc.want {
+			t.Errorf("bootDone() of a server %s for %s = %t, want %t", tc.state, tc.since, got, tc.want)
+		}
+	}
+	if !bootDone("unregistered", time.Now()) {
+		t.Errorf("bootDone() of an unregistered server = false, want true")
+	}
+}
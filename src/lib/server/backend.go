@@ -0,0 +1,408 @@
+package server
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/run"
+)
+
+const (
+	// nativePidDir is the directory in the modpack location containing the
+	// process groups of the natively managed servers. It is kept out of the
+	// server directories, which the servers' users can write.
+	nativePidDir = ".native"
+	// nativePidFile is the name of the pid file that builds before
+	// nativePidDir kept in the server directory. It is ignored, and left out
+	// of clones.
+	nativePidFile = "server.pid"
+	// nativeOutputFile is the file in the server directory to which the
+	// stdout and stderr of the natively managed server are written.
+	nativeOutputFile = "console.out"
+)
+
+var (
+	// backendName is the name of the process backend used to run the servers.
+	backendName = flag.String("backend", "screen", "The process backend used to run the servers. One of screen, tmux or native. Servers of the native backend have no session to attach to outside of mcctl.")
+	// backend is the process backend currently in use.
+	backend     ProcessBackend
+	backendErr  error
+	backendOnce sync.Once
+)
+
+// ProcessBackend manages the processes running the servers.
+type ProcessBackend interface {
+	// Running returns the names of all servers that currently have a running process.
+	Running(ctx context.Context) ([]string, error)
+	// Start starts the server's entrypoint from the given directory, as the
+	// user of the credential if one is given.
+	Start(ctx context.Context, server, dir string, cred *run.Credential) error
+	// SendCommand writes a single line to the server's console.
+	SendCommand(ctx context.Context, server, command string) error
+	// Kill force-stops the server's process.
+	Kill(ctx context.Context, server string) error
+	// Processes returns the server's entrypoint and all its descendants,
+	// leaving out terminal multiplexers that may be shared between servers.
+	Processes(ctx context.Context, server string) ([]int, error)
+}
+
+// Backend returns the process backend selected by the --backend flag. If the
+// flag is invalid, every operation of the backend fails.
+func Backend() ProcessBackend {
+	backendOnce.Do(func() {
+		backend, backendErr = NewBackend(*backendName)
+		if backendErr != nil {
+			backend = invalidBackend{err: backendErr}
+		}
+	})
+	return backend
+}
+
+// InitBackend selects the process backend of the --backend flag, and returns
+// an error if the flag is invalid.
+func InitBackend() error {
+	Backend()
+	return backendErr
+}
+
+// NewBackend returns the process backend with the given name.
+func NewBackend(name string) (ProcessBackend, error) {
+	switch name {
+	case "screen":
+		return &screenBackend{}, nil
+	case "tmux":
+		return &tmuxBackend{}, nil
+	case "native":
+		return newNativeBackend(), nil
+	default:
+		return nil, fmt.Errorf("unknown process backend %q", name)
+	}
+}
+
+// invalidBackend is the backend used when the --backend flag is invalid.
+type invalidBackend struct {
+	err error
+}
+
+func (b invalidBackend) Running(context.Context) ([]string, error) {
+	return nil, b.err
+}
+
+func (b invalidBackend) Start(context.Context, string, string, *run.Credential) error {
+	return b.err
+}
+
+func (b invalidBackend) SendCommand(context.Context, string, string) error {
+	return b.err
+}
+
+func (b invalidBackend) Kill(context.Context, string) error {
+	return b.err
+}
+
+func (b invalidBackend) Processes(context.Context, string) ([]int, error) {
+	return nil, b.err
+}
+
+// entrypoint returns the command starting the server's entrypoint. Sessions
+// of terminal multiplexers keep running as the manager's user, so that the
+// manager can find them, while the entrypoint drops to the credential's user.
+func entrypoint(cred *run.Credential) []string {
+	if cred == nil {
+		return []string{"./run.sh"}
+	}
+	args := []string{"setpriv", fmt.Sprintf("--reuid=%d", cred.UID), fmt.Sprintf("--regid=%d", cred.GID)}
+	if len(cred.Groups) == 0 {
+		args = append(args, "--clear-groups")
+	} else {
+		var groups []string
+		for _, g := range cred.Groups {
+			groups = append(groups, strconv.FormatUint(uint64(g), 10))
+		}
+		args = append(args, "--groups="+strings.Join(groups, ","))
+	}
+	return append(args, "--", "./run.sh")
+}
+
+// screenBackend runs each server in a detached GNU screen session named
+// SERVER.server.
+type screenBackend struct{}
+
+// screenSuffix is the suffix of every screen session started by the manager.
+const screenSuffix = ".server"
+
+// Running returns the names of all servers with a screen session.
+func (*screenBackend) Running(ctx context.Context) ([]string, error) {
+	opts := run.Options{
+		Name: "screen",
+		Args: []string{
+			"-ls",
+		},
+		OutputType: run.OutputCombined,
+		ExecMode:   run.ExecModeSync,
+	}
+	res, _ := run.WithContext(ctx, opts)
+	if res == nil { // Errors when nothing is found.
+		return nil, nil
+	}
+	return parseScreenList(res.Output), nil
+}
+
+// parseScreenList parses the output of `screen -ls`.
+func parseScreenList(output string) []string {
+	var servers []string
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		// First field is PID.MODPACK.server. Anything else is not one of ours.
+		_, screenName, ok := strings.Cut(fields[0], ".")
+		if !ok || !strings.HasSuffix(screenName, screenSuffix) {
+			continue
+		}
+		serverName := strings.TrimSuffix(screenName, screenSuffix)
+		if serverName == "" {
+			continue
+		}
+		servers = append(servers, serverName)
+	}
+	return servers
+}
+
+// parseScreenPid parses the pid of the server's screen session from the output
+// of `screen -ls`.
+func parseScreenPid(output, server string) (int, bool) {
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		pid, screenName, ok := strings.Cut(fields[0], ".")
+		if !ok || screenName != server+screenSuffix {
+			continue
+		}
+		if n, err := strconv.Atoi(pid); err == nil {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+// Start starts the server in a new detached screen session.
+func (*screenBackend) Start(ctx context.Context, server, dir string, cred *run.Credential) error {
+	opts := run.Options{
+		Name: "screen",
+		Args: append([]string{
+			"-S",
+			server + screenSuffix,
+			"-d",
+			"-m",
+		}, entrypoint(cred)...),
+		Dir:        dir,
+		OutputType: run.OutputCombined,
+		ExecMode:   run.ExecModeDetach,
+	}
+	_, err := run.WithContext(ctx, opts)
+	return err
+}
+
+// SendCommand stuffs the command into the screen session.
+func (*screenBackend) SendCommand(ctx context.Context, server, command string) error {
+	opts := run.Options{
+		Name: "screen",
+		Args: []string{
+			"-S",
+			server + screenSuffix,
+			"-X",
+			"stuff",
+			command + "^M",
+		},
+		OutputType: run.OutputNone,
+	}
+	_, err := run.WithContext(ctx, opts)
+	return err
+}
+
+// Kill quits the screen session.
+func (*screenBackend) Kill(ctx context.Context, server string) error {
+	opts := run.Options{
+		Name: "screen",
+		Args: []string{
+			"-S",
+			server + screenSuffix,
+			"-X",
+			"quit",
+		},
+		OutputType: run.OutputNone,
+	}
+	_, err := run.WithContext(ctx, opts)
+	return err
+}
+
+// Processes returns the children of the screen session and their descendants.
+func (*screenBackend) Processes(ctx context.Context, server string) ([]int, error) {
+	opts := run.Options{
+		Name: "screen",
+		Args: []string{
+			"-ls",
+		},
+		OutputType: run.OutputCombined,
+		ExecMode:   run.ExecModeSync,
+	}
+	res, _ := run.WithContext(ctx, opts)
+	if res == nil {
+		return nil, fmt.Errorf("no screen session for server %q", server)
+	}
+	pid, ok := parseScreenPid(res.Output, server)
+	if !ok {
+		return nil, fmt.Errorf("no screen session for server %q", server)
+	}
+	tree, err := processTree(pid)
+	if err != nil {
+		return nil, err
+	}
+	// The session process itself is the multiplexer.
+	return tree[1:], nil
+}
+
+// tmuxBackend runs each server in a detached tmux session named mc-SERVER.
+type tmuxBackend struct{}
+
+// tmuxPrefix is the prefix of every tmux session started by the manager.
+const tmuxPrefix = "mc-"
+
+// tmuxSession returns the tmux session name for the server. tmux does not
+// allow '.' and ':' in session names, so these are replaced.
+func tmuxSession(server string) string {
+	return tmuxPrefix + strings.NewReplacer(".", "_", ":", "_").Replace(server)
+}
+
+// Running returns the names of all servers with a tmux session.
+func (*tmuxBackend) Running(ctx context.Context) ([]string, error) {
+	opts := run.Options{
+		Name: "tmux",
+		Args: []string{
+			"list-sessions",
+			"-F",
+			"#{session_name}",
+		},
+		OutputType: run.OutputStdout,
+		ExecMode:   run.ExecModeSync,
+	}
+	res, _ := run.WithContext(ctx, opts)
+	if res == nil { // Errors when there is no tmux server running.
+		return nil, nil
+	}
+	sessions := strings.Fields(res.Output)
+
+	// Session names are lossy, so map them back using the known servers.
+	allServers, err := AllServers()
+	if err != nil {
+		return nil, err
+	}
+	var servers []string
+	for _, server := range allServers {
+		for _, session := range sessions {
+			if session == tmuxSession(server) {
+				servers = append(servers, server)
+				break
+			}
+		}
+	}
+	return servers, nil
+}
+
+// Start starts the server in a new detached tmux session.
+func (*tmuxBackend) Start(ctx context.Context, server, dir string, cred *run.Credential) error {
+	opts := run.Options{
+		Name: "tmux",
+		Args: append([]string{
+			"new-session",
+			"-d",
+			"-s",
+			tmuxSession(server),
+			"-c",
+			dir,
+		}, entrypoint(cred)...),
+		Dir:        dir,
+		OutputType: run.OutputCombined,
+		ExecMode:   run.ExecModeSync,
+	}
+	_, err := run.WithContext(ctx, opts)
+	return err
+}
+
+// SendCommand types the command into the tmux session.
+func (*tmuxBackend) SendCommand(ctx context.Context, server, command string) error {
+	// Send the command literally, then press enter separately so that key
+	// names in the command are not interpreted by tmux.
+	for _, args := range [][]string{
+		{"send-keys", "-t", tmuxSession(server), "-l", command},
+		{"send-keys", "-t", tmuxSession(server), "Enter"},
+	} {
+		opts := run.Options{
+			Name:       "tmux",
+			Args:       args,
+			OutputType: run.OutputNone,
+		}
+		if _, err := run.WithContext(ctx, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Kill kills the tmux session.
+func (*tmuxBackend) Kill(ctx context.Context, server string) error {
+	opts := run.Options{
+		Name: "tmux",
+		Args: []string{
+			"kill-session",
+			"-t",
+			tmuxSession(server),
+		},
+		OutputType: run.OutputNone,
+	}
+	_, err := run.WithContext(ctx, opts)
+	return err
+}
+
+// Processes returns the processes of the panes of the tmux session and their
+// descendants.
+func (*tmuxBackend) Processes(ctx context.Context, server string) ([]int, error) {
+	opts := run.Options{
+		Name: "tmux",
+		Args: []string{
+			"list-panes",
+			"-t",
+			tmuxSession(server),
+			"-F",
+			"#{pane_pid}",
+		},
+		OutputType: run.OutputStdout,
+		ExecMode:   run.ExecModeSync,
+	}
+	res, err := run.WithContext(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("no tmux session for server %q: %v", server, err)
+	}
+	var pids []int
+	for _, field := range strings.Fields(res.Output) {
+		pid, err := strconv.Atoi(field)
+		if err != nil {
+			continue
+		}
+		tree, err := processTree(pid)
+		if err != nil {
+			continue
+		}
+		pids = append(pids, tree...)
+	}
+	return pids, nil
+}
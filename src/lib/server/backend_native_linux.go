@@ -0,0 +1,272 @@
+//go:build linux
+
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/common"
+	"github.com/dranilew/minecraft-server-manager/src/lib/fileutil"
+	"github.com/dranilew/minecraft-server-manager/src/lib/logger"
+	"github.com/dranilew/minecraft-server-manager/src/lib/run"
+)
+
+// nativeBackend runs each server as a direct child of the manager, and writes
+// console commands to its stdin.
+type nativeBackend struct {
+	mu        sync.Mutex
+	processes map[string]*nativeProcess
+}
+
+// nativeProcess is a server process owned by the manager.
+type nativeProcess struct {
+	// pgid is the process group of the server.
+	pgid int
+	// stdin is the pipe to the server's console. This is nil if the process
+	// was adopted from a previous manager instance.
+	stdin io.WriteCloser
+	// done is closed once the process exits.
+	done chan struct{}
+}
+
+func newNativeBackend() *nativeBackend {
+	return &nativeBackend{processes: make(map[string]*nativeProcess)}
+}
+
+// exited returns whether the process has exited.
+func (p *nativeProcess) exited() bool {
+	if p.done == nil {
+		// Adopted processes cannot be waited on, so check the process group.
+		return syscall.Kill(-p.pgid, 0) != nil
+	}
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// process returns the live process for the server, adopting processes left
+// behind by a previous manager instance if needed.
+func (b *nativeBackend) process(server string) *nativeProcess {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if p, ok := b.processes[server]; ok {
+		if !p.exited() {
+			return p
+		}
+		delete(b.processes, server)
+	}
+
+	// Check for a process started by a previous manager instance.
+	pidFile := nativePidPath(server)
+	contents, err := os.ReadFile(pidFile)
+	if err != nil {
+		return nil
+	}
+	pgid, start, err := parsePidFile(string(contents))
+	if err != nil {
+		logger.Printf("Ignoring pid file of server %q: %v", server, err)
+		os.Remove(pidFile)
+		return nil
+	}
+	// The pid may have been reused since, so only adopt the process group
+	// if its leader is still the process that was started.
+	if group, leaderStart, err := processStart(pgid); err != nil || group != pgid || leaderStart != start {
+		os.Remove(pidFile)
+		return nil
+	}
+	p := &nativeProcess{pgid: pgid}
+	logger.Printf("Adopted running process group %d for server %q", pgid, server)
+	b.processes[server] = p
+	return p
+}
+
+// nativePidPath returns the path of the file containing the process group of
+// the natively managed server, and the start time of its leader.
+func nativePidPath(server string) string {
+	return filepath.Join(*common.ModpackLocation, nativePidDir, server+".pid")
+}
+
+// parsePidFile parses the process group and the start time of its leader in
+// a pid file. Process groups that would signal the whole host or the manager
+// itself are rejected.
+func parsePidFile(contents string) (int, string, error) {
+	pgidField, start, ok := strings.Cut(strings.TrimSpace(contents), " ")
+	if !ok || start == "" {
+		return 0, "", fmt.Errorf("missing start time")
+	}
+	pgid, err := strconv.Atoi(pgidField)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid process group %q", pgidField)
+	}
+	if pgid <= 1 || pgid == syscall.Getpgrp() {
+		return 0, "", fmt.Errorf("refusing process group %d", pgid)
+	}
+	return pgid, start, nil
+}
+
+// processStart returns the process group of the process, and the time at
+// which it started in clock ticks since boot.
+func processStart(pid int) (int, string, error) {
+	stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return 0, "", err
+	}
+	// The command name may contain spaces, so parse after its closing
+	// parenthesis: the state is the third field, the process group the
+	// fifth, and the start time the 22nd.
+	i := strings.LastIndexByte(string(stat), ')')
+	if i < 0 {
+		return 0, "", fmt.Errorf("malformed stat of process %d", pid)
+	}
+	fields := strings.Fields(string(stat[i+1:]))
+	if len(fields) < 20 {
+		return 0, "", fmt.Errorf("malformed stat of process %d", pid)
+	}
+	pgid, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return 0, "", fmt.Errorf("malformed stat of process %d", pid)
+	}
+	return pgid, fields[19], nil
+}
+
+// Running returns the names of all servers with a live process.
+func (b *nativeBackend) Running(context.Context) ([]string, error) {
+	allServers, err := AllServers()
+	if err != nil {
+		return nil, err
+	}
+	var servers []string
+	for _, server := range allServers {
+		if b.process(server) != nil {
+			servers = append(servers, server)
+		}
+	}
+	return servers, nil
+}
+
+// Start starts the server as a child of the manager in its own process group.
+func (b *nativeBackend) Start(_ context.Context, server, dir string, cred *run.Credential) error {
+	if b.process(server) != nil {
+		return fmt.Errorf("server %q already has a running process", server)
+	}
+
+	// The server directory may be owned by the server's user, so don't follow
+	// symbolic links planted there.
+	output, err := os.OpenFile(filepath.Join(dir, nativeOutputFile), os.O_CREATE|os.O_WRONLY|os.O_TRUNC|syscall.O_NOFOLLOW, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open console output file: %v", err)
+	}
+
+	// The context is not used, as the server should outlive the request.
+	cmd := exec.Command("./run.sh")
+	cmd.Dir = dir
+	cmd.Stdout = output
+	cmd.Stderr = output
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if cred != nil {
+		cmd.SysProcAttr.Credential = &syscall.Credential{Uid: cred.UID, Gid: cred.GID, Groups: cred.Groups}
+	}
+	// Start the server in its cgroup, so that no process escapes its limits.
+	cgroup, err := openCgroup(server)
+	if err != nil {
+		logger.Printf("Starting server %q outside of its cgroup: %v", server, err)
+	}
+	if cgroup != nil {
+		defer cgroup.Close()
+		cmd.SysProcAttr.UseCgroupFD = true
+		cmd.SysProcAttr.CgroupFD = int(cgroup.Fd())
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		output.Close()
+		return fmt.Errorf("failed to obtain pipe to stdin: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		output.Close()
+		return err
+	}
+
+	p := &nativeProcess{
+		pgid:  cmd.Process.Pid,
+		stdin: stdin,
+		done:  make(chan struct{}),
+	}
+	pidFile := nativePidPath(server)
+	if err := writePidFile(pidFile, p.pgid); err != nil {
+		logger.Printf("Failed to write pid file for server %q: %v", server, err)
+	}
+	go func() {
+		defer close(p.done)
+		if err := cmd.Wait(); err != nil {
+			logger.Printf("Server %q exited: %v", server, err)
+		}
+		output.Close()
+		os.Remove(pidFile)
+	}()
+
+	b.mu.Lock()
+	b.processes[server] = p
+	b.mu.Unlock()
+	return nil
+}
+
+// writePidFile records the process group, and the start time of its leader.
+func writePidFile(path string, pgid int) error {
+	_, start, err := processStart(pgid)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return fileutil.WriteFile(path, []byte(fmt.Sprintf("%d %s\n", pgid, start)), 0600)
+}
+
+// SendCommand writes the command to the server's stdin.
+func (b *nativeBackend) SendCommand(_ context.Context, server, command string) error {
+	p := b.process(server)
+	if p == nil {
+		return fmt.Errorf("server %q is not running", server)
+	}
+	if p.stdin == nil {
+		return fmt.Errorf("console of server %q is unavailable, as it was started by a previous manager instance", server)
+	}
+	if _, err := io.WriteString(p.stdin, command+"\n"); err != nil {
+		return fmt.Errorf("failed to write to console of server %q: %v", server, err)
+	}
+	return nil
+}
+
+// Kill kills the server's whole process group.
+func (b *nativeBackend) Kill(_ context.Context, server string) error {
+	p := b.process(server)
+	if p == nil {
+		return nil
+	}
+	if err := syscall.Kill(-p.pgid, syscall.SIGKILL); err != nil && !errors.Is(err, syscall.ESRCH) {
+		return err
+	}
+	return nil
+}
+
+// Processes returns the server's process and its descendants.
+func (b *nativeBackend) Processes(_ context.Context, server string) ([]int, error) {
+	p := b.process(server)
+	if p == nil {
+		return nil, fmt.Errorf("server %q is not running", server)
+	}
+	return processTree(p.pgid)
+}
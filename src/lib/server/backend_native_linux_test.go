@@ -0,0 +1,79 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/common"
+)
+
+func TestNativeAdoption(t *testing.T) {
+	setupFakeServer(t, "test", "")
+	cmd := exec.Command("sleep", "60")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start process: %v", err)
+	}
+	t.Cleanup(func() {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		cmd.Wait()
+	})
+	pgid := cmd.Process.Pid
+	_, start, err := processStart(pgid)
+	if err != nil {
+		t.Fatalf("processStart() failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		pidFile string
+		adopted bool
+	}{
+		{name: "server", pidFile: fmt.Sprintf("%d %s\n", pgid, start), adopted: true},
+		{name: "reused pid", pidFile: fmt.Sprintf("%d 1\n", pgid)},
+		{name: "missing start time", pidFile: strconv.Itoa(pgid)},
+		{name: "all processes", pidFile: "-1 1\n"},
+		{name: "init", pidFile: "1 1\n"},
+		{name: "own process group", pidFile: "0 1\n"},
+		{name: "manager", pidFile: fmt.Sprintf("%d 1\n", syscall.Getpgrp())},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := nativePidPath("test")
+			if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+				t.Fatalf("failed to create pid directory: %v", err)
+			}
+			if err := os.WriteFile(path, []byte(tc.pidFile), 0600); err != nil {
+				t.Fatalf("failed to write pid file: %v", err)
+			}
+			p := newNativeBackend().process("test")
+			if tc.adopted {
+				if p == nil || p.pgid != pgid {
+					t.Errorf("process() = %v, want process group %d", p, pgid)
+				}
+				return
+			}
+			if p != nil {
+				t.Errorf("process() adopted process group %d", p.pgid)
+			}
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Errorf("process() kept the rejected pid file")
+			}
+		})
+	}
+
+	// Pid files in the server directory are written by the server's user.
+	os.Remove(nativePidPath("test"))
+	legacy := filepath.Join(common.ServerDirectory("test"), nativePidFile)
+	if err := os.WriteFile(legacy, []byte(strconv.Itoa(pgid)), 0644); err != nil {
+		t.Fatalf("failed to write pid file: %v", err)
+	}
+	if p := newNativeBackend().process("test"); p != nil {
+		t.Errorf("process() adopted process group %d from the server directory", p.pgid)
+	}
+}
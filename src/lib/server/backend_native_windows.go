@@ -0,0 +1,37 @@
+//go:build windows
+
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/run"
+)
+
+// nativeBackend is not supported on Windows.
+type nativeBackend struct{}
+
+func newNativeBackend() *nativeBackend {
+	return &nativeBackend{}
+}
+
+func (*nativeBackend) Running(context.Context) ([]string, error) {
+	return nil, fmt.Errorf("not implemented on windows")
+}
+
+func (*nativeBackend) Start(context.Context, string, string, *run.Credential) error {
+	return fmt.Errorf("not implemented on windows")
+}
+
+func (*nativeBackend) SendCommand(context.Context, string, string) error {
+	return fmt.Errorf("not implemented on windows")
+}
+
+func (*nativeBackend) Kill(context.Context, string) error {
+	return fmt.Errorf("not implemented on windows")
+}
+
+func (*nativeBackend) Processes(context.Context, string) ([]int, error) {
+	return nil, fmt.Errorf("not implemented on windows")
+}
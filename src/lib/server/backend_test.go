@@ -0,0 +1,26 @@
+package server
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseScreen(t *testing.T) {
+	const output = `There are screens on:
+	4321.other	(Detached)
+	1234.test.server	(05/10/2026 10:00:00 AM)	(Detached)
+	5678.test.server.server	(Detached)
+3 Sockets in /run/screen/S-root.
+`
+	if got, want := parseScreenList(output), []string{"test", "test.server"}; !slices.Equal(got, want) {
+		t.Errorf("parseScreenList() = %q, want %q", got, want)
+	}
+	for server, want := range map[string]int{"test": 1234, "test.server": 5678} {
+		if got, ok := parseScreenPid(output, server); !ok || got != want {
+			t.Errorf("parseScreenPid(%q) = (%d, %t), want (%d, true)", server, got, ok, want)
+		}
+	}
+	if _, ok := parseScreenPid(output, "other"); ok {
+		t.Errorf("parseScreenPid(%q) found a session not started by the manager", "other")
+	}
+}
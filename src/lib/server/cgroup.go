@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"slices"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/common"
+	"github.com/dranilew/minecraft-server-manager/src/lib/logger"
+)
+
+const (
+	// maxCPUWeight is the highest cgroup CPU weight.
+	maxCPUWeight = 10000
+)
+
+// cgroupParent is the cgroup containing the cgroups of all servers.
+var cgroupParent = flag.String("cgroup-parent", "minecraft.slice", "cgroup v2, relative to /sys/fs/cgroup, in which every server gets its own cgroup with its resource limits. Empty disables cgroups.")
+
+// LimitsRequest is a request to change the resource limits of a server.
+type LimitsRequest struct {
+	// Server is the server whose limits to change.
+	Server string
+	// Limits are the new resource limits. Unset limits use the kernel defaults.
+	Limits common.ResourceLimits
+	// Reset removes all resource limits.
+	Reset bool
+}
+
+// CgroupUsage is the resource usage of the cgroup of a server.
+type CgroupUsage struct {
+	// Memory is the current memory usage in bytes.
+	Memory int64
+	// MemoryMax is the hard memory limit in bytes, or 0 if unlimited.
+	MemoryMax int64
+	// Pids is the current number of processes and threads.
+	Pids int
+	// OOMKills is the number of processes killed for running out of memory.
+	OOMKills int
+}
+
+// ValidateLimits returns an error if the resource limits are invalid.
+func ValidateLimits(limits common.ResourceLimits) error {
+	for _, memory := range []string{limits.MemoryMax, limits.MemoryHigh} {
+		if memory != "" && memory != "max" && !heapRegex.MatchString(memory) {
+			return fmt.Errorf("invalid memory limit %q, must be like 512M, 8G or max", memory)
+		}
+	}
+	if limits.CPUWeight < 0 || limits.CPUWeight > maxCPUWeight {
+		return fmt.Errorf("invalid CPU weight %d, must be from 1 to %d", limits.CPUWeight, maxCPUWeight)
+	}
+	if limits.PidsMax < 0 {
+		return fmt.Errorf("invalid process limit %d", limits.PidsMax)
+	}
+	return nil
+}
+
+// SetLimits changes the resource limits of a registered server. Limits of
+// running servers are applied right away.
+func SetLimits(ctx context.Context, req LimitsRequest) error {
+	if err := ValidateLimits(req.Limits); err != nil {
+		return err
+	}
+	if m := manifestOf(req.Server); m != nil && m.Resources != nil && !req.Reset {
+		return fmt.Errorf("resource limits of server %q are set in its %s", req.Server, ManifestFile)
+	}
+	if !req.Reset && !cgroupsEnabled() {
+		return fmt.Errorf("cgroup v2 is not available on this host, or disabled by --cgroup-parent")
+	}
+	common.ServerStatusesMu.Lock()
+	status, ok := common.ServerStatuses[req.Server]
+	if !ok {
+		common.ServerStatusesMu.Unlock()
+		return fmt.Errorf("server %q is not registered", req.Server)
+	}
+	if req.Reset {
+		status.Limits = nil
+	} else {
+		limits := req.Limits
+		status.Limits = &limits
+	}
+	common.ServerStatusesMu.Unlock()
+	if err := common.UpdateServerStatus(); err != nil {
+		return fmt.Errorf("failed to update server status: %v", err)
+	}
+
+	runningServers, err := GetRunningServers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get running servers: %v", err)
+	}
+	if !slices.Contains(runningServers, req.Server) || !cgroupsEnabled() {
+		return nil
+	}
+	return setupCgroup(req.Server)
+}
+
+// limitsOf returns a copy of the resource limits of the server.
+func limitsOf(server string) common.ResourceLimits {
+	if m := manifestOf(server); m != nil && m.Resources != nil {
+		return *m.Resources
+	}
+	common.ServerStatusesMu.Lock()
+	defer common.ServerStatusesMu.Unlock()
+	if status, ok := common.ServerStatuses[server]; ok && status.Limits != nil {
+		return *status.Limits
+	}
+	return common.ResourceLimits{}
+}
+
+// EnforceCgroups moves the processes of the running servers into their
+// cgroups, including processes started after the server started.
+func EnforceCgroups(ctx context.Context, runningServers []string) error {
+	if !cgroupsEnabled() {
+		return nil
+	}
+	return enforceCgroups(ctx, runningServers)
+}
+
+// startCgroup sets up the cgroup of the server before it starts, so that
+// the process backend can start the server in it.
+func startCgroup(server string) {
+	if !cgroupsEnabled() {
+		return
+	}
+	if err := setupCgroup(server); err != nil {
+		logger.Printf("Failed to set up cgroup of server %q: %v", server, err)
+	}
+}
+
+// placeCgroup moves the processes of the server that just started into its
+// cgroup, for process backends that can't start it there, like terminal
+// multiplexers.
+func placeCgroup(ctx context.Context, server string) {
+	if !cgroupsEnabled() {
+		return
+	}
+	if err := enforceCgroups(ctx, []string{server}); err != nil {
+		logger.Printf("Failed to move server %q into its cgroup: %v", server, err)
+	}
+}
@@ -0,0 +1,217 @@
+//go:build linux
+
+package server
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/logger"
+)
+
+// cgroupRoot is the mount point of the cgroup v2 hierarchy.
+const cgroupRoot = "/sys/fs/cgroup"
+
+var (
+	// cgroupControllers are the controllers enabled for the cgroups of servers.
+	cgroupControllers = []string{"memory", "cpu", "pids"}
+	// cgroupsUnavailable logs once that cgroups are unavailable.
+	cgroupsUnavailable sync.Once
+)
+
+// cgroupsEnabled returns whether servers are put into their own cgroups.
+func cgroupsEnabled() bool {
+	if *cgroupParent == "" {
+		return false
+	}
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		cgroupsUnavailable.Do(func() {
+			logger.Printf("cgroup v2 is not available, resource limits are disabled: %v", err)
+		})
+		return false
+	}
+	return true
+}
+
+// cgroupPath returns the path of the cgroup of the server, relative to the
+// cgroup root.
+func cgroupPath(server string) string {
+	return filepath.Join("/", *cgroupParent, server)
+}
+
+// setupCgroup creates the cgroup of the server, and writes its resource
+// limits. Unset limits are reset to the kernel defaults.
+func setupCgroup(server string) error {
+	if !cgroupsEnabled() {
+		return fmt.Errorf("cgroup v2 is not available")
+	}
+	// Controllers must be enabled on every level above the server's cgroup.
+	dir := cgroupRoot
+	for _, part := range strings.Split(filepath.Clean(*cgroupParent), string(os.PathSeparator)) {
+		if err := enableControllers(dir); err != nil {
+			return err
+		}
+		dir = filepath.Join(dir, part)
+		if err := os.Mkdir(dir, 0755); err != nil && !errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("failed to create cgroup %q: %v", dir, err)
+		}
+	}
+	if err := enableControllers(dir); err != nil {
+		return err
+	}
+	dir = filepath.Join(cgroupRoot, cgroupPath(server))
+	if err := os.Mkdir(dir, 0755); err != nil && !errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("failed to create cgroup %q: %v", dir, err)
+	}
+
+	limits := limitsOf(server)
+	pidsMax := "max"
+	if limits.PidsMax > 0 {
+		pidsMax = strconv.Itoa(limits.PidsMax)
+	}
+	cpuWeight := 100
+	if limits.CPUWeight > 0 {
+		cpuWeight = limits.CPUWeight
+	}
+	values := [][2]string{
+		{"memory.high", cmp.Or(limits.MemoryHigh, "max")},
+		{"memory.max", cmp.Or(limits.MemoryMax, "max")},
+		{"cpu.weight", strconv.Itoa(cpuWeight)},
+		{"pids.max", pidsMax},
+	}
+	for _, v := range values {
+		if err := os.WriteFile(filepath.Join(dir, v[0]), []byte(v[1]), 0644); err != nil {
+			return fmt.Errorf("failed to set %s of server %q to %s: %v", v[0], server, v[1], err)
+		}
+	}
+	return nil
+}
+
+// enableControllers enables the available cgroup controllers for the children
+// of the cgroup.
+func enableControllers(dir string) error {
+	available, err := os.ReadFile(filepath.Join(dir, "cgroup.controllers"))
+	if err != nil {
+		return fmt.Errorf("failed to read controllers of cgroup %q: %v", dir, err)
+	}
+	enabled, err := os.ReadFile(filepath.Join(dir, "cgroup.subtree_control"))
+	if err != nil {
+		return fmt.Errorf("failed to read controllers of cgroup %q: %v", dir, err)
+	}
+	var missing []string
+	for _, c := range cgroupControllers {
+		if slices.Contains(strings.Fields(string(available)), c) && !slices.Contains(strings.Fields(string(enabled)), c) {
+			missing = append(missing, "+"+c)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if err := os.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte(strings.Join(missing, " ")), 0644); err != nil {
+		return fmt.Errorf("failed to enable controllers %v of cgroup %q: %v", missing, dir, err)
+	}
+	return nil
+}
+
+// openCgroup opens the cgroup directory of the server, so that its process
+// can be started in it. nil is returned if cgroups are disabled.
+func openCgroup(server string) (*os.File, error) {
+	if !cgroupsEnabled() {
+		return nil, nil
+	}
+	f, err := os.Open(filepath.Join(cgroupRoot, cgroupPath(server)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cgroup of server %q: %v", server, err)
+	}
+	return f, nil
+}
+
+// enforceCgroups moves the processes of the servers, as found by the process
+// backend, into the servers' cgroups.
+func enforceCgroups(ctx context.Context, servers []string) error {
+	var errs []error
+	for _, server := range servers {
+		pids, err := Backend().Processes(ctx, server)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to find processes of server %q: %v", server, err))
+			continue
+		}
+		path := cgroupPath(server)
+		procsFile := filepath.Join(cgroupRoot, path, "cgroup.procs")
+		for _, pid := range pids {
+			if processCgroup(pid) == path {
+				continue
+			}
+			if _, err := os.Stat(procsFile); err != nil {
+				// The cgroup is gone, like after a reboot.
+				if err := setupCgroup(server); err != nil {
+					errs = append(errs, err)
+					break
+				}
+			}
+			err := os.WriteFile(procsFile, []byte(strconv.Itoa(pid)), 0644)
+			if err != nil && !errors.Is(err, syscall.ESRCH) {
+				errs = append(errs, fmt.Errorf("failed to move process %d of server %q into its cgroup: %v", pid, server, err))
+				continue
+			}
+			logger.Debugf("Moved process %d of server %q into cgroup %q", pid, server, path)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// processCgroup returns the cgroup v2 path of the process.
+func processCgroup(pid int) string {
+	contents, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		return ""
+	}
+	for line := range strings.Lines(string(contents)) {
+		if path, ok := strings.CutPrefix(strings.TrimSpace(line), "0::"); ok {
+			return path
+		}
+	}
+	return ""
+}
+
+// ReadCgroupUsage returns the resource usage of the cgroup of the server.
+func ReadCgroupUsage(server string) (CgroupUsage, error) {
+	var usage CgroupUsage
+	if *cgroupParent == "" {
+		return usage, fmt.Errorf("cgroups are disabled")
+	}
+	dir := filepath.Join(cgroupRoot, cgroupPath(server))
+	read := func(name string) (string, error) {
+		contents, err := os.ReadFile(filepath.Join(dir, name))
+		return strings.TrimSpace(string(contents)), err
+	}
+
+	memory, err := read("memory.current")
+	if err != nil {
+		return usage, err
+	}
+	usage.Memory, _ = strconv.ParseInt(memory, 10, 64)
+	if memoryMax, err := read("memory.max"); err == nil && memoryMax != "max" {
+		usage.MemoryMax, _ = strconv.ParseInt(memoryMax, 10, 64)
+	}
+	if pids, err := read("pids.current"); err == nil {
+		usage.Pids, _ = strconv.Atoi(pids)
+	}
+	if events, err := read("memory.events"); err == nil {
+		for line := range strings.Lines(events) {
+			if count, ok := strings.CutPrefix(strings.TrimSpace(line), "oom_kill "); ok {
+				usage.OOMKills, _ = strconv.Atoi(count)
+			}
+		}
+	}
+	return usage, nil
+}
@@ -0,0 +1,28 @@
+//go:build windows
+
+package server
+
+import (
+	"context"
+	"fmt"
+)
+
+// cgroupsEnabled returns false, as cgroups are not supported on Windows.
+func cgroupsEnabled() bool {
+	return false
+}
+
+// setupCgroup is not supported on Windows.
+func setupCgroup(string) error {
+	return fmt.Errorf("not implemented on windows")
+}
+
+// enforceCgroups is not supported on Windows.
+func enforceCgroups(context.Context, []string) error {
+	return nil
+}
+
+// ReadCgroupUsage is not supported on Windows.
+func ReadCgroupUsage(string) (CgroupUsage, error) {
+	return CgroupUsage{}, fmt.Errorf("not implemented on windows")
+}
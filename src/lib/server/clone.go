@@ -0,0 +1,207 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/common"
+	"github.com/dranilew/minecraft-server-manager/src/lib/logger"
+	"github.com/dranilew/minecraft-server-manager/src/lib/server/properties"
+)
+
+const (
+	// sessionLock is the lock file of a world held by the running server.
+	sessionLock = "session.lock"
+	// defaultLevelName is the world directory of servers that don't set one.
+	defaultLevelName = "world"
+	// saveFlushWait is how long to wait for the world to be flushed to disk
+	// before copying it from a running server.
+	saveFlushWait = 5 * time.Second
+)
+
+// CloneRequest is a request to clone a server into a new one.
+type CloneRequest struct {
+	// Source is the server to clone.
+	Source string
+	// Server is the name of the clone.
+	Server string
+	// NoWorld leaves out the world, so that the clone generates a new one.
+	NoWorld bool
+	// FromBackup restores the world of the clone from a backup instead of
+	// copying it. This is the path of a backup zip on the manager's host, or
+	// a gs:// URL with an optional "#<generation>" suffix.
+	FromBackup string
+}
+
+// Clone copies the source server into a new server with a free block of
+// ports, and registers the clone without starting it. The logs, crash
+// reports and world locks of the source are left out. Backups at gs:// URLs
+// are downloaded with fetch. Progress is written to out.
+func Clone(ctx context.Context, req CloneRequest, fetch func(context.Context, string) (string, error), out func(string) error) error {
+	if err := checkNewServer(req.Server); err != nil {
+		return err
+	}
+	srcDir := common.ServerDirectory(req.Source)
+	if _, err := os.Stat(filepath.Join(srcDir, runScript)); err != nil {
+		return fmt.Errorf("server %q not found: %v", req.Source, err)
+	}
+	if req.NoWorld && req.FromBackup != "" {
+		return fmt.Errorf("a world can't both be left out and restored from a backup")
+	}
+
+	levelName := defaultLevelName
+	props, err := LoadProperties(req.Source)
+	if err == nil {
+		if name, ok := props.Get("level-name"); ok && name != "" {
+			levelName = name
+		}
+	}
+	copyWorld := !req.NoWorld && req.FromBackup == ""
+
+	tmpDir, err := stagingDir(req.Server)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	runningServers, err := GetRunningServers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get running servers: %v", err)
+	}
+	if copyWorld && slices.Contains(runningServers, req.Source) {
+		// Keep the running server from writing to the world while it is copied.
+		if err := out(fmt.Sprintf("Flushing the world of server %q", req.Source)); err != nil {
+			return err
+		}
+		if _, err := RunCommand(ctx, req.Source, "save-off"); err != nil {
+			return fmt.Errorf("failed to disable saving on server %q: %v", req.Source, err)
+		}
+		defer func() {
+			if _, err := RunCommand(ctx, req.Source, "save-on"); err != nil {
+				logger.Printf("Failed to enable saving on server %q: %v", req.Source, err)
+			}
+		}()
+		if _, err := RunCommand(ctx, req.Source, "save-all flush"); err != nil {
+			return fmt.Errorf("failed to save server %q: %v", req.Source, err)
+		}
+		time.Sleep(saveFlushWait)
+	}
+
+	if err := out(fmt.Sprintf("Copying server %q", req.Source)); err != nil {
+		return err
+	}
+	if err := copyTree(srcDir, tmpDir, cloneSkipped(levelName, copyWorld)); err != nil {
+		return fmt.Errorf("failed to copy server %q: %v", req.Source, err)
+	}
+
+	if req.FromBackup != "" {
+		if err := restoreWorld(ctx, req.FromBackup, filepath.Join(tmpDir, levelName), fetch, out); err != nil {
+			return err
+		}
+	}
+
+	// Servers created by the manager use their name as the MOTD.
+	if props != nil {
+		if motd, _ := props.Get("motd"); motd == req.Source {
+			props.Set("motd", req.Server)
+			if err := props.Save(filepath.Join(tmpDir, properties.FileName)); err != nil {
+				return err
+			}
+		}
+	}
+
+	ports, err := installServer(req.Server, tmpDir)
+	if err != nil {
+		return err
+	}
+	logger.Printf("Cloned server %q into %q with ports %v", req.Source, req.Server, ports)
+	return out(fmt.Sprintf("Cloned server %q into %q with %v", req.Source, req.Server, ports))
+}
+
+// cloneSkipped returns whether the path of a server's file is left out of
+// clones. The processes, logs and crash reports of the source server aren't
+// part of the clone, and neither is its world unless copyWorld is set.
+func cloneSkipped(levelName string, copyWorld bool) func(path string) bool {
+	skipped := []string{logsDir, crashReportsDir, diagnosticsDir, crashHistoryFile, nativePidFile, nativeOutputFile}
+	if !copyWorld {
+		skipped = append(skipped, levelName)
+	}
+	return func(path string) bool {
+		return slices.Contains(skipped, path) || filepath.Base(path) == sessionLock
+	}
+}
+
+// restoreWorld extracts the world from the backup into the world directory.
+// Backups keep the world in a top-level directory, which is stripped, so that
+// the world ends up in the world directory whatever the level name.
+func restoreWorld(ctx context.Context, backup, worldDir string, fetch func(context.Context, string) (string, error), out func(string) error) error {
+	if err := out(fmt.Sprintf("Restoring the world from %s", backup)); err != nil {
+		return err
+	}
+	if strings.HasPrefix(backup, "gs://") {
+		path, err := fetch(ctx, backup)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(path)
+		backup = path
+	} else if !filepath.IsAbs(backup) {
+		return fmt.Errorf("backup %q must be an absolute path or a gs:// URL", backup)
+	}
+	if err := extractZip(backup, worldDir, true); err != nil {
+		return fmt.Errorf("failed to restore the world: %v", err)
+	}
+	return nil
+}
+
+// copyTree copies the directory tree at src to dst, preserving modes and
+// symbolic links. Paths relative to src for which skip returns true are left
+// out.
+func copyTree(src, dst string, skip func(path string) bool) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		if skip(rel) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.Mkdir(target, info.Mode().Perm())
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			if err := copyFile(path, target); err != nil {
+				return err
+			}
+			return os.Chmod(target, info.Mode().Perm())
+		}
+		// Sockets, pipes and devices aren't part of a server.
+		return nil
+	})
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"archive/zip"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRestoreWorld(t *testing.T) {
+	// Backups keep the world under world/, whatever the level name.
+	backup := filepath.Join(t.TempDir(), "test-backup.zip")
+	f, err := os.Create(backup)
+	if err != nil {
+		t.Fatalf("failed to create backup: %v", err)
+	}
+	zw := zip.NewWriter(f)
+	files := map[string]string{
+		"world/level.dat":          "level",
+		"world/region/r.0.0.mca":   "region",
+		"world/DIM-1/region/r.mca": "nether",
+	}
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("failed to add %s to backup: %v", name, err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to write backup: %v", err)
+	}
+	f.Close()
+
+	dir := t.TempDir()
+	worldDir := filepath.Join(dir, "survival")
+	if err := restoreWorld(context.Background(), backup, worldDir, nil, func(string) error { return nil }); err != nil {
+		t.Fatalf("restoreWorld() failed: %v", err)
+	}
+	for name, want := range files {
+		path := filepath.Join(worldDir, filepath.FromSlash(name[len("world/"):]))
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Errorf("failed to read restored %s: %v", name, err)
+			continue
+		}
+		if string(got) != want {
+			t.Errorf("restored %s = %q, want %q", name, got, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, defaultLevelName)); !os.IsNotExist(err) {
+		t.Errorf("restoreWorld() created %s directory, want only %s", defaultLevelName, worldDir)
+	}
+}
+
+func TestCopyTreeSkipsRuntimeFiles(t *testing.T) {
+	src := t.TempDir()
+	files := map[string]bool{
+		runScript:                             true,
+		"server.properties":                   true,
+		"config/create-common.toml":           true,
+		"survival/level.dat":                  true,
+		"survival/session.lock":               false,
+		nativePidFile:                         false,
+		nativeOutputFile:                      false,
+		"logs/latest.log":                     false,
+		"crash-reports/crash-2024-server.txt": false,
+		crashHistoryFile:                      false,
+	}
+	for name := range files {
+		path := filepath.Join(src, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create directory of %s: %v", name, err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	for _, copyWorld := range []bool{true, false} {
+		dst := t.TempDir()
+		if err := copyTree(src, dst, cloneSkipped("survival", copyWorld)); err != nil {
+			t.Fatalf("copyTree() failed: %v", err)
+		}
+		for name, copied := range files {
+			if strings.HasPrefix(name, "survival/") && !copyWorld {
+				copied = false
+			}
+			_, err := os.Stat(filepath.Join(dst, filepath.FromSlash(name)))
+			if copied && err != nil {
+				t.Errorf("copyTree() with world %t didn't copy %s: %v", copyWorld, name, err)
+			}
+			if !copied && !os.IsNotExist(err) {
+				t.Errorf("copyTree() with world %t copied %s", copyWorld, name)
+			}
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/common"
+	"github.com/dranilew/minecraft-server-manager/src/lib/server/properties"
+)
+
+var (
+	// defaultProperties are the default server.properties of a vanilla server.
+	defaultProperties = map[string]string{
+		"accepts-transfers":                 "false",
+		"allow-flight":                      "false",
+		"allow-nether":                      "true",
+		"broadcast-console-to-ops":          "true",
+		"broadcast-rcon-to-ops":             "true",
+		"difficulty":                        "easy",
+		"enable-command-block":              "false",
+		"enable-jmx-monitoring":             "false",
+		"enable-query":                      "false",
+		"enable-rcon":                       "false",
+		"enable-status":                     "true",
+		"enforce-secure-profile":            "true",
+		"enforce-whitelist":                 "false",
+		"entity-broadcast-range-percentage": "100",
+		"force-gamemode":                    "false",
+		"function-permission-level":         "2",
+		"gamemode":                          "survival",
+		"generate-structures":               "true",
+		"generator-settings":                "{}",
+		"hardcore":                          "false",
+		"hide-online-players":               "false",
+		"initial-disabled-packs":            "",
+		"initial-enabled-packs":             "vanilla",
+		"level-name":                        "world",
+		"level-seed":                        "",
+		"level-type":                        "minecraft:normal",
+		"log-ips":                           "true",
+		"max-chained-neighbor-updates":      "1000000",
+		"max-players":                       "20",
+		"max-tick-time":                     "60000",
+		"max-world-size":                    "29999984",
+		"motd":                              "A Minecraft Server",
+		"network-compression-threshold":     "256",
+		"online-mode":                       "true",
+		"op-permission-level":               "4",
+		"player-idle-timeout":               "0",
+		"prevent-proxy-connections":         "false",
+		"pvp":                               "true",
+		"query.port":                        "25565",
+		"rate-limit":                        "0",
+		"rcon.password":                     "",
+		"rcon.port":                         "25575",
+		"require-resource-pack":             "false",
+		"resource-pack":                     "",
+		"resource-pack-id":                  "",
+		"resource-pack-prompt":              "",
+		"resource-pack-sha1":                "",
+		"server-ip":                         "",
+		"server-port":                       "25565",
+		"simulation-distance":               "10",
+		"spawn-monsters":                    "true",
+		"spawn-protection":                  "16",
+		"sync-chunk-writes":                 "true",
+		"text-filtering-config":             "",
+		"use-native-transport":              "true",
+		"view-distance":                     "10",
+		"white-list":                        "false",
+	}
+	// liveProperties are the properties that are applied to a running server
+	// through a console command instead of a restart.
+	liveProperties = map[string]func(value string) string{
+		"difficulty": func(value string) string { return "difficulty " + value },
+		"white-list": func(value string) string {
+			if value == "true" {
+				return "whitelist on"
+			}
+			return "whitelist off"
+		},
+	}
+	// portProperties are the properties managed by the port allocator.
+	portProperties = []string{"server-port", "query.port", "rcon.port"}
+)
+
+// ConfigRequest is a request to change a property in the server.properties
+// of a server.
+type ConfigRequest struct {
+	// Server is the server whose properties to change.
+	Server string
+	// Key is the property to change.
+	Key string
+	// Value is the new value of the property.
+	Value string
+	// Unset removes the property instead, which makes the server use the
+	// default value.
+	Unset bool
+	// Restart restarts the server if it is running and the change can't be
+	// applied otherwise.
+	Restart bool
+}
+
+// ConfigDifference is a property whose value differs from the default.
+type ConfigDifference struct {
+	// Key is the property.
+	Key string
+	// Value is the value of the property.
+	Value string
+	// Default is the default value of the property.
+	Default string
+	// HasDefault indicates whether the property is a vanilla property with a
+	// known default, unlike properties added by mods.
+	HasDefault bool
+}
+
+// propertiesPath returns the location of the server.properties of the server.
+func propertiesPath(server string) string {
+	return filepath.Join(common.ServerDirectory(server), properties.FileName)
+}
+
+// LoadProperties reads the server.properties of the server.
+func LoadProperties(server string) (*properties.Properties, error) {
+	return properties.Load(propertiesPath(server))
+}
+
+// DiffProperties returns the properties of the server that differ from the
+// defaults, in the order they appear in the file. If a key is given, only
+// that property is compared.
+func DiffProperties(server, key string) ([]ConfigDifference, error) {
+	props, err := LoadProperties(server)
+	if err != nil {
+		return nil, err
+	}
+	var diffs []ConfigDifference
+	for _, k := range props.Keys() {
+		if key != "" && k != key {
+			continue
+		}
+		value, _ := props.Get(k)
+		def, ok := defaultProperties[k]
+		if ok && value == def {
+			continue
+		}
+		diffs = append(diffs, ConfigDifference{Key: k, Value: value, Default: def, HasDefault: ok})
+	}
+	return diffs, nil
+}
+
+// Configure changes a property of the server, and writes progress to out.
+// Properties of a running server are applied through the console where
+// possible, and otherwise by restarting it if requested.
+func Configure(ctx context.Context, req ConfigRequest, out func(string) error) error {
+	if req.Key == "" {
+		return fmt.Errorf("no property given")
+	}
+	if slices.Contains(portProperties, req.Key) {
+		return fmt.Errorf("%s is managed by the port allocator, use mcctl server set-port instead", req.Key)
+	}
+	if slices.Contains(maintenanceProperties, req.Key) && InMaintenance(req.Server) {
+		return fmt.Errorf("%s is managed by maintenance mode of server %q until it is turned off", req.Key, req.Server)
+	}
+	common.ServerStatusesMu.Lock()
+	_, ok := common.ServerStatuses[req.Server]
+	common.ServerStatusesMu.Unlock()
+	if !ok {
+		return fmt.Errorf("server %q is not registered", req.Server)
+	}
+
+	path := propertiesPath(req.Server)
+	props, err := properties.Load(path)
+	if err != nil {
+		return err
+	}
+	old, wasSet := props.Get(req.Key)
+	value := req.Value
+	if req.Unset {
+		if !wasSet {
+			return out(fmt.Sprintf("%s is not set", req.Key))
+		}
+		props.Unset(req.Key)
+		value = defaultProperties[req.Key]
+	} else {
+		if wasSet && old == req.Value {
+			return out(fmt.Sprintf("%s is already %q", req.Key, req.Value))
+		}
+		props.Set(req.Key, req.Value)
+	}
+	if err := props.Save(path); err != nil {
+		return err
+	}
+	if req.Unset {
+		if err := out(fmt.Sprintf("Unset %s", req.Key)); err != nil {
+			return err
+		}
+	} else if err := out(fmt.Sprintf("Set %s to %q", req.Key, req.Value)); err != nil {
+		return err
+	}
+
+	runningServers, err := GetRunningServers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get running servers: %v", err)
+	}
+	if !slices.Contains(runningServers, req.Server) {
+		return nil
+	}
+	if command, ok := liveProperties[req.Key]; ok {
+		if _, err := RunCommand(ctx, req.Server, command(value)); err != nil {
+			return fmt.Errorf("failed to apply %s to the running server: %v", req.Key, err)
+		}
+		return out("Applied the change to the running server")
+	}
+	if !req.Restart {
+		return out(fmt.Sprintf("Restart server %q for the change to take effect", req.Server))
+	}
+	if err := out(fmt.Sprintf("Restarting server %q to apply the change", req.Server)); err != nil {
+		return err
+	}
+	return Restart(ctx, StopRequest{Servers: []string{req.Server}})
+}
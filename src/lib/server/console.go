@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/common"
+	"github.com/dranilew/minecraft-server-manager/src/lib/logger"
+	"github.com/dranilew/minecraft-server-manager/src/lib/rcon"
+)
+
+const (
+	// captureWindow is how long log lines are captured as the output of a
+	// command written to the console.
+	captureWindow = 2 * time.Second
+)
+
+// RunCommand runs a console command on the server. The command is sent over
+// RCON if it is enabled for the server, in which case the command output is
+// returned. Otherwise, the command is written to the console through the
+// process backend, and no output is returned.
+func RunCommand(ctx context.Context, server, command string) (string, error) {
+	output, _, err := runCommand(ctx, server, command)
+	return output, err
+}
+
+// CaptureCommand is like RunCommand, but when the command is written to the
+// console, the lines logged shortly after are returned as its output.
+func CaptureCommand(ctx context.Context, server, command string) (string, error) {
+	path := filepath.Join(common.ServerDirectory(server), logsDir, latestLog)
+	var offset int64
+	if info, err := os.Stat(path); err == nil {
+		offset = info.Size()
+	}
+
+	output, viaRCON, err := runCommand(ctx, server, command)
+	if err != nil || viaRCON {
+		return output, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case <-time.After(captureWindow):
+	}
+	var lines []string
+	if _, err := readLog(path, offset, func(line string) error {
+		lines = append(lines, line)
+		return nil
+	}); err != nil {
+		return "", fmt.Errorf("failed to capture output of %q: %v", command, err)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// runCommand runs the console command, and returns whether it was sent over RCON.
+func runCommand(ctx context.Context, server, command string) (string, bool, error) {
+	conf, err := rcon.LoadConfig(common.ServerDirectory(server))
+	if err != nil {
+		logger.Debugf("Failed to read RCON configuration for %q, using console: %v", server, err)
+		return "", false, sendConsole(ctx, server, command)
+	}
+	client, err := rcon.Dial(ctx, conf)
+	if err != nil {
+		logger.Debugf("RCON unavailable for %q, using console: %v", server, err)
+		return "", false, sendConsole(ctx, server, command)
+	}
+	defer client.Close()
+
+	output, err := client.Command(ctx, command)
+	if err != nil {
+		return "", true, fmt.Errorf("failed to run %q over RCON: %v", command, err)
+	}
+	return output, true, nil
+}
+
+// sendConsole writes the command to the server's console through the process backend.
+func sendConsole(ctx context.Context, server, command string) error {
+	if err := Backend().SendCommand(ctx, server, command); err != nil {
+		return fmt.Errorf("failed to write %q to console: %v", command, err)
+	}
+	return nil
+}
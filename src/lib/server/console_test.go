@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/common"
+	"github.com/dranilew/minecraft-server-manager/src/lib/run"
+)
+
+// fakeBackend records the calls made to the process backend.
+type fakeBackend struct {
+	mu       sync.Mutex
+	running  []string
+	started  []string
+	commands []string
+	killErr  error
+}
+
+func (b *fakeBackend) Running(context.Context) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.running...), nil
+}
+
+func (b *fakeBackend) Start(_ context.Context, server, _ string, _ *run.Credential) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.started = append(b.started, server)
+	return nil
+}
+
+func (b *fakeBackend) SendCommand(_ context.Context, _, command string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.commands = append(b.commands, command)
+	return nil
+}
+
+func (b *fakeBackend) Kill(context.Context, string) error {
+	return b.killErr
+}
+
+func (b *fakeBackend) Processes(context.Context, string) ([]int, error) {
+	return nil, nil
+}
+
+// setupFakeServer points the modpack location at a temporary directory
+// holding a server with the given properties, replaces the process backend
+// with a fake, and disables cgroups.
+func setupFakeServer(t *testing.T, server, props string) *fakeBackend {
+	t.Helper()
+	oldLocation, oldCgroupParent := *common.ModpackLocation, *cgroupParent
+	*common.ModpackLocation, *cgroupParent = t.TempDir(), ""
+	t.Cleanup(func() { *common.ModpackLocation, *cgroupParent = oldLocation, oldCgroupParent })
+
+	dir := common.ServerDirectory(server)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("failed to create server directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "server.properties"), []byte(props), 0644); err != nil {
+		t.Fatalf("failed to write server.properties: %v", err)
+	}
+
+	fake := &fakeBackend{}
+	backendOnce.Do(func() {})
+	oldBackend := backend
+	backend = fake
+	t.Cleanup(func() { backend = oldBackend })
+	return fake
+}
+
+// closedPort returns a local port that nothing listens on.
+func closedPort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() failed: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestRunCommandConsoleFallback(t *testing.T) {
+	tests := []struct {
+		name  string
+		props func(t *testing.T) string
+	}{
+		{
+			name:  "rcon disabled",
+			props: func(*testing.T) string { return "enable-rcon=false\n" },
+		},
+		{
+			name: "rcon unreachable",
+			props: func(t *testing.T) string {
+				return "enable-rcon=true\nrcon.password=secret\nrcon.port=" + strconv.Itoa(closedPort(t)) + "\n"
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := setupFakeServer(t, "test", tc.props(t))
+			output, viaRCON, err := runCommand(context.Background(), "test", "say hello")
+			if err != nil {
+				t.Fatalf("runCommand() failed: %v", err)
+			}
+			if viaRCON || output != "" {
+				t.Errorf("runCommand() = (%q, %t), want console fallback", output, viaRCON)
+			}
+			if len(fake.commands) != 1 || fake.commands[0] != "say hello" {
+				t.Errorf("console commands = %q, want [%q]", fake.commands, "say hello")
+			}
+		})
+	}
+}
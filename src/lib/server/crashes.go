@@ -0,0 +1,258 @@
+package server
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/common"
+	"github.com/dranilew/minecraft-server-manager/src/lib/fileutil"
+	"github.com/dranilew/minecraft-server-manager/src/lib/logger"
+)
+
+const (
+	// crashHistoryFile is the file in the server directory storing the
+	// parsed crash reports.
+	crashHistoryFile = "crash-history.json"
+	// maxCrashHistory is the number of crashes kept in the history.
+	maxCrashHistory = 50
+	// maxCrashFrames is the number of stack frames kept per crash.
+	maxCrashFrames = 8
+)
+
+var (
+	// crashReportFileRegex matches crash report files, like
+	// crash-2024-01-02_12.34.56-server.txt.
+	crashReportFileRegex = regexp.MustCompile(`^crash-.*\.txt$`)
+	// suspectedModsRegex matches the header of the suspected mods section
+	// written by Forge and NeoForge, or by Fabric and Quilt in the system
+	// details.
+	suspectedModsRegex = regexp.MustCompile(`^Suspected Mods?(?:\(s\))?:\s*(.*)$`)
+	// loaderModRegex matches a mod listed by Fabric and Quilt, like
+	// "sodium: Sodium 0.5.3+mc1.20.1".
+	loaderModRegex = regexp.MustCompile(`^([a-z0-9_.-]+): (.+?)(?: [0-9][^ ]*)?$`)
+	// transformerFrameRegex matches the mod owning a stack frame, like
+	// TRANSFORMER/create@0.5.1 or MC-BOOTSTRAP/create@0.5.1.
+	transformerFrameRegex = regexp.MustCompile(`\{?(?:TRANSFORMER|MC-BOOTSTRAP|SECURE-BOOTSTRAP)/([a-z0-9_.-]+)@`)
+	// mixinFrameRegex matches the mod owning a mixin injected by Fabric and
+	// Quilt, like handler$zza000$sodium$onTick.
+	mixinFrameRegex = regexp.MustCompile(`\.(?:handler|redirect|modify|localvar|constant|wrapOperation|wrapWithCondition)\$[a-z]{3}[0-9]{3}\$([a-z0-9_]+)\$`)
+	// crashTimeLayouts are the layouts of the Time line in crash reports.
+	crashTimeLayouts = []string{"2006-01-02 15:04:05", "2006-01-02 15:04:05.000", "1/2/06 3:04 PM", "2006-01-02, 3:04 p.m."}
+	// builtinMods are the mods that are never suspected, as they appear in
+	// every stack trace.
+	builtinMods = []string{"minecraft", "forge", "neoforge", "fml", "fabricloader", "java"}
+
+	// knownCrashReports caches the crash reports already in the history of
+	// each server.
+	knownCrashReports   = make(map[string]map[string]bool)
+	knownCrashReportsMu sync.Mutex
+)
+
+// CrashReport is a parsed crash report.
+type CrashReport struct {
+	// File is the name of the crash report file.
+	File string `json:"file"`
+	// Time is the time of the crash.
+	Time time.Time `json:"time"`
+	// Description is the description of the crash, like "Exception in
+	// server tick loop".
+	Description string `json:"description"`
+	// Exception is the exception that caused the crash.
+	Exception string `json:"exception"`
+	// Frames are the top stack frames of the exception.
+	Frames []string `json:"frames,omitempty"`
+	// SuspectedMods are the mods suspected to have caused the crash.
+	SuspectedMods []string `json:"suspected-mods,omitempty"`
+}
+
+// ParseCrashReport parses the crash report at the given path.
+func ParseCrashReport(path string) (*CrashReport, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open crash report: %v", err)
+	}
+	defer f.Close()
+
+	report := &CrashReport{File: filepath.Base(path)}
+	if dateTime := crashReportsRegex.FindString(report.File); dateTime != "" {
+		report.Time, _ = time.ParseInLocation("2006-01-02_15.04.05", dateTime, time.Local)
+	}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	// inTrace indicates the lines are part of the exception's stack trace,
+	// and inMods that they are part of the suspected mods section, whose
+	// header is indented modsIndent times.
+	var inTrace, inMods bool
+	var modsIndent int
+	var frameMods []string
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		trimmed := strings.TrimSpace(line)
+		indent := len(line) - len(strings.TrimLeft(line, "\t"))
+
+		if inMods {
+			// Mods are indented once more than the header, their details
+			// further.
+			if trimmed != "" && indent > modsIndent {
+				if indent == modsIndent+1 {
+					report.SuspectedMods = append(report.SuspectedMods, modName(trimmed))
+				}
+				continue
+			}
+			inMods = false
+		}
+		if match := suspectedModsRegex.FindStringSubmatch(trimmed); match != nil {
+			inMods, modsIndent = true, indent
+			if mods := match[1]; mods != "" && !strings.EqualFold(mods, "NONE") && !strings.EqualFold(mods, "Unknown") {
+				report.SuspectedMods = append(report.SuspectedMods, strings.Split(mods, ", ")...)
+			}
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "Time: "):
+			value := strings.TrimPrefix(line, "Time: ")
+			for _, layout := range crashTimeLayouts {
+				if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+					report.Time = t
+					break
+				}
+			}
+		case strings.HasPrefix(line, "Description: ") && report.Description == "":
+			report.Description = strings.TrimPrefix(line, "Description: ")
+		case report.Description != "" && report.Exception == "" && trimmed != "":
+			// The exception directly follows the description.
+			report.Exception = trimmed
+			inTrace = true
+		case inTrace && strings.HasPrefix(trimmed, "at "):
+			if len(report.Frames) < maxCrashFrames {
+				report.Frames = append(report.Frames, strings.TrimPrefix(trimmed, "at "))
+			}
+			if match := transformerFrameRegex.FindStringSubmatch(trimmed); match != nil {
+				frameMods = append(frameMods, match[1])
+			} else if match := mixinFrameRegex.FindStringSubmatch(trimmed); match != nil {
+				frameMods = append(frameMods, match[1])
+			}
+		case inTrace:
+			// Keep the frames of the outermost exception only.
+			inTrace = false
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read crash report: %v", err)
+	}
+
+	// Fall back to the first mod in the stack trace if the loader did not
+	// report any suspects.
+	if len(report.SuspectedMods) == 0 {
+		for _, mod := range frameMods {
+			if !slices.Contains(builtinMods, mod) {
+				report.SuspectedMods = []string{mod}
+				break
+			}
+		}
+	}
+	return report, nil
+}
+
+// modName returns the name of a suspected mod, like "Create (create)", from
+// the way Forge or Fabric list it.
+func modName(line string) string {
+	if match := loaderModRegex.FindStringSubmatch(line); match != nil {
+		return fmt.Sprintf("%s (%s)", match[2], match[1])
+	}
+	mod, _, _ := strings.Cut(line, ", Version")
+	return mod
+}
+
+// CrashHistory returns the parsed crash reports of the server, oldest first.
+func CrashHistory(server string) ([]CrashReport, error) {
+	contents, err := os.ReadFile(filepath.Join(common.ServerDirectory(server), crashHistoryFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read crash history of server %q: %v", server, err)
+	}
+	var history []CrashReport
+	if err := json.Unmarshal(contents, &history); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal crash history of server %q: %v", server, err)
+	}
+	return history, nil
+}
+
+// recordCrashes parses the crash reports of the server that aren't in its
+// crash history yet, and adds them to the history.
+func recordCrashes(server string, reports []os.DirEntry) error {
+	knownCrashReportsMu.Lock()
+	defer knownCrashReportsMu.Unlock()
+	known, ok := knownCrashReports[server]
+	if !ok {
+		history, err := CrashHistory(server)
+		if err != nil {
+			return err
+		}
+		known = make(map[string]bool)
+		for _, crash := range history {
+			known[crash.File] = true
+		}
+		knownCrashReports[server] = known
+	}
+
+	var parsed []CrashReport
+	dir := filepath.Join(common.ServerDirectory(server), crashReportsDir)
+	for _, report := range reports {
+		if report.IsDir() || known[report.Name()] || !crashReportFileRegex.MatchString(report.Name()) {
+			continue
+		}
+		// Servers write crash reports while crashing, so skip reports that
+		// may still be incomplete.
+		if info, err := report.Info(); err != nil || time.Since(info.ModTime()) < time.Second {
+			continue
+		}
+		crash, err := ParseCrashReport(filepath.Join(dir, report.Name()))
+		if err != nil {
+			logger.Printf("Failed to parse crash report %q of server %q: %v", report.Name(), server, err)
+		}
+		if crash == nil {
+			crash = &CrashReport{File: report.Name()}
+		}
+		known[report.Name()] = true
+		parsed = append(parsed, *crash)
+		logger.Printf("Server %q crashed: %s: %s (suspected mods: %v)", server, crash.Description, crash.Exception, crash.SuspectedMods)
+	}
+	if len(parsed) == 0 {
+		return nil
+	}
+
+	history, err := CrashHistory(server)
+	if err != nil {
+		return err
+	}
+	history = append(history, parsed...)
+	slices.SortStableFunc(history, func(a, b CrashReport) int {
+		return cmp.Compare(a.Time.Unix(), b.Time.Unix())
+	})
+	if len(history) > maxCrashHistory {
+		history = history[len(history)-maxCrashHistory:]
+	}
+	b, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := fileutil.WriteFile(filepath.Join(common.ServerDirectory(server), crashHistoryFile), b, 0644); err != nil {
+		return fmt.Errorf("failed to write crash history of server %q: %v", server, err)
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// forgeCrashReport is a crash report written by a Forge server.
+const forgeCrashReport = `---- Minecraft Crash Report ----
+// Surprise! Haha. Well, this is awkward.
+
+Time: 2024-03-10 21:14:07
+Description: Exception in server tick loop
+
+java.lang.NullPointerException: Cannot invoke "net.minecraft.world.level.block.state.BlockState.m_60734_()" because "state" is null
+	at TRANSFORMER/create@0.5.1.f/com.simibubi.create.content.kinetics.belt.BeltBlockEntity.tick(BeltBlockEntity.java:120) ~[create-1.20.1-0.5.1.f.jar%23197!/:0.5.1.f] {re:computing_frames,re:classloading}
+	at TRANSFORMER/minecraft@1.20.1/net.minecraft.world.level.Level.m_46463_(Level.java:479) ~[server-1.20.1-20230612.114412-srg.jar%23262!/:?] {re:mixin,pl:accesstransformer:B,re:classloading}
+	at TRANSFORMER/minecraft@1.20.1/net.minecraft.server.MinecraftServer.m_5703_(MinecraftServer.java:893) ~[server-1.20.1-20230612.114412-srg.jar%23262!/:?] {re:mixin,pl:accesstransformer:B,re:classloading}
+	at java.base/java.lang.Thread.run(Thread.java:833) [?:?] {}
+
+
+A detailed walkthrough of the error, its code path and all known details is as follows:
+---------------------------------------------------------------------------------------
+
+-- Head --
+Thread: Server thread
+Suspected Mod: 
+	Create (create), Version: 0.5.1.f
+		Issue tracker URL: https://github.com/Creators-of-Create/Create/issues
+		at TRANSFORMER/create@0.5.1.f/com.simibubi.create.content.kinetics.belt.BeltBlockEntity.tick(BeltBlockEntity.java:120)
+Stacktrace:
+	at TRANSFORMER/create@0.5.1.f/com.simibubi.create.content.kinetics.belt.BeltBlockEntity.tick(BeltBlockEntity.java:120) ~[create-1.20.1-0.5.1.f.jar%23197!/:0.5.1.f] {re:computing_frames,re:classloading}
+
+-- System Details --
+Details:
+	Minecraft Version: 1.20.1
+	Minecraft Version ID: 1.20.1
+	Operating System: Linux (amd64) version 6.1.0-18-amd64
+	Java Version: 17.0.10, Eclipse Adoptium
+	Suspected Mods: NONE
+	FML: 47.2
+	Forge: net.minecraftforge:47.2.0
+`
+
+// fabricCrashReport is a crash report written by a Fabric server, without
+// suspected mods.
+const fabricCrashReport = `---- Minecraft Crash Report ----
+// I blame Dinnerbone.
+
+Time: 2024-05-02 18:40:55
+Description: Ticking entity
+
+java.lang.IllegalStateException: Accessing LegacyRandomSource from multiple threads
+	at net.minecraft.class_6677.method_39008(class_6677.java:51)
+	at net.minecraft.class_5819.method_43048(class_5819.java:66)
+	at net.minecraft.class_1308.handler$zfe000$lithium$tickMovement(class_1308.java:2061)
+	at net.minecraft.class_1308.method_6007(class_1308.java:601)
+Caused by: java.lang.IllegalStateException: Accessed from another thread
+	at net.minecraft.class_6677.method_39010(class_6677.java:60)
+
+
+A detailed walkthrough of the error, its code path and all known details is as follows:
+---------------------------------------------------------------------------------------
+
+-- Head --
+Thread: Server thread
+Stacktrace:
+	at net.minecraft.class_6677.method_39008(class_6677.java:51)
+
+-- System Details --
+Details:
+	Minecraft Version: 1.20.1
+	Minecraft Version ID: 1.20.1
+	Operating System: Linux (amd64) version 6.1.0-18-amd64
+	Java Version: 17.0.10, Eclipse Adoptium
+	Fabric Mods: 
+		fabric-api: Fabric API 0.92.0+1.20.1
+		fabricloader: Fabric Loader 0.15.11
+		lithium: Lithium 0.11.2
+	Server Running: true
+`
+
+// quiltCrashReport is a crash report whose system details list the suspected
+// mods the way Fabric and Quilt list mods.
+const quiltCrashReport = `---- Minecraft Crash Report ----
+// Why did you do that?
+
+Time: 2024-06-20 08:03:12
+Description: Exception in server tick loop
+
+java.lang.StackOverflowError
+	at net.minecraft.class_2338.method_10093(class_2338.java:310)
+
+
+-- System Details --
+Details:
+	Minecraft Version: 1.20.1
+	Suspected Mods: 
+		sodium: Sodium 0.5.3+mc1.20.1
+			Issue tracker URL: https://github.com/CaffeineMC/sodium-fabric/issues
+		qsl: Quilt Standard Libraries 6.1.2+1.20.1
+	Quilt Mods: 
+		sodium: Sodium 0.5.3+mc1.20.1
+`
+
+func TestParseCrashReport(t *testing.T) {
+	tests := []struct {
+		name     string
+		report   string
+		want     CrashReport
+		frames   int
+		topFrame string
+	}{
+		{
+			name:   "forge",
+			report: forgeCrashReport,
+			want: CrashReport{
+				Time:          time.Date(2024, 3, 10, 21, 14, 7, 0, time.Local),
+				Description:   "Exception in server tick loop",
+				Exception:     `java.lang.NullPointerException: Cannot invoke "net.minecraft.world.level.block.state.BlockState.m_60734_()" because "state" is null`,
+				SuspectedMods: []string{"Create (create)"},
+			},
+			frames:   4,
+			topFrame: "TRANSFORMER/create@0.5.1.f/com.simibubi.create.content.kinetics.belt.BeltBlockEntity.tick(BeltBlockEntity.java:120) ~[create-1.20.1-0.5.1.f.jar%23197!/:0.5.1.f] {re:computing_frames,re:classloading}",
+		},
+		{
+			name:   "fabric mixin frame",
+			report: fabricCrashReport,
+			want: CrashReport{
+				Time:          time.Date(2024, 5, 2, 18, 40, 55, 0, time.Local),
+				Description:   "Ticking entity",
+				Exception:     "java.lang.IllegalStateException: Accessing LegacyRandomSource from multiple threads",
+				SuspectedMods: []string{"lithium"},
+			},
+			frames:   4,
+			topFrame: "net.minecraft.class_6677.method_39008(class_6677.java:51)",
+		},
+		{
+			name:   "quilt suspected mods",
+			report: quiltCrashReport,
+			want: CrashReport{
+				Time:          time.Date(2024, 6, 20, 8, 3, 12, 0, time.Local),
+				Description:   "Exception in server tick loop",
+				Exception:     "java.lang.StackOverflowError",
+				SuspectedMods: []string{"Sodium (sodium)", "Quilt Standard Libraries (qsl)"},
+			},
+			frames:   1,
+			topFrame: "net.minecraft.class_2338.method_10093(class_2338.java:310)",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// The time in the file name is overridden by the report.
+			path := filepath.Join(t.TempDir(), "crash-2020-01-01_00.00.00-server.txt")
+			if err := os.WriteFile(path, []byte(tc.report), 0644); err != nil {
+				t.Fatalf("failed to write crash report: %v", err)
+			}
+			got, err := ParseCrashReport(path)
+			if err != nil {
+				t.Fatalf("ParseCrashReport() failed: %v", err)
+			}
+			if got.File != filepath.Base(path) {
+				t.Errorf("File = %q, want %q", got.File, filepath.Base(path))
+			}
+			if !got.Time.Equal(tc.want.Time) {
+				t.Errorf("Time = %v, want %v", got.Time, tc.want.Time)
+			}
+			if got.Description != tc.want.Description {
+				t.Errorf("Description = %q, want %q", got.Description, tc.want.Description)
+			}
+			if got.Exception != tc.want.Exception {
+				t.Errorf("Exception = %q, want %q", got.Exception, tc.want.Exception)
+			}
+			if !slices.Equal(got.SuspectedMods, tc.want.SuspectedMods) {
+				t.Errorf("SuspectedMods = %q, want %q", got.SuspectedMods, tc.want.SuspectedMods)
+			}
+			if len(got.Frames) != tc.frames || got.Frames[0] != tc.topFrame {
+				t.Errorf("Frames = %q, want %d frames starting with %q", got.Frames, tc.frames, tc.topFrame)
+			}
+		})
+	}
+}
+
+func TestParseCrashReportFileTime(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "crash-2024-01-02_12.34.56-server.txt")
+	if err := os.WriteFile(path, []byte("Description: Watching Server\n\njava.lang.Error: ServerHangWatchdog detected that a single server tick took 60.00 seconds\n"), 0644); err != nil {
+		t.Fatalf("failed to write crash report: %v", err)
+	}
+	got, err := ParseCrashReport(path)
+	if err != nil {
+		t.Fatalf("ParseCrashReport() failed: %v", err)
+	}
+	if want := time.Date(2024, 1, 2, 12, 34, 56, 0, time.Local); !got.Time.Equal(want) {
+		t.Errorf("Time = %v, want %v", got.Time, want)
+	}
+}
@@ -0,0 +1,493 @@
+package server
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/common"
+	"github.com/dranilew/minecraft-server-manager/src/lib/fileutil"
+	"github.com/dranilew/minecraft-server-manager/src/lib/logger"
+	"github.com/dranilew/minecraft-server-manager/src/lib/run"
+	"github.com/dranilew/minecraft-server-manager/src/lib/server/properties"
+)
+
+const (
+	// LoaderPack is a server pack zip.
+	LoaderPack = "pack"
+	// LoaderVanilla is a vanilla server jar.
+	LoaderVanilla = "vanilla"
+	// LoaderFabric is a Fabric installer or server launcher jar.
+	LoaderFabric = "fabric"
+	// LoaderForge is a Forge installer jar.
+	LoaderForge = "forge"
+	// LoaderNeoForge is a NeoForge installer jar.
+	LoaderNeoForge = "neoforge"
+
+	// runScript is the script that starts a server.
+	runScript = "run.sh"
+	// eulaFile is the file in which the EULA is accepted.
+	eulaFile = "eula.txt"
+	// installTimeout is how long loader installers may run.
+	installTimeout = 30 * time.Minute
+)
+
+var (
+	// javaPath is the java binary used to run installers and servers.
+	javaPath = flag.String("java", "java", "Java binary used to run loader installers and newly created servers.")
+
+	// Loaders are the supported server sources.
+	Loaders = []string{LoaderPack, LoaderVanilla, LoaderFabric, LoaderForge, LoaderNeoForge}
+	// serverNameRegex matches valid server names.
+	serverNameRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+	// startScripts are the start scripts shipped by server packs, in order of
+	// preference.
+	startScripts = []string{"run.sh", "startserver.sh", "start.sh", "ServerStart.sh", "start-server.sh"}
+)
+
+// CreateRequest is a request to provision a new server.
+type CreateRequest struct {
+	// Server is the name of the new server.
+	Server string
+	// Source is the path of a server pack zip, or an installer or server jar,
+	// on the manager's host.
+	Source string
+	// Loader is the type of the source. It is detected from the source if empty.
+	Loader string
+	// AcceptEULA accepts the minecraft EULA on behalf of the operator.
+	AcceptEULA bool
+}
+
+// Create provisions a new server from a server pack or loader installer, and
+// registers it without starting it. Progress is written to out.
+func Create(ctx context.Context, req CreateRequest, out func(string) error) error {
+	if err := checkNewServer(req.Server); err != nil {
+		return err
+	}
+	if !filepath.IsAbs(req.Source) {
+		return fmt.Errorf("source %q must be an absolute path", req.Source)
+	}
+	loader := req.Loader
+	if loader == "" {
+		var err error
+		if loader, err = detectLoader(req.Source); err != nil {
+			return err
+		}
+	} else if !slices.Contains(Loaders, loader) {
+		return fmt.Errorf("invalid loader %q, must be one of %v", loader, Loaders)
+	}
+	if err := out(fmt.Sprintf("Creating %s server %q from %s", loader, req.Server, req.Source)); err != nil {
+		return err
+	}
+
+	tmpDir, err := stagingDir(req.Server)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	if err := install(ctx, loader, req.Source, tmpDir, out); err != nil {
+		return err
+	}
+
+	eula := "eula=false\n"
+	if req.AcceptEULA {
+		eula = "eula=true\n"
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, eulaFile), []byte(eula), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", eulaFile, err)
+	}
+
+	ports, err := installServer(req.Server, tmpDir)
+	if err != nil {
+		return err
+	}
+	logger.Printf("Created server %q with ports %v", req.Server, ports)
+	if err := out(fmt.Sprintf("Created server %q with %v", req.Server, ports)); err != nil {
+		return err
+	}
+	if !req.AcceptEULA {
+		return out(fmt.Sprintf("The EULA is not accepted, set eula=true in %s before starting the server", filepath.Join(common.ServerDirectory(req.Server), eulaFile)))
+	}
+	return nil
+}
+
+// checkNewServer returns an error if the name isn't valid for a new server,
+// or a server by that name already exists.
+func checkNewServer(server string) error {
+	if !serverNameRegex.MatchString(server) {
+		return fmt.Errorf("invalid server name %q", server)
+	}
+	common.ServerStatusesMu.Lock()
+	_, registered := common.ServerStatuses[server]
+	common.ServerStatusesMu.Unlock()
+	if _, err := os.Stat(common.ServerDirectory(server)); registered || err == nil {
+		return fmt.Errorf("server %q already exists", server)
+	}
+	return nil
+}
+
+// stagingDir creates a temporary directory in the modpack directory to lay
+// out a new server in, so that failed attempts don't leave a half-built
+// server behind. The caller must remove it.
+func stagingDir(server string) (string, error) {
+	dir, err := os.MkdirTemp(*common.ModpackLocation, "."+server+".creating-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create server directory: %v", err)
+	}
+	if err := os.Chmod(dir, 0755); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("failed to set mode of server directory: %v", err)
+	}
+	return dir, nil
+}
+
+// installServer gives the new server laid out in the staging directory a
+// free block of ports, moves it in place and registers it as stopped.
+func installServer(server, stagingDir string) (Ports, error) {
+	// Reserve the ports and register the server along with moving it in place,
+	// so that no other server can take the ports in between.
+	common.ServerStatusesMu.Lock()
+	ports, err := allocatePorts(server)
+	if err == nil {
+		err = seedProperties(stagingDir, server, ports)
+	}
+	if err == nil {
+		if err = os.Rename(stagingDir, common.ServerDirectory(server)); err != nil {
+			err = fmt.Errorf("failed to move server into place: %v", err)
+		}
+	}
+	if err != nil {
+		common.ServerStatusesMu.Unlock()
+		return Ports{}, err
+	}
+	status := &common.ServerStatus{Name: server, Port: ports.Game, QueryPort: ports.Query, RCONPort: ports.RCON}
+	status.SetState(common.StateStopped)
+	common.ServerStatuses[server] = status
+	common.ServerStatusesMu.Unlock()
+	if err := common.UpdateServerStatus(); err != nil {
+		return Ports{}, fmt.Errorf("failed to update server status: %v", err)
+	}
+
+	common.BackupStatusesMu.Lock()
+	common.BackupStatuses[server] = true
+	common.BackupStatusesMu.Unlock()
+	if err := common.UpdateBackupStatus(); err != nil {
+		return Ports{}, fmt.Errorf("failed to update backup status: %v", err)
+	}
+	return ports, nil
+}
+
+// detectLoader determines the type of the source from its contents.
+func detectLoader(source string) (string, error) {
+	r, err := zip.OpenReader(source)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %q: %v", source, err)
+	}
+	defer r.Close()
+	if !strings.HasSuffix(strings.ToLower(source), ".jar") {
+		return LoaderPack, nil
+	}
+
+	var names []string
+	for _, f := range r.File {
+		names = append(names, f.Name)
+	}
+	has := func(prefix string) bool {
+		return slices.ContainsFunc(names, func(name string) bool { return strings.HasPrefix(name, prefix) })
+	}
+	switch {
+	case has("net/neoforged/"), has("data/neoforge"):
+		return LoaderNeoForge, nil
+	case has("net/minecraftforge/installer/"):
+		return LoaderForge, nil
+	case has("net/fabricmc/"):
+		return LoaderFabric, nil
+	case has("net/minecraft/bundler/"), has("net/minecraft/server/"):
+		return LoaderVanilla, nil
+	}
+	return "", fmt.Errorf("failed to detect the loader of %q, pass it explicitly", source)
+}
+
+// install lays out the server from the source in the directory, and makes
+// sure it has a run script.
+func install(ctx context.Context, loader, source, dir string, out func(string) error) error {
+	switch loader {
+	case LoaderPack:
+		if err := out("Extracting server pack"); err != nil {
+			return err
+		}
+		if err := extractZip(source, dir, true); err != nil {
+			return err
+		}
+		return preparePack(ctx, dir, out)
+	case LoaderVanilla:
+		if err := copyFile(source, filepath.Join(dir, "server.jar")); err != nil {
+			return err
+		}
+		return writeRunScript(dir, "server.jar")
+	case LoaderFabric:
+		return installFabric(ctx, source, dir, out)
+	default:
+		return installForge(ctx, loader, source, dir, out)
+	}
+}
+
+// extractZip extracts the zip into the directory. With strip, zips that
+// contain a single top-level directory are extracted from within it.
+func extractZip(source, dir string, strip bool) error {
+	r, err := zip.OpenReader(source)
+	if err != nil {
+		return fmt.Errorf("failed to open %q: %v", source, err)
+	}
+	defer r.Close()
+
+	// Find a common top-level directory.
+	var prefix string
+	for i, f := range r.File {
+		if !strip {
+			break
+		}
+		top, _, nested := strings.Cut(f.Name, "/")
+		if !nested || (i > 0 && top+"/" != prefix) {
+			prefix = ""
+			break
+		}
+		prefix = top + "/"
+	}
+
+	for _, f := range r.File {
+		name := strings.TrimPrefix(f.Name, prefix)
+		if name == "" {
+			continue
+		}
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if !strings.HasPrefix(path, filepath.Clean(dir)+string(os.PathSeparator)) {
+			return fmt.Errorf("%q contains invalid path %q", source, f.Name)
+		}
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := extractFile(f, path); err != nil {
+			return fmt.Errorf("failed to extract %q: %v", f.Name, err)
+		}
+	}
+	return nil
+}
+
+// extractFile extracts a single file from a zip.
+func extractFile(f *zip.File, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	src, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	mode := os.FileMode(0644)
+	if f.Mode()&0111 != 0 || strings.HasSuffix(path, ".sh") {
+		mode = 0755
+	}
+	dst, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// preparePack makes sure the extracted server pack has a run script, by
+// wrapping its own start script, running its loader installer, or running
+// its server jar.
+func preparePack(ctx context.Context, dir string, out func(string) error) error {
+	for _, script := range startScripts {
+		if _, err := os.Stat(filepath.Join(dir, script)); err != nil {
+			continue
+		}
+		if script == runScript {
+			return nil
+		}
+		contents := fmt.Sprintf("#!/bin/sh\ncd \"$(dirname \"$0\")\"\nexec sh ./%s \"$@\"\n", script)
+		return os.WriteFile(filepath.Join(dir, runScript), []byte(contents), 0755)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, ".jar") || !strings.Contains(name, "installer") {
+			continue
+		}
+		loader, err := detectLoader(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		if loader == LoaderForge || loader == LoaderNeoForge {
+			return installForge(ctx, loader, filepath.Join(dir, name), dir, out)
+		}
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasSuffix(name, ".jar") && (name == "server.jar" || strings.Contains(name, "server")) {
+			return writeRunScript(dir, name)
+		}
+	}
+	return fmt.Errorf("server pack has no start script, installer or server jar")
+}
+
+// installFabric installs a Fabric server. Fabric installers download the
+// server launcher, while server launchers are used as is.
+func installFabric(ctx context.Context, source, dir string, out func(string) error) error {
+	r, err := zip.OpenReader(source)
+	if err != nil {
+		return fmt.Errorf("failed to open %q: %v", source, err)
+	}
+	isInstaller := slices.ContainsFunc(r.File, func(f *zip.File) bool {
+		return strings.HasPrefix(f.Name, "net/fabricmc/installer/")
+	})
+	r.Close()
+
+	if !isInstaller {
+		if err := copyFile(source, filepath.Join(dir, "fabric-server-launch.jar")); err != nil {
+			return err
+		}
+		return writeRunScript(dir, "fabric-server-launch.jar")
+	}
+	if err := out("Running Fabric installer"); err != nil {
+		return err
+	}
+	if err := runInstaller(ctx, dir, source, "server", "-dir", dir, "-downloadMinecraft"); err != nil {
+		return err
+	}
+	return writeRunScript(dir, "fabric-server-launch.jar")
+}
+
+// installForge runs a Forge or NeoForge installer. Recent versions generate
+// their own run script, while older versions only install a server jar.
+func installForge(ctx context.Context, loader, source, dir string, out func(string) error) error {
+	installer := filepath.Join(dir, filepath.Base(source))
+	if filepath.Dir(source) != filepath.Clean(dir) {
+		if err := copyFile(source, installer); err != nil {
+			return err
+		}
+	}
+	if err := out(fmt.Sprintf("Running %s installer, this may take a few minutes", loader)); err != nil {
+		return err
+	}
+	if err := runInstaller(ctx, dir, installer, "--installServer"); err != nil {
+		return err
+	}
+	os.Remove(installer)
+	os.Remove(installer + ".log")
+
+	if _, err := os.Stat(filepath.Join(dir, runScript)); err == nil {
+		return os.Chmod(filepath.Join(dir, runScript), 0755)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasSuffix(name, ".jar") && (strings.HasPrefix(name, "forge-") || strings.HasPrefix(name, "neoforge-")) {
+			return writeRunScript(dir, name)
+		}
+	}
+	return fmt.Errorf("%s installer did not produce a run script or server jar", loader)
+}
+
+// runInstaller runs the installer jar with the arguments in the directory.
+func runInstaller(ctx context.Context, dir, installer string, args ...string) error {
+	opts := run.Options{
+		Name:       *javaPath,
+		Args:       append([]string{"-jar", installer}, args...),
+		Dir:        dir,
+		OutputType: run.OutputCombined,
+		Timeout:    installTimeout,
+	}
+	if _, err := run.WithContext(ctx, opts); err != nil {
+		return fmt.Errorf("installer %q failed: %v", filepath.Base(installer), err)
+	}
+	return nil
+}
+
+// writeRunScript writes a run script starting the server jar with the
+// default JVM.
+func writeRunScript(dir, jar string) error {
+	return writeIfChanged(filepath.Join(dir, runScript), launchScript(*javaPath, nil, jar), 0755)
+}
+
+// seedProperties writes the ports and a default MOTD to the server.properties
+// in the directory, creating it if needed.
+func seedProperties(dir, server string, ports Ports) error {
+	path := filepath.Join(dir, properties.FileName)
+	props, err := properties.Load(path)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		props, _ = properties.Parse(nil)
+	}
+	if _, ok := props.Get("motd"); !ok {
+		props.Set("motd", server)
+	}
+	props.Set("server-port", strconv.Itoa(ports.Game))
+	props.Set("query.port", strconv.Itoa(ports.Query))
+	props.Set("rcon.port", strconv.Itoa(ports.RCON))
+	return props.Save(path)
+}
+
+// copyFile copies the file at src to dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open %q: %v", src, err)
+	}
+	defer in.Close()
+	outFile, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create %q: %v", dst, err)
+	}
+	if _, err := io.Copy(outFile, in); err != nil {
+		outFile.Close()
+		return fmt.Errorf("failed to copy %q: %v", src, err)
+	}
+	return outFile.Close()
+}
+
+// replaceWithCopy replaces dst with a copy of src, keeping the owner of dst.
+// Unlike copyFile, symbolic links are never followed: src must be a regular
+// file, and a link at dst is replaced.
+func replaceWithCopy(src, dst string) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return fmt.Errorf("failed to read %q: %v", src, err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%q is not a regular file", src)
+	}
+	contents, err := os.ReadFile(src)
+	if err != nil {
+		return fmt.Errorf("failed to read %q: %v", src, err)
+	}
+	return fileutil.WriteFile(dst, contents, 0644)
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/common"
+)
+
+var (
+	// commandErrors are the messages minecraft responds with when a command fails.
+	commandErrors = []string{
+		"Unknown or incomplete command",
+		"Unknown command",
+		"Incorrect argument for command",
+	}
+)
+
+// ExecRequest is a request to run a console command on one or more servers.
+type ExecRequest struct {
+	// Servers is the list of servers to run the command on.
+	Servers []string
+	// All runs the command on all running servers.
+	All bool
+	// Tag runs the command on all running servers with the tag.
+	Tag string
+	// Command is the console command to run.
+	Command string
+}
+
+// ExecResult is the result of running a console command on a single server.
+type ExecResult struct {
+	// Server is the server the command ran on.
+	Server string
+	// Output is the output of the command.
+	Output string
+	// Error is the reason the command failed. This is empty if the command succeeded.
+	Error string `json:",omitempty"`
+}
+
+// Select returns the registered servers selected by name, by tag, or all
+// registered servers. Selecting servers by name does not check whether they
+// are registered.
+func Select(servers []string, all bool, tag string) []string {
+	res := slices.Clone(servers)
+	if !all && tag == "" {
+		return res
+	}
+	common.ServerStatusesMu.Lock()
+	defer common.ServerStatusesMu.Unlock()
+	for name, status := range common.ServerStatuses {
+		if all || slices.Contains(Tags(status), tag) {
+			res = append(res, name)
+		}
+	}
+	slices.Sort(res)
+	return slices.Compact(res)
+}
+
+// Exec runs the console command on all servers in the request concurrently,
+// and returns the result for each server.
+func Exec(ctx context.Context, req ExecRequest) ([]ExecResult, error) {
+	if strings.TrimSpace(req.Command) == "" {
+		return nil, fmt.Errorf("no command given")
+	}
+	runningServers, err := GetRunningServers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get running servers: %v", err)
+	}
+
+	// Servers selected by tag or all servers are skipped if they aren't running.
+	servers := Select(nil, req.All, req.Tag)
+	servers = slices.DeleteFunc(servers, func(server string) bool {
+		return !slices.Contains(runningServers, server)
+	})
+	servers = append(servers, req.Servers...)
+	slices.Sort(servers)
+	servers = slices.Compact(servers)
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("no servers selected")
+	}
+
+	results := make([]ExecResult, len(servers))
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		wg.Go(func() {
+			results[i] = ExecResult{Server: server}
+			if !slices.Contains(runningServers, server) {
+				results[i].Error = "server is not running"
+				return
+			}
+			output, err := CaptureCommand(ctx, server, req.Command)
+			results[i].Output = output
+			if err != nil {
+				results[i].Error = err.Error()
+				return
+			}
+			for _, msg := range commandErrors {
+				if strings.Contains(output, msg) {
+					results[i].Error = msg
+					return
+				}
+			}
+		})
+	}
+	wg.Wait()
+	return results, nil
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"slices"
+	"sync"
 	"time"
 
 	"github.com/dranilew/minecraft-server-manager/src/lib/common"
@@ -21,7 +22,8 @@ var (
 	// configurationFile is the expected configuration file name.
 	configurationFile = "scripts.yaml"
 	// currentConfigurations is the set of configurations that the server is currently managing.
-	currentConfigurations = make(map[string]*configuration)
+	currentConfigurations   = make(map[string]*configuration)
+	currentConfigurationsMu sync.Mutex
 )
 
 // configuration is the configuration file.
@@ -82,6 +84,8 @@ func readYaml(server string) error {
 	}
 
 	// If the configuration doesn't exist, initialize and return.
+	currentConfigurationsMu.Lock()
+	defer currentConfigurationsMu.Unlock()
 	currConf, ok := currentConfigurations[server]
 	if !ok {
 		currentConfigurations[server] = &conf
@@ -124,7 +128,9 @@ func ExtraScripts(ctx context.Context, server string) error {
 		return fmt.Errorf("failed to read yaml file: %v", err)
 	}
 
+	currentConfigurationsMu.Lock()
 	conf, ok := currentConfigurations[server]
+	currentConfigurationsMu.Unlock()
 	if !ok {
 		logger.Debugf("Configuration for server %q not found, skipping", server)
 		return nil
@@ -140,11 +146,17 @@ func ExtraScripts(ctx context.Context, server string) error {
 		if time.Since(script.LastRun) >= script.Interval {
 			logger.Debugf("Running script %q from %q for server %q", script.Name, scriptPath, server)
 			script.LastRun = time.Now()
+			cred, err := serverCredential(server)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
 			opts := run.Options{
 				Name:       scriptPath,
 				OutputType: run.OutputNone,
 				ExecMode:   run.ExecModeAsync,
 				Dir:        serverDir,
+				Credential: cred,
 			}
 			if _, err := run.WithContext(ctx, opts); err != nil {
 				errs = append(errs, err)
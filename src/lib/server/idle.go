@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/common"
+	"github.com/dranilew/minecraft-server-manager/src/lib/logger"
+)
+
+// RecordPlayers records the number of players online on the running server,
+// and returns whether its idle time changed.
+func RecordPlayers(server string, online int) bool {
+	common.ServerStatusesMu.Lock()
+	defer common.ServerStatusesMu.Unlock()
+	status, ok := common.ServerStatuses[server]
+	if !ok {
+		return false
+	}
+	switch {
+	case online > 0 && !status.IdleSince.IsZero():
+		status.IdleSince = time.Time{}
+		return true
+	case online == 0 && status.IdleSince.IsZero():
+		status.IdleSince = time.Now()
+		return true
+	}
+	return false
+}
+
+// IdleServers returns the running servers that have had no players online for
+// longer than their idle policy allows.
+func IdleServers() []string {
+	common.ServerStatusesMu.Lock()
+	defer common.ServerStatusesMu.Unlock()
+	var servers []string
+	for name, status := range common.ServerStatuses {
+		if status.State != common.StateRunning || !status.ShouldRun || status.IdleSince.IsZero() {
+			continue
+		}
+		if m := manifestOf(name); m != nil && m.Idle != nil && time.Since(status.IdleSince) >= m.Idle.After {
+			servers = append(servers, name)
+		}
+	}
+	slices.Sort(servers)
+	return servers
+}
+
+// Sleep takes a final backup of the idle server with the given function,
+// stops it and marks it as sleeping. Sleeping servers are expected to run
+// again, but aren't recovered until they are started.
+func Sleep(ctx context.Context, server string, backup func(ctx context.Context, server, bucket string) error) error {
+	var policy IdlePolicy
+	if m := manifestOf(server); m != nil && m.Idle != nil {
+		policy = *m.Idle
+	}
+	common.ServerStatusesMu.Lock()
+	status, ok := common.ServerStatuses[server]
+	var idleSince time.Time
+	if ok {
+		idleSince = status.IdleSince
+	}
+	common.ServerStatusesMu.Unlock()
+	if !ok {
+		return fmt.Errorf("server %q is not registered", server)
+	}
+	logger.Printf("No players were online on server %q for %s, putting it to sleep", server, formatDuration(time.Since(idleSince).Round(time.Second)))
+
+	if policy.Bucket != "" {
+		if err := backup(ctx, server, policy.Bucket); err != nil {
+			return fmt.Errorf("failed to create final backup of server %q: %v", server, err)
+		}
+	}
+
+	// Players may have joined during the backup.
+	common.ServerStatusesMu.Lock()
+	idle := status.IdleSince.Equal(idleSince)
+	common.ServerStatusesMu.Unlock()
+	if !idle {
+		logger.Printf("Players joined server %q, keeping it running", server)
+		return nil
+	}
+
+	if err := Stop(ctx, StopRequest{Servers: []string{server}}); err != nil {
+		return err
+	}
+	runningServers, err := GetRunningServers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get running servers: %v", err)
+	}
+	if slices.Contains(runningServers, server) {
+		return fmt.Errorf("server %q did not stop", server)
+	}
+
+	common.ServerStatusesMu.Lock()
+	status.ShouldRun = true
+	status.IdleSince = time.Time{}
+	status.SetState(common.StateSleeping)
+	common.ServerStatusesMu.Unlock()
+	if err := common.UpdateServerStatus(); err != nil {
+		return fmt.Errorf("failed to update server status: %v", err)
+	}
+	if policy.Bucket != "" {
+		// The final backup is up to date.
+		common.BackupStatusesMu.Lock()
+		common.BackupStatuses[server] = false
+		common.BackupStatusesMu.Unlock()
+		if err := common.UpdateBackupStatus(); err != nil {
+			return fmt.Errorf("failed to update backup status: %v", err)
+		}
+	}
+	logger.Printf("Server %q is sleeping", server)
+	return nil
+}
@@ -0,0 +1,351 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/common"
+	"github.com/dranilew/minecraft-server-manager/src/lib/fileutil"
+)
+
+const (
+	// GCAikar is Aikar's tuned G1 flags, recommended for most servers.
+	GCAikar = "aikar"
+	// GCG1 is the G1 collector with default settings.
+	GCG1 = "g1"
+	// GCZGC is the Z collector, for large heaps. Java 21 and 22 only use its
+	// generational mode with -XX:+ZGenerational in the extra arguments.
+	GCZGC = "zgc"
+	// GCShenandoah is the Shenandoah collector.
+	GCShenandoah = "shenandoah"
+
+	// jvmArgsFile is the file Forge and NeoForge read JVM arguments from.
+	jvmArgsFile = "user_jvm_args.txt"
+	// largeHeap is the maximum heap size from which Aikar's flags use larger
+	// regions and young generation.
+	largeHeap = 12 << 30
+	// originalSuffix is the suffix of the copies of files kept from before
+	// the JVM settings were applied, which are restored on reset.
+	originalSuffix = ".orig"
+	// managedHeader marks files that are generated from the JVM settings.
+	managedHeader = "# Generated by the minecraft server manager from the JVM settings of the server.\n# Changes are overwritten, use mcctl server jvm instead.\n"
+)
+
+var (
+	// GCPresets are the supported garbage collector presets.
+	GCPresets = []string{GCAikar, GCG1, GCZGC, GCShenandoah}
+
+	// heapRegex matches heap sizes, like 512M or 8G.
+	heapRegex = regexp.MustCompile(`^([1-9][0-9]*)([KkMmGg]?)$`)
+	// jarRegex matches the server jar run by a run script.
+	jarRegex = regexp.MustCompile(`-jar\s+["']?([^\s"']+\.jar)`)
+	// argsFileJavaRegex matches the java binary of a run script that reads
+	// the JVM arguments file, like Forge's "java @user_jvm_args.txt ...".
+	argsFileJavaRegex = regexp.MustCompile(`(?m)^(\s*(?:exec\s+)?)('[^']*'|\S+)(\s+@` + regexp.QuoteMeta(jvmArgsFile) + `\b)`)
+	// shellSafeRegex matches arguments that don't need quoting in a shell.
+	shellSafeRegex = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+	// aikarFlags are Aikar's flags for heaps up to 12G, see
+	// https://docs.papermc.io/paper/aikars-flags.
+	aikarFlags = []string{
+		"-XX:+UseG1GC", "-XX:+ParallelRefProcEnabled", "-XX:MaxGCPauseMillis=200",
+		"-XX:+UnlockExperimentalVMOptions", "-XX:+DisableExplicitGC", "-XX:+AlwaysPreTouch",
+		"-XX:G1NewSizePercent=30", "-XX:G1MaxNewSizePercent=40", "-XX:G1HeapRegionSize=8M",
+		"-XX:G1ReservePercent=20", "-XX:G1HeapWastePercent=5", "-XX:G1MixedGCCountTarget=4",
+		"-XX:InitiatingHeapOccupancyPercent=15", "-XX:G1MixedGCLiveThresholdPercent=90",
+		"-XX:G1RSetUpdatingPauseTimePercent=5", "-XX:SurvivorRatio=32", "-XX:+PerfDisableSharedMem",
+		"-XX:MaxTenuringThreshold=1", "-Dusing.aikars.flags=https://mcflags.emc.gs", "-Daikars.new.flags=true",
+	}
+	// aikarLargeHeapFlags replace Aikar's flags for heaps above 12G.
+	aikarLargeHeapFlags = map[string]string{
+		"-XX:G1NewSizePercent":               "40",
+		"-XX:G1MaxNewSizePercent":            "50",
+		"-XX:G1HeapRegionSize":               "16M",
+		"-XX:G1ReservePercent":               "15",
+		"-XX:InitiatingHeapOccupancyPercent": "20",
+	}
+)
+
+// JVMRequest is a request to change the JVM settings of a server.
+type JVMRequest struct {
+	// Server is the server whose JVM settings to change.
+	Server string
+	// Settings are the new JVM settings. Unset fields use the defaults.
+	Settings common.JVMSettings
+	// Reset stops managing the JVM settings, and restores the files from
+	// before the settings were applied.
+	Reset bool
+}
+
+// ValidateJVM returns an error if the JVM settings are invalid.
+func ValidateJVM(settings common.JVMSettings) error {
+	for _, heap := range []string{settings.MinHeap, settings.MaxHeap} {
+		if heap != "" && !heapRegex.MatchString(heap) {
+			return fmt.Errorf("invalid heap size %q, must be like 512M or 8G", heap)
+		}
+	}
+	if settings.MinHeap != "" && settings.MaxHeap != "" && heapBytes(settings.MinHeap) > heapBytes(settings.MaxHeap) {
+		return fmt.Errorf("minimum heap size %s is larger than the maximum heap size %s", settings.MinHeap, settings.MaxHeap)
+	}
+	if settings.GC != "" && !slices.Contains(GCPresets, settings.GC) {
+		return fmt.Errorf("invalid GC preset %q, must be one of %v", settings.GC, GCPresets)
+	}
+	return nil
+}
+
+// heapBytes returns the size in bytes of the valid heap size.
+func heapBytes(heap string) int64 {
+	match := heapRegex.FindStringSubmatch(heap)
+	n, _ := strconv.ParseInt(match[1], 10, 64)
+	switch strings.ToUpper(match[2]) {
+	case "K":
+		n <<= 10
+	case "M":
+		n <<= 20
+	case "G":
+		n <<= 30
+	}
+	return n
+}
+
+// JavaPath returns the java binary of the JVM settings.
+func JavaPath(settings common.JVMSettings) string {
+	return cmp.Or(settings.Java, *javaPath)
+}
+
+// JVMArgs returns the JVM arguments of the JVM settings.
+func JVMArgs(settings common.JVMSettings) []string {
+	var args []string
+	if settings.MinHeap != "" {
+		args = append(args, "-Xms"+settings.MinHeap)
+	}
+	if settings.MaxHeap != "" {
+		args = append(args, "-Xmx"+settings.MaxHeap)
+	}
+	switch settings.GC {
+	case GCAikar:
+		large := settings.MaxHeap != "" && heapBytes(settings.MaxHeap) > largeHeap
+		for _, flag := range aikarFlags {
+			name, _, _ := strings.Cut(flag, "=")
+			if value, ok := aikarLargeHeapFlags[name]; ok && large {
+				flag = name + "=" + value
+			}
+			args = append(args, flag)
+		}
+	case GCG1:
+		args = append(args, "-XX:+UseG1GC")
+	case GCZGC:
+		args = append(args, "-XX:+UseZGC")
+	case GCShenandoah:
+		args = append(args, "-XX:+UseShenandoahGC")
+	}
+	return append(args, settings.ExtraArgs...)
+}
+
+// SetJVM changes the JVM settings of a registered server, and applies them
+// to its run script. Progress is written to out.
+func SetJVM(ctx context.Context, req JVMRequest, out func(string) error) error {
+	if !req.Reset {
+		if err := ValidateJVM(req.Settings); err != nil {
+			return err
+		}
+	}
+	if m := manifestOf(req.Server); m != nil && m.JVM != nil {
+		return fmt.Errorf("JVM settings of server %q are set in its %s", req.Server, ManifestFile)
+	}
+	common.ServerStatusesMu.Lock()
+	status, ok := common.ServerStatuses[req.Server]
+	if !ok {
+		common.ServerStatusesMu.Unlock()
+		return fmt.Errorf("server %q is not registered", req.Server)
+	}
+	previous := status.JVM
+	if req.Reset {
+		status.JVM = nil
+	} else {
+		settings := req.Settings
+		status.JVM = &settings
+	}
+	common.ServerStatusesMu.Unlock()
+	if err := common.UpdateServerStatus(); err != nil {
+		return fmt.Errorf("failed to update server status: %v", err)
+	}
+	if req.Reset {
+		restored, err := restoreOriginals(common.ServerDirectory(req.Server))
+		if err != nil {
+			return err
+		}
+		if len(restored) == 0 {
+			return out(fmt.Sprintf("JVM settings of server %q are no longer managed, its run script is left as is", req.Server))
+		}
+		return out(fmt.Sprintf("JVM settings of server %q are no longer managed, restored %s", req.Server, strings.Join(restored, " and ")))
+	}
+
+	file, _, err := applyJVM(req.Server)
+	if err != nil {
+		// Keep the settings that could be applied.
+		common.ServerStatusesMu.Lock()
+		status.JVM = previous
+		common.ServerStatusesMu.Unlock()
+		return errors.Join(err, common.UpdateServerStatus())
+	}
+	if err := out(fmt.Sprintf("Wrote the JVM settings of server %q to %s", req.Server, file)); err != nil {
+		return err
+	}
+	runningServers, err := GetRunningServers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get running servers: %v", err)
+	}
+	if slices.Contains(runningServers, req.Server) {
+		return out(fmt.Sprintf("Restart server %q for the change to take effect", req.Server))
+	}
+	return nil
+}
+
+// applyJVM applies the JVM settings of the server, if any, and returns the
+// file they were written to and whether any file changed. Servers whose run
+// script reads the JVM arguments file, like Forge and NeoForge, get the
+// arguments rendered into it. Servers running a server jar get their run
+// script rewritten.
+func applyJVM(server string) (string, bool, error) {
+	common.ServerStatusesMu.Lock()
+	var settings *common.JVMSettings
+	if status, ok := common.ServerStatuses[server]; ok {
+		settings, _ = EffectiveJVM(status)
+	}
+	common.ServerStatusesMu.Unlock()
+	if settings == nil {
+		return "", false, nil
+	}
+
+	dir := common.ServerDirectory(server)
+	scriptPath := filepath.Join(dir, runScript)
+	script, err := os.ReadFile(scriptPath)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read %s: %v", runScript, err)
+	}
+	java := shellQuote(JavaPath(*settings))
+	args := JVMArgs(*settings)
+
+	if argsFileJavaRegex.Match(script) {
+		var b strings.Builder
+		b.WriteString(managedHeader)
+		for _, arg := range args {
+			b.WriteString(argFileQuote(arg) + "\n")
+		}
+		argsChanged, err := rewrite(filepath.Join(dir, jvmArgsFile), []byte(b.String()), 0644)
+		if err != nil {
+			return "", false, err
+		}
+		updated := argsFileJavaRegex.ReplaceAllFunc(script, func(line []byte) []byte {
+			match := argsFileJavaRegex.FindSubmatch(line)
+			return []byte(string(match[1]) + java + string(match[3]))
+		})
+		scriptChanged, err := rewrite(scriptPath, updated, 0755)
+		if err != nil {
+			return "", false, err
+		}
+		return jvmArgsFile, argsChanged || scriptChanged, nil
+	}
+
+	match := jarRegex.FindSubmatch(script)
+	if match == nil {
+		return "", false, fmt.Errorf("%s of server %q neither reads %s nor runs a server jar, so JVM settings can't be applied", runScript, server, jvmArgsFile)
+	}
+	changed, err := rewrite(scriptPath, launchScript(JavaPath(*settings), args, string(match[1])), 0755)
+	if err != nil {
+		return "", false, err
+	}
+	return runScript, changed, nil
+}
+
+// launchScript returns a run script starting the server jar with the java
+// binary and JVM arguments.
+func launchScript(java string, args []string, jar string) []byte {
+	command := []string{"exec", shellQuote(java)}
+	for _, arg := range args {
+		command = append(command, shellQuote(arg))
+	}
+	command = append(command, "-jar", shellQuote(jar), "nogui", `"$@"`)
+	var b strings.Builder
+	b.WriteString("#!/bin/sh\n")
+	if len(args) > 0 {
+		b.WriteString(managedHeader)
+	}
+	b.WriteString("cd \"$(dirname \"$0\")\"\n")
+	b.WriteString(strings.Join(command, " ") + "\n")
+	return []byte(b.String())
+}
+
+// rewrite writes the file generated from the JVM settings unless it already
+// has the contents, and returns whether it was written. Before the file is
+// first rewritten, it is kept aside so that it can be restored on reset. The
+// copy is refreshed if a generated file was replaced, like by a loader update.
+func rewrite(path string, contents []byte, mode os.FileMode) (bool, error) {
+	current, err := os.ReadFile(path)
+	if err == nil && string(current) == string(contents) {
+		return false, nil
+	}
+	original := path + originalSuffix
+	if info, err := os.Lstat(path); err == nil && info.Mode().IsRegular() {
+		_, err := os.Lstat(original)
+		replaced := strings.Contains(string(contents), managedHeader) && !strings.Contains(string(current), managedHeader)
+		if errors.Is(err, os.ErrNotExist) || replaced {
+			if err := fileutil.WriteFile(original, current, info.Mode().Perm()); err != nil {
+				return false, fmt.Errorf("failed to keep original %s: %v", filepath.Base(path), err)
+			}
+		}
+	}
+	return true, writeIfChanged(path, contents, mode)
+}
+
+// restoreOriginals restores the files kept aside before the JVM settings were
+// applied, and returns the names of the restored files.
+func restoreOriginals(dir string) ([]string, error) {
+	var restored []string
+	for _, name := range []string{runScript, jvmArgsFile} {
+		path := filepath.Join(dir, name)
+		if err := os.Rename(path+originalSuffix, path); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return restored, fmt.Errorf("failed to restore %s: %v", name, err)
+		}
+		restored = append(restored, name)
+	}
+	return restored, nil
+}
+
+// writeIfChanged writes the file unless it already has the contents. The file
+// is replaced rather than written in place, as server directories may be
+// owned by the servers' users.
+func writeIfChanged(path string, contents []byte, mode os.FileMode) error {
+	if current, err := os.ReadFile(path); err == nil && string(current) == string(contents) {
+		return nil
+	}
+	return fileutil.WriteFile(path, contents, mode)
+}
+
+// shellQuote quotes the argument for a POSIX shell.
+func shellQuote(arg string) string {
+	if shellSafeRegex.MatchString(arg) {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// argFileQuote quotes the argument for a java arguments file.
+func argFileQuote(arg string) string {
+	if !strings.ContainsAny(arg, " \t\"'#\\") {
+		return arg
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+}
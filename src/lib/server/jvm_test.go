@@ -0,0 +1,197 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/common"
+)
+
+// registerServer registers the server for the duration of the test.
+func registerServer(t *testing.T, server string) {
+	t.Helper()
+	common.ServerStatusesMu.Lock()
+	common.ServerStatuses[server] = &common.ServerStatus{Name: server}
+	common.ServerStatusesMu.Unlock()
+	t.Cleanup(func() {
+		common.ServerStatusesMu.Lock()
+		delete(common.ServerStatuses, server)
+		common.ServerStatusesMu.Unlock()
+	})
+}
+
+// readFile returns the contents of the file, or fails the test.
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", filepath.Base(path), err)
+	}
+	return string(contents)
+}
+
+func TestSetJVMKeepsOriginals(t *testing.T) {
+	const forgeScript = "#!/usr/bin/env sh\njava @user_jvm_args.txt @libraries/forge/unix_args.txt \"$@\"\n"
+	tests := []struct {
+		name   string
+		files  map[string]string
+		edited []string
+	}{
+		{
+			name:   "server jar",
+			files:  map[string]string{runScript: "#!/bin/sh\njava -Xmx2G -jar server.jar nogui\n"},
+			edited: []string{runScript},
+		},
+		{
+			name:   "jvm arguments file",
+			files:  map[string]string{runScript: forgeScript, jvmArgsFile: "# Add your JVM arguments here\n-Xmx4G\n"},
+			edited: []string{runScript, jvmArgsFile},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			setupFakeServer(t, "test", "")
+			registerServer(t, "test")
+			dir := common.ServerDirectory("test")
+			for name, contents := range tc.files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0755); err != nil {
+					t.Fatalf("failed to write %s: %v", name, err)
+				}
+			}
+			ctx := context.Background()
+			out := func(string) error { return nil }
+
+			req := JVMRequest{Server: "test", Settings: common.JVMSettings{Java: "/opt/java/bin/java", MaxHeap: "8G", GC: GCAikar}}
+			if err := SetJVM(ctx, req, out); err != nil {
+				t.Fatalf("SetJVM() failed: %v", err)
+			}
+			for _, name := range tc.edited {
+				path := filepath.Join(dir, name)
+				if got := readFile(t, path); got == tc.files[name] {
+					t.Errorf("SetJVM() left %s unchanged", name)
+				}
+				if got := readFile(t, path+originalSuffix); got != tc.files[name] {
+					t.Errorf("%s%s = %q, want %q", name, originalSuffix, got, tc.files[name])
+				}
+			}
+			if !strings.Contains(readFile(t, filepath.Join(dir, runScript)), "/opt/java/bin/java") {
+				t.Errorf("SetJVM() didn't write the java binary to %s", runScript)
+			}
+
+			// Applying the same settings again changes nothing.
+			if _, changed, err := applyJVM("test"); err != nil || changed {
+				t.Errorf("applyJVM() = (%t, %v), want (false, nil)", changed, err)
+			}
+
+			// Changing the settings keeps the original files.
+			req.Settings.MaxHeap = "16G"
+			if err := SetJVM(ctx, req, out); err != nil {
+				t.Fatalf("SetJVM() failed: %v", err)
+			}
+			for _, name := range tc.edited {
+				if got := readFile(t, filepath.Join(dir, name+originalSuffix)); got != tc.files[name] {
+					t.Errorf("%s%s = %q, want %q", name, originalSuffix, got, tc.files[name])
+				}
+			}
+
+			if err := SetJVM(ctx, JVMRequest{Server: "test", Reset: true}, out); err != nil {
+				t.Fatalf("SetJVM() with reset failed: %v", err)
+			}
+			for name, want := range tc.files {
+				path := filepath.Join(dir, name)
+				if got := readFile(t, path); got != want {
+					t.Errorf("%s after reset = %q, want %q", name, got, want)
+				}
+				info, err := os.Stat(path)
+				if err != nil {
+					t.Fatalf("failed to stat %s: %v", name, err)
+				}
+				if info.Mode().Perm() != 0755 {
+					t.Errorf("mode of %s after reset = %v, want %v", name, info.Mode().Perm(), os.FileMode(0755))
+				}
+				if _, err := os.Stat(path + originalSuffix); !os.IsNotExist(err) {
+					t.Errorf("%s%s still exists after reset", name, originalSuffix)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyJVMAfterLoaderUpdate(t *testing.T) {
+	setupFakeServer(t, "test", "")
+	registerServer(t, "test")
+	dir := common.ServerDirectory("test")
+	script := filepath.Join(dir, runScript)
+	if err := os.WriteFile(script, []byte("#!/bin/sh\njava -jar server-1.0.jar nogui\n"), 0755); err != nil {
+		t.Fatalf("failed to write %s: %v", runScript, err)
+	}
+	req := JVMRequest{Server: "test", Settings: common.JVMSettings{MaxHeap: "8G"}}
+	if err := SetJVM(context.Background(), req, func(string) error { return nil }); err != nil {
+		t.Fatalf("SetJVM() failed: %v", err)
+	}
+
+	// The update replaces the generated run script.
+	updated := "#!/bin/sh\njava -jar server-1.1.jar nogui\n"
+	if err := os.WriteFile(script, []byte(updated), 0755); err != nil {
+		t.Fatalf("failed to write %s: %v", runScript, err)
+	}
+	if _, changed, err := applyJVM("test"); err != nil || !changed {
+		t.Fatalf("applyJVM() = (%t, %v), want (true, nil)", changed, err)
+	}
+	if got := readFile(t, script); !strings.Contains(got, "server-1.1.jar") || !strings.Contains(got, "-Xmx8G") {
+		t.Errorf("applyJVM() wrote %q, want the updated jar with the JVM settings", got)
+	}
+	if got := readFile(t, script+originalSuffix); got != updated {
+		t.Errorf("%s%s = %q, want %q", runScript, originalSuffix, got, updated)
+	}
+}
+
+func TestJVMArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings common.JVMSettings
+		want     []string
+	}{
+		{
+			name:     "heap",
+			settings: common.JVMSettings{MinHeap: "2G", MaxHeap: "8G"},
+			want:     []string{"-Xms2G", "-Xmx8G"},
+		},
+		{
+			// Java 17 doesn't know -XX:+ZGenerational, so it is left to the
+			// extra arguments.
+			name:     "zgc",
+			settings: common.JVMSettings{MaxHeap: "16G", GC: GCZGC},
+			want:     []string{"-Xmx16G", "-XX:+UseZGC"},
+		},
+		{
+			name:     "zgc generational",
+			settings: common.JVMSettings{GC: GCZGC, ExtraArgs: []string{"-XX:+ZGenerational"}},
+			want:     []string{"-XX:+UseZGC", "-XX:+ZGenerational"},
+		},
+		{
+			name:     "shenandoah",
+			settings: common.JVMSettings{GC: GCShenandoah},
+			want:     []string{"-XX:+UseShenandoahGC"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := JVMArgs(tc.settings); !slices.Equal(got, tc.want) {
+				t.Errorf("JVMArgs() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestJVMArgsAikarLargeHeap(t *testing.T) {
+	small := JVMArgs(common.JVMSettings{MaxHeap: "8G", GC: GCAikar})
+	large := JVMArgs(common.JVMSettings{MaxHeap: "16G", GC: GCAikar})
+	if !slices.Contains(small, "-XX:G1HeapRegionSize=8M") || !slices.Contains(large, "-XX:G1HeapRegionSize=16M") {
+		t.Errorf("JVMArgs() with Aikar's flags = %q for 8G and %q for 16G, want the large heap flags above 12G", small, large)
+	}
+}
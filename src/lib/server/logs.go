@@ -0,0 +1,309 @@
+package server
+
+import (
+	"bufio"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/common"
+)
+
+const (
+	// logsDir is the directory containing the server logs.
+	logsDir = "logs"
+	// latestLog is the log file currently written to by the server.
+	latestLog = "latest.log"
+	// followInterval is the interval at which followed logs are polled.
+	followInterval = 250 * time.Millisecond
+)
+
+var (
+	// rotatedLogRegex matches rotated log files, like 2024-01-02-3.log.gz.
+	rotatedLogRegex = regexp.MustCompile(`^([0-9]{4}-[0-9]{2}-[0-9]{2})-([0-9]+)\.log\.gz$`)
+	// logTimeRegex matches the timestamp at the start of a log line. Vanilla
+	// uses [12:34:56], while Forge uses [02Jan2024 12:34:56.789].
+	logTimeRegex = regexp.MustCompile(`^\[(?:([0-9]{2}[A-Za-z]{3}[0-9]{4}) )?([0-9]{2}:[0-9]{2}:[0-9]{2})`)
+)
+
+// LogsRequest is a request for the console logs of a server.
+type LogsRequest struct {
+	// Server is the server whose logs to read.
+	Server string
+	// Follow indicates whether to keep streaming new lines once all existing
+	// lines have been read.
+	Follow bool
+	// Since only returns lines logged within this duration. Zero returns all lines.
+	Since time.Duration
+	// Grep only returns lines matching this regular expression.
+	Grep string
+}
+
+// rotatedLog is a rotated log file.
+type rotatedLog struct {
+	name  string
+	date  string
+	index int
+}
+
+// Logs reads the server's logs, oldest first, and calls fn for every line that
+// matches the request. If the request follows the logs, this only returns once
+// the context is done or fn returns an error.
+func Logs(ctx context.Context, req LogsRequest, fn func(line string) error) error {
+	var grep *regexp.Regexp
+	if req.Grep != "" {
+		var err error
+		if grep, err = regexp.Compile(req.Grep); err != nil {
+			return fmt.Errorf("invalid grep pattern %q: %v", req.Grep, err)
+		}
+	}
+	var cutoff time.Time
+	if req.Since > 0 {
+		cutoff = time.Now().Add(-req.Since)
+	}
+	dir := filepath.Join(common.ServerDirectory(req.Server), logsDir)
+
+	// Find all rotated logs, sorted by their date and index.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read logs of server %q: %v", req.Server, err)
+	}
+	var rotated []rotatedLog
+	for _, entry := range entries {
+		match := rotatedLogRegex.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		index, _ := strconv.Atoi(match[2])
+		rotated = append(rotated, rotatedLog{name: entry.Name(), date: match[1], index: index})
+	}
+	slices.SortFunc(rotated, func(a, b rotatedLog) int {
+		return cmp.Or(cmp.Compare(a.date, b.date), cmp.Compare(a.index, b.index))
+	})
+
+	// Filter lines by time and pattern. Lines without a timestamp, like stack
+	// traces, are filtered along with the last timestamped line.
+	keep := cutoff.IsZero()
+	filter := func(modTime time.Time) func(string) error {
+		return func(line string) error {
+			if t, ok := logLineTime(line, modTime); ok {
+				keep = t.After(cutoff)
+			}
+			if !keep || (grep != nil && !grep.MatchString(line)) {
+				return nil
+			}
+			return fn(line)
+		}
+	}
+
+	for _, log := range rotated {
+		path := filepath.Join(dir, log.name)
+		info, err := os.Stat(path)
+		if err != nil || info.ModTime().Before(cutoff) {
+			continue
+		}
+		if err := readGzipLog(path, filter(info.ModTime())); err != nil {
+			return err
+		}
+	}
+
+	latest := filepath.Join(dir, latestLog)
+	var offset int64
+	if info, err := os.Stat(latest); err == nil {
+		if offset, err = readLog(latest, 0, filter(info.ModTime())); err != nil {
+			return err
+		}
+	}
+	if !req.Follow {
+		return nil
+	}
+	return FollowLog(ctx, latest, offset, func(line string) error {
+		if grep != nil && !grep.MatchString(line) {
+			return nil
+		}
+		return fn(line)
+	})
+}
+
+// logLineTime returns the time at which the line was logged. Vanilla lines
+// only contain the time of day, so the date is taken from the modification
+// time of the log file.
+func logLineTime(line string, modTime time.Time) (time.Time, bool) {
+	match := logTimeRegex.FindStringSubmatch(line)
+	if match == nil {
+		return time.Time{}, false
+	}
+	if match[1] != "" {
+		t, err := time.ParseInLocation("02Jan2006 15:04:05", match[1]+" "+match[2], time.Local)
+		return t, err == nil
+	}
+	clock, err := time.Parse("15:04:05", match[2])
+	if err != nil {
+		return time.Time{}, false
+	}
+	t := time.Date(modTime.Year(), modTime.Month(), modTime.Day(), clock.Hour(), clock.Minute(), clock.Second(), 0, time.Local)
+	// Lines logged before midnight in a file last modified after midnight.
+	if t.After(modTime.Add(time.Minute)) {
+		t = t.AddDate(0, 0, -1)
+	}
+	return t, true
+}
+
+// readGzipLog calls fn for every line in the gzipped log file.
+func readGzipLog(path string, fn func(string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %q: %v", path, err)
+	}
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("failed to decompress %q: %v", path, err)
+	}
+	defer r.Close()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		if err := fn(scanner.Text()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// readLog calls fn for every complete line in the log file starting at the
+// given offset, and returns the offset after the last complete line.
+func readLog(path string, offset int64, fn func(string) error) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return offset, fmt.Errorf("failed to open %q: %v", path, err)
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return offset, fmt.Errorf("failed to seek %q: %v", path, err)
+	}
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			// Partial lines are read again once they are complete.
+			if errors.Is(err, io.EOF) {
+				return offset, nil
+			}
+			return offset, err
+		}
+		offset += int64(len(line))
+		if err := fn(trimLineEnding(line)); err != nil {
+			return offset, err
+		}
+	}
+}
+
+// trimLineEnding removes the trailing line ending from the line.
+func trimLineEnding(line string) string {
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return line
+}
+
+// FollowLog calls fn for every line appended to the log file after the given
+// offset, until the context is done or fn returns an error. The log file is
+// read from the start again if it is truncated or replaced, like when the
+// server restarts.
+func FollowLog(ctx context.Context, path string, offset int64, fn func(string) error) error {
+	prev, _ := os.Stat(path)
+	ticker := time.NewTicker(followInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if prev == nil || !os.SameFile(prev, info) || info.Size() < offset {
+			offset = 0
+		}
+		prev = info
+		if info.Size() == offset {
+			continue
+		}
+		if offset, err = readLog(path, offset, fn); err != nil {
+			return err
+		}
+	}
+}
+
+// logCursor tracks the offsets up to which the latest logs of servers have
+// been scanned.
+type logCursor struct {
+	mu      sync.Mutex
+	offsets map[string]logOffset
+}
+
+// logOffset is the offset up to which a log file has been scanned.
+type logOffset struct {
+	info   os.FileInfo
+	offset int64
+}
+
+func newLogCursor() *logCursor {
+	return &logCursor{offsets: make(map[string]logOffset)}
+}
+
+// scan calls fn for every line the server logged since the last scan, along
+// with the modification time of the log. The first scan of a server only
+// reads lines logged afterwards, unless fromStart is set. The log is read
+// from the start again if it was truncated or replaced.
+func (c *logCursor) scan(server string, fromStart bool, fn func(line string, modTime time.Time) error) error {
+	path := filepath.Join(common.ServerDirectory(server), logsDir, latestLog)
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prev, ok := c.offsets[server]
+	switch {
+	case !ok && !fromStart:
+		prev.offset = info.Size()
+	case !ok, !os.SameFile(prev.info, info), info.Size() < prev.offset:
+		prev.offset = 0
+	}
+	offset, err := readLog(path, prev.offset, func(line string) error {
+		return fn(line, info.ModTime())
+	})
+	c.offsets[server] = logOffset{info: info, offset: offset}
+	return err
+}
+
+// reset makes the next scan of the server's log start from the beginning.
+func (c *logCursor) reset(server string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.offsets[server] = logOffset{}
+}
+
+// forget drops the scan offset of the server.
+func (c *logCursor) forget(server string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.offsets, server)
+}
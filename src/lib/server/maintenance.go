@@ -0,0 +1,286 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/common"
+	"github.com/dranilew/minecraft-server-manager/src/lib/logger"
+)
+
+const (
+	// savedWhitelistFile is where the whitelist is kept during maintenance.
+	savedWhitelistFile = "whitelist.json.maintenance"
+	// defaultMaintenanceMessage is the message non-staff players are kicked
+	// with if none is given.
+	defaultMaintenanceMessage = "The server is under maintenance, please come back later"
+)
+
+var (
+	// maintenanceProperties are the properties changed for maintenance.
+	maintenanceProperties = []string{"white-list", "enforce-whitelist", "motd"}
+	// onlinePlayersRegex matches the output of the list command.
+	onlinePlayersRegex = regexp.MustCompile(`(?m)players online:(.*)$`)
+)
+
+// MaintenanceRequest is a request to turn maintenance mode of a server on or
+// off.
+type MaintenanceRequest struct {
+	// Server is the server to change.
+	Server string
+	// Enable turns maintenance mode on instead of off.
+	Enable bool
+	// Message is the message non-staff players are kicked with, which is also
+	// used as the MOTD.
+	Message string
+	// KeepWhitelist switches on the existing whitelist instead of only
+	// allowing the operators of the server.
+	KeepWhitelist bool
+}
+
+// InMaintenance returns whether the server is in maintenance mode.
+func InMaintenance(server string) bool {
+	common.ServerStatusesMu.Lock()
+	defer common.ServerStatusesMu.Unlock()
+	status, ok := common.ServerStatuses[server]
+	return ok && status.Maintenance != nil
+}
+
+// SetMaintenance turns maintenance mode of a registered server on or off, and
+// writes progress to out. While in maintenance, only staff can join, and
+// scheduled restarts and backup unlocking are paused. Everything changed is
+// restored when maintenance mode is turned off.
+func SetMaintenance(ctx context.Context, req MaintenanceRequest, out func(string) error) error {
+	common.ServerStatusesMu.Lock()
+	status, ok := common.ServerStatuses[req.Server]
+	var inMaintenance bool
+	if ok {
+		inMaintenance = status.Maintenance != nil
+	}
+	common.ServerStatusesMu.Unlock()
+	if !ok {
+		return fmt.Errorf("server %q is not registered", req.Server)
+	}
+
+	if req.Enable {
+		if inMaintenance {
+			return out(fmt.Sprintf("Server %q is already in maintenance mode", req.Server))
+		}
+		return enableMaintenance(ctx, req, out)
+	}
+	if !inMaintenance {
+		return out(fmt.Sprintf("Server %q is not in maintenance mode", req.Server))
+	}
+	return disableMaintenance(ctx, req.Server, out)
+}
+
+// enableMaintenance closes the server to everyone but staff.
+func enableMaintenance(ctx context.Context, req MaintenanceRequest, out func(string) error) error {
+	dir := common.ServerDirectory(req.Server)
+	props, err := LoadProperties(req.Server)
+	if err != nil {
+		return err
+	}
+	staff, err := readPlayerList(filepath.Join(dir, opsFile))
+	if err != nil {
+		return err
+	}
+	m := &common.Maintenance{
+		Since:      time.Now(),
+		Message:    cmp.Or(req.Message, defaultMaintenanceMessage),
+		StaffOnly:  !req.KeepWhitelist,
+		Properties: make(map[string]string),
+	}
+	for _, key := range maintenanceProperties {
+		if value, ok := props.Get(key); ok {
+			m.Properties[key] = value
+		} else {
+			m.UnsetProperties = append(m.UnsetProperties, key)
+		}
+	}
+
+	allowed := staff
+	if m.StaffOnly {
+		// Keep the whitelist before anything is changed, so that it can be
+		// restored even if the manager stops in between.
+		whitelist := filepath.Join(dir, whitelistFile)
+		if _, err := os.Stat(whitelist); err == nil {
+			if err := replaceWithCopy(whitelist, filepath.Join(dir, savedWhitelistFile)); err != nil {
+				return fmt.Errorf("failed to save whitelist of server %q: %v", req.Server, err)
+			}
+			m.WhitelistSaved = true
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to save whitelist of server %q: %v", req.Server, err)
+		}
+	} else {
+		whitelist, err := readPlayerList(filepath.Join(dir, whitelistFile))
+		if err != nil {
+			return err
+		}
+		allowed = append(allowed, whitelist...)
+	}
+	if err := setMaintenanceState(req.Server, m); err != nil {
+		return err
+	}
+
+	if m.StaffOnly {
+		var whitelist []listEntry
+		for _, e := range staff {
+			whitelist = append(whitelist, listEntry{UUID: e.UUID, Name: e.Name})
+		}
+		if err := writePlayerList(req.Server, filepath.Join(dir, whitelistFile), whitelist); err != nil {
+			return err
+		}
+		if len(staff) == 0 {
+			if err := out(fmt.Sprintf("Server %q has no operators, nobody can join", req.Server)); err != nil {
+				return err
+			}
+		}
+	}
+	props.Set("white-list", "true")
+	props.Set("enforce-whitelist", "true")
+	props.Set("motd", m.Message)
+	if err := props.Save(propertiesPath(req.Server)); err != nil {
+		return err
+	}
+	if err := out(fmt.Sprintf("Server %q is in maintenance mode", req.Server)); err != nil {
+		return err
+	}
+
+	runningServers, err := GetRunningServers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get running servers: %v", err)
+	}
+	if !slices.Contains(runningServers, req.Server) {
+		return nil
+	}
+	for _, command := range []string{"whitelist reload", "whitelist on"} {
+		if _, err := RunCommand(ctx, req.Server, command); err != nil {
+			return fmt.Errorf("failed to switch on whitelist of server %q: %v", req.Server, err)
+		}
+	}
+	if err := kickPlayers(ctx, req.Server, allowed, m.Message, out); err != nil {
+		return err
+	}
+	return out("The MOTD changes when the server restarts")
+}
+
+// disableMaintenance restores the settings changed for maintenance.
+func disableMaintenance(ctx context.Context, server string, out func(string) error) error {
+	common.ServerStatusesMu.Lock()
+	m := common.ServerStatuses[server].Maintenance
+	common.ServerStatusesMu.Unlock()
+
+	dir := common.ServerDirectory(server)
+	if m.StaffOnly {
+		whitelist, saved := filepath.Join(dir, whitelistFile), filepath.Join(dir, savedWhitelistFile)
+		// Remove the whitelist if the server had none before maintenance.
+		remove := whitelist
+		if m.WhitelistSaved {
+			if err := replaceWithCopy(saved, whitelist); err != nil {
+				return fmt.Errorf("failed to restore whitelist of server %q: %v", server, err)
+			}
+			remove = saved
+		}
+		if err := os.Remove(remove); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to restore whitelist of server %q: %v", server, err)
+		}
+	}
+
+	props, err := LoadProperties(server)
+	if err != nil {
+		return err
+	}
+	for key, value := range m.Properties {
+		props.Set(key, value)
+	}
+	for _, key := range m.UnsetProperties {
+		props.Unset(key)
+	}
+	if err := props.Save(propertiesPath(server)); err != nil {
+		return err
+	}
+	if err := setMaintenanceState(server, nil); err != nil {
+		return err
+	}
+	if err := out(fmt.Sprintf("Server %q is no longer in maintenance mode", server)); err != nil {
+		return err
+	}
+
+	runningServers, err := GetRunningServers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get running servers: %v", err)
+	}
+	if !slices.Contains(runningServers, server) {
+		return nil
+	}
+	whitelist, ok := props.Get("white-list")
+	if !ok {
+		whitelist = defaultProperties["white-list"]
+	}
+	for _, command := range []string{"whitelist reload", liveProperties["white-list"](whitelist)} {
+		if _, err := RunCommand(ctx, server, command); err != nil {
+			return fmt.Errorf("failed to restore whitelist of server %q: %v", server, err)
+		}
+	}
+	return out("The MOTD changes when the server restarts")
+}
+
+// setMaintenanceState persists the maintenance mode of the server.
+func setMaintenanceState(server string, m *common.Maintenance) error {
+	common.ServerStatusesMu.Lock()
+	if status, ok := common.ServerStatuses[server]; ok {
+		status.Maintenance = m
+	}
+	common.ServerStatusesMu.Unlock()
+	if err := common.UpdateServerStatus(); err != nil {
+		return fmt.Errorf("failed to update server status: %v", err)
+	}
+	return nil
+}
+
+// kickPlayers kicks the online players of the running server that aren't
+// allowed to stay.
+func kickPlayers(ctx context.Context, server string, allowed []listEntry, message string, out func(string) error) error {
+	output, err := CaptureCommand(ctx, server, "list")
+	if err != nil {
+		return fmt.Errorf("failed to list players of server %q: %v", server, err)
+	}
+	var errs []error
+	for _, name := range onlinePlayers(output) {
+		if containsPlayer(allowed, name) {
+			continue
+		}
+		if _, err := RunCommand(ctx, server, fmt.Sprintf("kick %s %s", name, message)); err != nil {
+			errs = append(errs, fmt.Errorf("failed to kick %s: %v", name, err))
+			continue
+		}
+		logger.Printf("Kicked %s from server %q for maintenance", name, server)
+		if err := out(fmt.Sprintf("Kicked %s", name)); err != nil {
+			return err
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// onlinePlayers parses the names of the online players from the output of
+// the list command.
+func onlinePlayers(output string) []string {
+	var names []string
+	for _, match := range onlinePlayersRegex.FindAllStringSubmatch(output, -1) {
+		for name := range strings.SplitSeq(match[1], ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
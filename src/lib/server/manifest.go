@@ -0,0 +1,556 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/common"
+	"github.com/dranilew/minecraft-server-manager/src/lib/logger"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// ManifestFile is the optional file in a server directory holding the
+	// manager-level settings of the server.
+	ManifestFile = "server.yaml"
+
+	// SourceManifest marks settings that come from the manifest.
+	SourceManifest = ManifestFile
+	// SourceManager marks settings that were set with mcctl.
+	SourceManager = "mcctl"
+	// SourceDefault marks settings that use the defaults of the manager.
+	SourceDefault = "default"
+)
+
+var (
+	// manifests are the last valid manifests of the servers, by server.
+	manifests   = make(map[string]*manifestEntry)
+	manifestsMu sync.Mutex
+)
+
+// Manifest is the server.yaml of a server. Settings in the manifest take
+// precedence over the ones set with mcctl, which take precedence over the
+// defaults of the manager. Unset settings are left to the next source.
+type Manifest struct {
+	// DisplayName is the human-readable name of the server.
+	DisplayName string `yaml:"display-name,omitempty"`
+	// Tags are added to the tags set with mcctl.
+	Tags []string `yaml:"tags,omitempty"`
+	// Autostart starts the server when the manager starts, or keeps it
+	// stopped if false. If unset, servers that were running are started.
+	Autostart *bool `yaml:"autostart,omitempty"`
+	// Priority orders the servers started when the manager starts. Servers
+	// with a higher priority are started first.
+	Priority int `yaml:"priority,omitempty"`
+	// DependsOn are the servers that must be ready before the server is
+	// started when the manager starts.
+	DependsOn []string `yaml:"depends-on,omitempty"`
+	// StopTimeout is the time to wait for the server to exit before
+	// force-killing it.
+	StopTimeout time.Duration `yaml:"stop-timeout,omitempty"`
+	// Recovery configures how the server is restarted after crashing.
+	Recovery *RecoveryPolicy `yaml:"recovery,omitempty"`
+	// Backup configures when the server is backed up.
+	Backup *BackupPolicy `yaml:"backup,omitempty"`
+	// Idle stops the server after no players have been online for a while.
+	Idle *IdlePolicy `yaml:"idle,omitempty"`
+	// JVM replaces the JVM settings set with mcctl.
+	JVM *common.JVMSettings `yaml:"jvm,omitempty"`
+	// Resources replaces the resource limits set with mcctl.
+	Resources *common.ResourceLimits `yaml:"resources,omitempty"`
+}
+
+// RecoveryPolicy configures how a server is restarted after crashing. Unset
+// fields use the flags of the manager.
+type RecoveryPolicy struct {
+	// Enabled restarts the server after crashing. This defaults to true.
+	Enabled *bool `yaml:"enabled,omitempty"`
+	// Window is the window in which restarts are counted.
+	Window time.Duration `yaml:"window,omitempty"`
+	// MaxRestarts is the number of restarts within the window after which the
+	// server is marked as failed.
+	MaxRestarts int `yaml:"max-restarts,omitempty"`
+	// Backoff is the delay before the second restart within the window.
+	Backoff time.Duration `yaml:"backoff,omitempty"`
+	// MaxBackoff is the longest delay between restarts.
+	MaxBackoff time.Duration `yaml:"max-backoff,omitempty"`
+}
+
+// BackupPolicy configures when a server is backed up.
+type BackupPolicy struct {
+	// Enabled includes the server in backups that aren't forced. This
+	// defaults to true.
+	Enabled *bool `yaml:"enabled,omitempty"`
+	// Always backs up the server even if no players were online since the
+	// last backup.
+	Always bool `yaml:"always,omitempty"`
+}
+
+// IdlePolicy puts a server to sleep after no players have been online for a
+// while.
+type IdlePolicy struct {
+	// After is how long no players must be online before the server is
+	// stopped.
+	After time.Duration `yaml:"after"`
+	// Bucket is the location to which to save the final backup before
+	// stopping. No final backup is taken if this isn't set.
+	Bucket string `yaml:"bucket,omitempty"`
+}
+
+// Setting is a setting of the effective configuration of a server.
+type Setting struct {
+	// Name is the name of the setting in the manifest.
+	Name string
+	// Value is the effective value of the setting.
+	Value string
+	// Source is where the value comes from.
+	Source string
+}
+
+// Description is the effective configuration of a server.
+type Description struct {
+	// Server is the described server.
+	Server string
+	// Manifest is the path of the manifest, or empty if there is none.
+	Manifest string
+	// ManifestError is the reason the manifest is invalid, if it is.
+	ManifestError string
+	// Settings are the effective settings of the server.
+	Settings []Setting
+}
+
+// manifestEntry is a loaded manifest.
+type manifestEntry struct {
+	// modTime and size identify the version of the file that was read.
+	modTime time.Time
+	size    int64
+	// manifest is the last valid manifest.
+	manifest *Manifest
+}
+
+// ValidateManifest returns an error if the manifest is invalid.
+func ValidateManifest(m Manifest) error {
+	for _, tag := range m.Tags {
+		if !serverNameRegex.MatchString(tag) {
+			return fmt.Errorf("invalid tag %q", tag)
+		}
+	}
+	for _, dep := range m.DependsOn {
+		if !serverNameRegex.MatchString(dep) {
+			return fmt.Errorf("invalid server %q in depends-on", dep)
+		}
+	}
+	if m.StopTimeout < 0 {
+		return fmt.Errorf("invalid stop-timeout %s", m.StopTimeout)
+	}
+	if r := m.Recovery; r != nil {
+		if r.Window < 0 || r.Backoff < 0 || r.MaxBackoff < 0 || r.MaxRestarts < 0 {
+			return fmt.Errorf("invalid recovery policy: durations and max-restarts must not be negative")
+		}
+		if r.Backoff > 0 && r.MaxBackoff > 0 && r.Backoff > r.MaxBackoff {
+			return fmt.Errorf("invalid recovery policy: backoff %s is longer than max-backoff %s", r.Backoff, r.MaxBackoff)
+		}
+	}
+	if i := m.Idle; i != nil {
+		if i.After <= 0 {
+			return fmt.Errorf("invalid idle policy: after must be positive")
+		}
+		if i.Bucket != "" && !strings.HasPrefix(i.Bucket, "gs://") {
+			return fmt.Errorf("invalid idle policy: bucket %q must be a gs:// URL", i.Bucket)
+		}
+	}
+	if m.JVM != nil {
+		if err := ValidateJVM(*m.JVM); err != nil {
+			return fmt.Errorf("invalid jvm: %v", err)
+		}
+	}
+	if m.Resources != nil {
+		if err := ValidateLimits(*m.Resources); err != nil {
+			return fmt.Errorf("invalid resources: %v", err)
+		}
+	}
+	return nil
+}
+
+// LoadManifest reads and validates the manifest of the server. It returns nil
+// if the server has no manifest. The server's user can write its directory,
+// so the resource limits and java binary of manifests that the user may have
+// written are left out.
+func LoadManifest(server string) (*Manifest, error) {
+	path := manifestPath(server)
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read %s: %v", ManifestFile, err)
+	}
+	defer f.Close()
+	contents, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", ManifestFile, err)
+	}
+	m, err := parseManifest(contents)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", ManifestFile, err)
+	}
+	// Symbolic links are followed when opening, so make sure the opened file
+	// is the manifest itself.
+	linkInfo, err := os.Lstat(path)
+	trusted := err == nil && os.SameFile(info, linkInfo) && managerOwned(info)
+	if !trusted && (m.Resources != nil || (m.JVM != nil && m.JVM.Java != "")) {
+		logger.Printf("Ignoring resources and jvm.java in %s of server %q, it must be owned by the manager's user and not writable by others", ManifestFile, server)
+		m.Resources = nil
+		if m.JVM != nil {
+			m.JVM.Java = ""
+		}
+	}
+	return m, nil
+}
+
+// parseManifest decodes and validates the manifest. Unknown settings are
+// rejected, so that typos don't go unnoticed.
+func parseManifest(contents []byte) (*Manifest, error) {
+	var m Manifest
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&m); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse %s: %v", ManifestFile, err)
+	}
+	if err := ValidateManifest(m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// manifestPath returns the location of the manifest of the server.
+func manifestPath(server string) string {
+	return filepath.Join(common.ServerDirectory(server), ManifestFile)
+}
+
+// reloadManifest reads the manifest of the server if it changed since it was
+// last read, and returns whether the effective manifest changed. Invalid
+// manifests are logged and ignored, keeping the last valid one.
+func reloadManifest(server string) bool {
+	manifestsMu.Lock()
+	defer manifestsMu.Unlock()
+	entry := manifests[server]
+	info, err := os.Stat(manifestPath(server))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			logger.Printf("Failed to read %s of server %q: %v", ManifestFile, server, err)
+			return false
+		}
+		if entry == nil {
+			return false
+		}
+		delete(manifests, server)
+		logger.Printf("Removed %s of server %q", ManifestFile, server)
+		return entry.manifest != nil
+	}
+	if entry != nil && entry.modTime.Equal(info.ModTime()) && entry.size == info.Size() {
+		return false
+	}
+	if entry == nil {
+		entry = &manifestEntry{}
+		manifests[server] = entry
+	}
+	entry.modTime = info.ModTime()
+	entry.size = info.Size()
+	m, err := LoadManifest(server)
+	if err != nil {
+		logger.Printf("Ignoring invalid %s of server %q, keeping the last valid settings: %v", ManifestFile, server, err)
+		return false
+	}
+	if entry.manifest == nil && m != nil {
+		logger.Debugf("Loaded %s of server %q", ManifestFile, server)
+	} else {
+		logger.Printf("Reloaded %s of server %q", ManifestFile, server)
+	}
+	entry.manifest = m
+	return true
+}
+
+// manifestOf returns the manifest of the server, or nil if it has none.
+func manifestOf(server string) *Manifest {
+	reloadManifest(server)
+	manifestsMu.Lock()
+	defer manifestsMu.Unlock()
+	if entry, ok := manifests[server]; ok {
+		return entry.manifest
+	}
+	return nil
+}
+
+// forgetManifest drops the loaded manifest of the server.
+func forgetManifest(server string) {
+	manifestsMu.Lock()
+	delete(manifests, server)
+	manifestsMu.Unlock()
+}
+
+// ReloadManifests reloads the manifests of all registered servers that
+// changed, and applies the new resource limits of running servers. Other
+// settings are read when they are used, and JVM settings are applied on the
+// next start.
+func ReloadManifests(runningServers []string) error {
+	common.ServerStatusesMu.Lock()
+	var servers []string
+	for server := range common.ServerStatuses {
+		servers = append(servers, server)
+	}
+	common.ServerStatusesMu.Unlock()
+
+	var errs []error
+	for _, server := range servers {
+		if !reloadManifest(server) || !slices.Contains(runningServers, server) || !cgroupsEnabled() {
+			continue
+		}
+		if err := setupCgroup(server); err != nil {
+			errs = append(errs, fmt.Errorf("failed to apply resource limits of server %q: %v", server, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Tags returns the tags of the server, including the tags of its manifest.
+func Tags(status *common.ServerStatus) []string {
+	tags := slices.Clone(status.Tags)
+	if m := manifestOf(status.Name); m != nil {
+		tags = append(tags, m.Tags...)
+	}
+	slices.Sort(tags)
+	return slices.Compact(tags)
+}
+
+// WantsBackup returns whether the server should be backed up, given whether
+// players were online since its last backup.
+func WantsBackup(server string, played, force bool) bool {
+	if force {
+		return true
+	}
+	var policy BackupPolicy
+	if m := manifestOf(server); m != nil && m.Backup != nil {
+		policy = *m.Backup
+	}
+	if policy.Enabled != nil && !*policy.Enabled {
+		return false
+	}
+	return played || policy.Always
+}
+
+// recoveryPolicyOf returns the recovery policy of the server, with unset
+// fields filled in from the flags.
+func recoveryPolicyOf(server string) RecoveryPolicy {
+	return mergeRecoveryPolicy(manifestOf(server))
+}
+
+// mergeRecoveryPolicy returns the recovery policy of the manifest, with unset
+// fields filled in from the flags.
+func mergeRecoveryPolicy(m *Manifest) RecoveryPolicy {
+	enabled := true
+	policy := RecoveryPolicy{
+		Enabled:     &enabled,
+		Window:      *crashWindow,
+		MaxRestarts: *crashMaxRestarts,
+		Backoff:     *crashBackoff,
+		MaxBackoff:  *crashMaxBackoff,
+	}
+	if m == nil || m.Recovery == nil {
+		return policy
+	}
+	r := m.Recovery
+	if r.Enabled != nil {
+		policy.Enabled = r.Enabled
+	}
+	if r.Window > 0 {
+		policy.Window = r.Window
+	}
+	if r.MaxRestarts > 0 {
+		policy.MaxRestarts = r.MaxRestarts
+	}
+	if r.Backoff > 0 {
+		policy.Backoff = r.Backoff
+	}
+	if r.MaxBackoff > 0 {
+		policy.MaxBackoff = r.MaxBackoff
+	}
+	return policy
+}
+
+// EffectiveJVM returns a copy of the JVM settings of the server and where they
+// come from, or nil if they aren't managed. The caller must hold the lock of the
+// server statuses.
+func EffectiveJVM(status *common.ServerStatus) (*common.JVMSettings, string) {
+	if m := manifestOf(status.Name); m != nil && m.JVM != nil {
+		settings := *m.JVM
+		return &settings, SourceManifest
+	}
+	if status.JVM != nil {
+		settings := *status.JVM
+		return &settings, SourceManager
+	}
+	return nil, SourceDefault
+}
+
+// Describe returns the effective configuration of the registered server,
+// merging its manifest, the settings set with mcctl and the defaults.
+func Describe(server string) (*Description, error) {
+	desc := &Description{Server: server}
+	m, err := LoadManifest(server)
+	if err != nil {
+		desc.ManifestError = err.Error()
+	}
+	if _, statErr := os.Stat(manifestPath(server)); statErr == nil {
+		desc.Manifest = manifestPath(server)
+	}
+	if m == nil {
+		m = &Manifest{}
+	}
+
+	common.ServerStatusesMu.Lock()
+	defer common.ServerStatusesMu.Unlock()
+	status, ok := common.ServerStatuses[server]
+	if !ok {
+		return nil, fmt.Errorf("server %q is not registered", server)
+	}
+	add := func(name, value, source string) {
+		desc.Settings = append(desc.Settings, Setting{Name: name, Value: value, Source: source})
+	}
+
+	if m.DisplayName != "" {
+		add("display-name", m.DisplayName, SourceManifest)
+	} else {
+		add("display-name", server, SourceDefault)
+	}
+
+	var sources []string
+	if len(m.Tags) > 0 {
+		sources = append(sources, SourceManifest)
+	}
+	if len(status.Tags) > 0 {
+		sources = append(sources, SourceManager)
+	}
+	tags := append(slices.Clone(status.Tags), m.Tags...)
+	slices.Sort(tags)
+	add("tags", cmp.Or(strings.Join(slices.Compact(tags), ","), "none"), cmp.Or(strings.Join(sources, ", "), SourceDefault))
+
+	if m.Autostart != nil {
+		add("autostart", strconv.FormatBool(*m.Autostart), SourceManifest)
+	} else {
+		add("autostart", "if it was running", SourceDefault)
+	}
+	add("priority", strconv.Itoa(m.Priority), sourceIf(m.Priority != 0, SourceManifest))
+	add("depends-on", cmp.Or(strings.Join(m.DependsOn, ","), "none"), sourceIf(len(m.DependsOn) > 0, SourceManifest))
+
+	policy := DefaultStopPolicy()
+	warningsSource, timeoutSource := SourceDefault, SourceDefault
+	if status.StopPolicy != nil {
+		if status.StopPolicy.Warnings != nil {
+			policy.Warnings = status.StopPolicy.Warnings
+			warningsSource = SourceManager
+		}
+		if status.StopPolicy.Timeout > 0 {
+			policy.Timeout = status.StopPolicy.Timeout
+			timeoutSource = SourceManager
+		}
+	}
+	if m.StopTimeout > 0 {
+		policy.Timeout = m.StopTimeout
+		timeoutSource = SourceManifest
+	}
+	var warnings []string
+	for _, w := range policy.Warnings {
+		warnings = append(warnings, formatDuration(w))
+	}
+	add("stop-warnings", cmp.Or(strings.Join(warnings, ","), "none"), warningsSource)
+	add("stop-timeout", formatDuration(policy.Timeout), timeoutSource)
+
+	recovery := mergeRecoveryPolicy(m)
+	recoveryValue := "disabled"
+	if *recovery.Enabled {
+		recoveryValue = fmt.Sprintf("up to %d restarts within %s, backoff %s to %s", recovery.MaxRestarts, formatDuration(recovery.Window), formatDuration(recovery.Backoff), formatDuration(recovery.MaxBackoff))
+	}
+	add("recovery", recoveryValue, sourceIf(m.Recovery != nil, SourceManifest))
+
+	backupValue := "after players were online"
+	if b := m.Backup; b != nil {
+		if b.Enabled != nil && !*b.Enabled {
+			backupValue = "only when forced"
+		} else if b.Always {
+			backupValue = "always"
+		}
+	}
+	add("backup", backupValue, sourceIf(m.Backup != nil, SourceManifest))
+
+	idleValue := "disabled"
+	if i := m.Idle; i != nil {
+		idleValue = fmt.Sprintf("sleep after %s without players", formatDuration(i.After))
+		if i.Bucket != "" {
+			idleValue += ", backed up to " + i.Bucket
+		}
+	}
+	add("idle", idleValue, sourceIf(m.Idle != nil, SourceManifest))
+
+	jvm, jvmSource := status.JVM, sourceIf(status.JVM != nil, SourceManager)
+	if m.JVM != nil {
+		jvm, jvmSource = m.JVM, SourceManifest
+	}
+	if jvm != nil {
+		add("jvm", strings.Join(append([]string{JavaPath(*jvm)}, JVMArgs(*jvm)...), " "), jvmSource)
+	} else {
+		add("jvm", "as in "+runScript, jvmSource)
+	}
+
+	limits, limitsSource := status.Limits, sourceIf(status.Limits != nil, SourceManager)
+	if m.Resources != nil {
+		limits, limitsSource = m.Resources, SourceManifest
+	}
+	add("resources", formatLimits(limits), limitsSource)
+	return desc, nil
+}
+
+// formatLimits returns a readable form of the resource limits.
+func formatLimits(limits *common.ResourceLimits) string {
+	if limits == nil {
+		return "none"
+	}
+	var fields []string
+	if limits.MemoryMax != "" {
+		fields = append(fields, "memory-max="+limits.MemoryMax)
+	}
+	if limits.MemoryHigh != "" {
+		fields = append(fields, "memory-high="+limits.MemoryHigh)
+	}
+	if limits.CPUWeight > 0 {
+		fields = append(fields, fmt.Sprintf("cpu-weight=%d", limits.CPUWeight))
+	}
+	if limits.PidsMax > 0 {
+		fields = append(fields, fmt.Sprintf("pids-max=%d", limits.PidsMax))
+	}
+	return cmp.Or(strings.Join(fields, " "), "none")
+}
+
+// sourceIf returns the source if the condition holds, and the default source
+// otherwise.
+func sourceIf(cond bool, source string) string {
+	if cond {
+		return source
+	}
+	return SourceDefault
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/common"
+)
+
+func TestLoadManifestTrust(t *testing.T) {
+	const manifest = `display-name: Test
+jvm:
+  java: /opt/java/bin/java
+  max-heap: 8G
+resources:
+  memory-max: 10G
+  pids-max: 4096
+`
+	tests := []struct {
+		name    string
+		setup   func(t *testing.T, path string)
+		trusted bool
+	}{
+		{
+			name:    "owned by the manager",
+			setup:   func(*testing.T, string) {},
+			trusted: true,
+		},
+		{
+			name: "writable by others",
+			setup: func(t *testing.T, path string) {
+				if err := os.Chmod(path, 0666); err != nil {
+					t.Fatalf("failed to change mode of %s: %v", ManifestFile, err)
+				}
+			},
+		},
+		{
+			name: "owned by another user",
+			setup: func(t *testing.T, path string) {
+				if os.Getuid() != 0 {
+					t.Skip("changing owners requires root")
+				}
+				if err := os.Chown(path, 65534, 65534); err != nil {
+					t.Fatalf("failed to change owner of %s: %v", ManifestFile, err)
+				}
+			},
+		},
+		{
+			name: "symlink",
+			setup: func(t *testing.T, path string) {
+				target := filepath.Join(t.TempDir(), ManifestFile)
+				if err := os.Rename(path, target); err != nil {
+					t.Fatalf("failed to move %s: %v", ManifestFile, err)
+				}
+				if err := os.Symlink(target, path); err != nil {
+					t.Fatalf("failed to plant symlink: %v", err)
+				}
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			setupFakeServer(t, "test", "")
+			path := manifestPath("test")
+			if err := os.WriteFile(path, []byte(manifest), 0644); err != nil {
+				t.Fatalf("failed to write %s: %v", ManifestFile, err)
+			}
+			tc.setup(t, path)
+
+			m, err := LoadManifest("test")
+			if err != nil {
+				t.Fatalf("LoadManifest() failed: %v", err)
+			}
+			// Other settings are honoured either way.
+			if m.DisplayName != "Test" || m.JVM == nil || m.JVM.MaxHeap != "8G" {
+				t.Errorf("LoadManifest() = %+v, want the display name and heap size", m)
+			}
+			if tc.trusted {
+				if m.Resources == nil || *m.Resources != (common.ResourceLimits{MemoryMax: "10G", PidsMax: 4096}) || m.JVM.Java != "/opt/java/bin/java" {
+					t.Errorf("LoadManifest() left out resources %+v or java %q of a trusted manifest", m.Resources, m.JVM.Java)
+				}
+				return
+			}
+			if m.Resources != nil || m.JVM.Java != "" {
+				t.Errorf("LoadManifest() = (resources %+v, java %q), want them left out", m.Resources, m.JVM.Java)
+			}
+		})
+	}
+}
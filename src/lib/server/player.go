@@ -0,0 +1,478 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/common"
+	"github.com/dranilew/minecraft-server-manager/src/lib/fileutil"
+	"github.com/dranilew/minecraft-server-manager/src/lib/logger"
+)
+
+const (
+	// whitelistFile is the file containing the players allowed to join.
+	whitelistFile = "whitelist.json"
+	// opsFile is the file containing the operators of the server.
+	opsFile = "ops.json"
+	// bannedPlayersFile is the file containing the banned players.
+	bannedPlayersFile = "banned-players.json"
+	// userCacheFile is the file containing the players that joined the server.
+	userCacheFile = "usercache.json"
+	// defaultBanReason is the reason of bans if none is given.
+	defaultBanReason = "Banned by an operator."
+	// banTimeFormat is the time format of bans.
+	banTimeFormat = "2006-01-02 15:04:05 -0700"
+)
+
+// PlayerAction is a change to the player lists of a server.
+type PlayerAction string
+
+const (
+	// PlayerWhitelist adds players to the whitelist, or removes them.
+	PlayerWhitelist PlayerAction = "whitelist"
+	// PlayerOp makes players operators, or removes them as operators.
+	PlayerOp PlayerAction = "op"
+	// PlayerBan bans players.
+	PlayerBan PlayerAction = "ban"
+	// PlayerPardon removes the ban of players.
+	PlayerPardon PlayerAction = "pardon"
+)
+
+var (
+	// playerNameRegex matches valid player names.
+	playerNameRegex = regexp.MustCompile("^[A-Za-z0-9_]{1,16}$")
+	// playerLists are the player lists changed by each action.
+	playerLists = map[PlayerAction]string{
+		PlayerWhitelist: whitelistFile,
+		PlayerOp:        opsFile,
+		PlayerBan:       bannedPlayersFile,
+		PlayerPardon:    bannedPlayersFile,
+	}
+)
+
+// PlayerRequest is a request to change the player lists of one or more
+// servers.
+type PlayerRequest struct {
+	// Action is the change to make.
+	Action PlayerAction
+	// Players are the names of the players to change.
+	Players []string
+	// Remove removes the players from the whitelist or as operators instead.
+	Remove bool
+	// Reason is the reason of a ban.
+	Reason string
+	// Servers is the list of servers to change.
+	Servers []string
+	// All changes all registered servers.
+	All bool
+	// Tag changes all registered servers with the tag.
+	Tag string
+	// Sync makes the whitelists of the servers identical, containing every
+	// player whitelisted on any of them.
+	Sync bool
+}
+
+// listEntry is an entry of the player lists of a server, like the whitelist.
+type listEntry struct {
+	UUID string `json:"uuid"`
+	Name string `json:"name"`
+	// Level and BypassesPlayerLimit are set for operators.
+	Level               int   `json:"level,omitempty"`
+	BypassesPlayerLimit *bool `json:"bypassesPlayerLimit,omitempty"`
+	// Created, Source, Expires and Reason are set for bans.
+	Created string `json:"created,omitempty"`
+	Source  string `json:"source,omitempty"`
+	Expires string `json:"expires,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// userCacheEntry is an entry of the user cache of a server.
+type userCacheEntry struct {
+	Name string `json:"name"`
+	UUID string `json:"uuid"`
+}
+
+// ManagePlayers changes the player lists of the selected servers, and writes
+// progress to out. Running servers are changed through the console, and the
+// files of stopped servers are changed directly.
+func ManagePlayers(ctx context.Context, req PlayerRequest, out func(string) error) error {
+	if _, ok := playerLists[req.Action]; !ok {
+		return fmt.Errorf("unknown player action %q", req.Action)
+	}
+	if len(req.Players) == 0 && !req.Sync {
+		return fmt.Errorf("no players given")
+	}
+	for _, name := range req.Players {
+		if !playerNameRegex.MatchString(name) {
+			return fmt.Errorf("invalid player name %q", name)
+		}
+	}
+	if req.Sync && req.Action != PlayerWhitelist {
+		return fmt.Errorf("only whitelists can be synced")
+	}
+	if req.Remove && req.Action != PlayerWhitelist && req.Action != PlayerOp {
+		return fmt.Errorf("players can only be removed from the whitelist or as operators, use pardon to remove bans")
+	}
+
+	servers := Select(req.Servers, req.All, req.Tag)
+	if len(servers) == 0 {
+		return fmt.Errorf("no servers selected")
+	}
+	common.ServerStatusesMu.Lock()
+	for _, server := range servers {
+		if _, ok := common.ServerStatuses[server]; !ok {
+			common.ServerStatusesMu.Unlock()
+			return fmt.Errorf("server %q is not registered", server)
+		}
+	}
+	common.ServerStatusesMu.Unlock()
+	runningServers, err := GetRunningServers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get running servers: %v", err)
+	}
+
+	if req.Sync {
+		return syncWhitelists(ctx, servers, runningServers, req, out)
+	}
+	var errs []error
+	for _, server := range servers {
+		for _, name := range req.Players {
+			msg, err := managePlayer(ctx, server, slices.Contains(runningServers, server), req, name)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: failed to %s %s: %v", server, req.Action, name, err))
+				continue
+			}
+			if err := out(fmt.Sprintf("%s: %s", server, msg)); err != nil {
+				return err
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// managePlayer changes the player on the server, and returns what changed.
+func managePlayer(ctx context.Context, server string, running bool, req PlayerRequest, name string) (string, error) {
+	add := req.Action == PlayerBan || (req.Action != PlayerPardon && !req.Remove)
+	path, maintenance := playerListPath(server, req.Action)
+	// Whitelists of servers in maintenance are kept aside, so that they are
+	// changed even though the server doesn't use them until maintenance ends.
+	if running && !maintenance {
+		command := playerCommand(req, name, add)
+		output, err := CaptureCommand(ctx, server, command)
+		if err != nil {
+			return "", err
+		}
+		for _, msg := range commandErrors {
+			if strings.Contains(output, msg) {
+				return "", fmt.Errorf("%q failed: %s", command, msg)
+			}
+		}
+		return fmt.Sprintf("ran %q", command), nil
+	}
+
+	entries, err := readPlayerList(path)
+	if err != nil {
+		return "", err
+	}
+	i := slices.IndexFunc(entries, func(e listEntry) bool { return strings.EqualFold(e.Name, name) })
+	if !add {
+		if i < 0 {
+			return fmt.Sprintf("%s is not on %s", name, filepath.Base(path)), nil
+		}
+		entries = slices.Delete(entries, i, i+1)
+	} else {
+		if i >= 0 {
+			return fmt.Sprintf("%s is already on %s", entries[i].Name, filepath.Base(path)), nil
+		}
+		entry, err := resolvePlayer(server, name)
+		if err != nil {
+			return "", err
+		}
+		if err := fillEntry(server, &entry, req); err != nil {
+			return "", err
+		}
+		entries = append(entries, entry)
+		name = entry.Name
+	}
+	if err := writePlayerList(server, path, entries); err != nil {
+		return "", err
+	}
+	if maintenance {
+		if err := markWhitelistSaved(server); err != nil {
+			return "", err
+		}
+	}
+	if !add {
+		return fmt.Sprintf("removed %s from %s", name, filepath.Base(path)), nil
+	}
+	return fmt.Sprintf("added %s to %s", name, filepath.Base(path)), nil
+}
+
+// syncWhitelists makes the whitelists of the servers identical, containing
+// every player whitelisted on any of them, with the changes of the request
+// applied.
+func syncWhitelists(ctx context.Context, servers, runningServers []string, req PlayerRequest, out func(string) error) error {
+	var target []listEntry
+	current := make(map[string][]listEntry)
+	for _, server := range servers {
+		path, _ := playerListPath(server, PlayerWhitelist)
+		entries, err := readPlayerList(path)
+		if err != nil {
+			return err
+		}
+		current[server] = entries
+		for _, e := range entries {
+			if !containsPlayer(target, e.Name) {
+				target = append(target, listEntry{UUID: e.UUID, Name: e.Name})
+			}
+		}
+	}
+	for _, name := range req.Players {
+		switch {
+		case req.Remove:
+			target = slices.DeleteFunc(target, func(e listEntry) bool { return strings.EqualFold(e.Name, name) })
+		case !containsPlayer(target, name):
+			// UUIDs are resolved by the servers whose files are written.
+			target = append(target, listEntry{Name: name})
+		}
+	}
+	slices.SortFunc(target, func(a, b listEntry) int { return cmp.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)) })
+
+	var errs []error
+	for _, server := range servers {
+		path, maintenance := playerListPath(server, PlayerWhitelist)
+		if slices.Contains(runningServers, server) && !maintenance {
+			// Running servers are changed one player at a time.
+			var commands []string
+			for _, e := range target {
+				if !containsPlayer(current[server], e.Name) {
+					commands = append(commands, "whitelist add "+e.Name)
+				}
+			}
+			for _, e := range current[server] {
+				if !containsPlayer(target, e.Name) {
+					commands = append(commands, "whitelist remove "+e.Name)
+				}
+			}
+			for _, command := range commands {
+				if _, err := RunCommand(ctx, server, command); err != nil {
+					errs = append(errs, fmt.Errorf("%s: %v", server, err))
+				}
+			}
+			if err := out(fmt.Sprintf("%s: ran %d whitelist commands", server, len(commands))); err != nil {
+				return err
+			}
+			continue
+		}
+		entries := slices.Clone(target)
+		var err error
+		for i, e := range entries {
+			if e.UUID == "" {
+				if entries[i], err = resolvePlayer(server, e.Name); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", server, err))
+			continue
+		}
+		if err := writePlayerList(server, path, entries); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", server, err))
+			continue
+		}
+		if maintenance {
+			if err := markWhitelistSaved(server); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %v", server, err))
+				continue
+			}
+		}
+		if err := out(fmt.Sprintf("%s: wrote %d players to %s", server, len(entries), filepath.Base(path))); err != nil {
+			return err
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// playerListPath returns the player list changed by the action on the server,
+// and whether it is the whitelist kept aside during maintenance.
+func playerListPath(server string, action PlayerAction) (string, bool) {
+	dir := common.ServerDirectory(server)
+	if action == PlayerWhitelist {
+		common.ServerStatusesMu.Lock()
+		status, ok := common.ServerStatuses[server]
+		staffOnly := ok && status.Maintenance != nil && status.Maintenance.StaffOnly
+		common.ServerStatusesMu.Unlock()
+		if staffOnly {
+			return filepath.Join(dir, savedWhitelistFile), true
+		}
+	}
+	return filepath.Join(dir, playerLists[action]), false
+}
+
+// markWhitelistSaved records that the whitelist kept aside during maintenance
+// exists, so that it is restored when maintenance ends.
+func markWhitelistSaved(server string) error {
+	common.ServerStatusesMu.Lock()
+	status, ok := common.ServerStatuses[server]
+	if !ok || status.Maintenance == nil || status.Maintenance.WhitelistSaved {
+		common.ServerStatusesMu.Unlock()
+		return nil
+	}
+	status.Maintenance.WhitelistSaved = true
+	common.ServerStatusesMu.Unlock()
+	if err := common.UpdateServerStatus(); err != nil {
+		return fmt.Errorf("failed to update server status: %v", err)
+	}
+	return nil
+}
+
+// playerCommand returns the console command making the change to the player.
+func playerCommand(req PlayerRequest, name string, add bool) string {
+	switch req.Action {
+	case PlayerWhitelist:
+		if add {
+			return "whitelist add " + name
+		}
+		return "whitelist remove " + name
+	case PlayerOp:
+		if add {
+			return "op " + name
+		}
+		return "deop " + name
+	case PlayerBan:
+		if req.Reason != "" {
+			return fmt.Sprintf("ban %s %s", name, req.Reason)
+		}
+		return "ban " + name
+	default:
+		return "pardon " + name
+	}
+}
+
+// fillEntry sets the fields of a new entry that depend on the player list.
+func fillEntry(server string, entry *listEntry, req PlayerRequest) error {
+	switch req.Action {
+	case PlayerOp:
+		entry.Level = 4
+		if props, err := LoadProperties(server); err == nil {
+			if value, ok := props.Get("op-permission-level"); ok {
+				if entry.Level, err = strconv.Atoi(value); err != nil {
+					return fmt.Errorf("invalid op-permission-level %q", value)
+				}
+			}
+		}
+		bypass := false
+		entry.BypassesPlayerLimit = &bypass
+	case PlayerBan:
+		entry.Created = time.Now().Format(banTimeFormat)
+		entry.Source = SourceManager
+		entry.Expires = "forever"
+		entry.Reason = cmp.Or(req.Reason, defaultBanReason)
+	}
+	return nil
+}
+
+// resolvePlayer looks up the UUID of the player in the user caches of all
+// registered servers, starting with the given server. Players that never
+// joined get their offline UUID on servers in offline mode.
+func resolvePlayer(server, name string) (listEntry, error) {
+	servers := []string{server}
+	common.ServerStatusesMu.Lock()
+	for other := range common.ServerStatuses {
+		if other != server {
+			servers = append(servers, other)
+		}
+	}
+	common.ServerStatusesMu.Unlock()
+	slices.Sort(servers[1:])
+
+	for _, s := range servers {
+		b, err := os.ReadFile(filepath.Join(common.ServerDirectory(s), userCacheFile))
+		if err != nil {
+			continue
+		}
+		var cache []userCacheEntry
+		if err := json.Unmarshal(b, &cache); err != nil {
+			logger.Debugf("Failed to parse user cache of server %q: %v", s, err)
+			continue
+		}
+		for _, e := range cache {
+			if strings.EqualFold(e.Name, name) {
+				return listEntry{UUID: e.UUID, Name: e.Name}, nil
+			}
+		}
+	}
+
+	onlineMode := defaultProperties["online-mode"]
+	if props, err := LoadProperties(server); err == nil {
+		if value, ok := props.Get("online-mode"); ok {
+			onlineMode = value
+		}
+	}
+	if onlineMode == "true" {
+		return listEntry{}, fmt.Errorf("%s never joined any server, so its UUID is unknown; start server %q to resolve it through the console", name, server)
+	}
+	return listEntry{UUID: offlineUUID(name), Name: name}, nil
+}
+
+// offlineUUID returns the UUID servers in offline mode give the player.
+func offlineUUID(name string) string {
+	b := md5.Sum([]byte("OfflinePlayer:" + name))
+	b[6] = b[6]&0x0f | 0x30
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// containsPlayer returns whether the player is on the list.
+func containsPlayer(entries []listEntry, name string) bool {
+	return slices.ContainsFunc(entries, func(e listEntry) bool { return strings.EqualFold(e.Name, name) })
+}
+
+// readPlayerList reads a player list of a server, like its whitelist. A
+// missing list is empty.
+func readPlayerList(path string) ([]listEntry, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	var entries []listEntry
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return entries, nil
+}
+
+// writePlayerList writes a player list of the server, owned by the user the
+// server runs as.
+func writePlayerList(server, path string, entries []listEntry) error {
+	if entries == nil {
+		entries = []listEntry{}
+	}
+	b, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %v", path, err)
+	}
+	if err := fileutil.WriteFile(path, b, 0644); err != nil {
+		return err
+	}
+	cred, err := serverCredential(server)
+	if err != nil || cred == nil {
+		return err
+	}
+	return chownTree(path, cred)
+}
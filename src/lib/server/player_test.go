@@ -0,0 +1,352 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/common"
+)
+
+// plantSymlink replaces the file in the server directory with a symbolic link
+// to a file outside of it, and returns the path of the target.
+func plantSymlink(t *testing.T, path string) string {
+	t.Helper()
+	target := filepath.Join(t.TempDir(), "target")
+	if err := os.WriteFile(target, []byte("secret"), 0600); err != nil {
+		t.Fatalf("failed to write target: %v", err)
+	}
+	if err := os.Symlink(target, path); err != nil {
+		t.Fatalf("failed to plant symlink: %v", err)
+	}
+	return target
+}
+
+// checkNotFollowed checks that the symbolic link at path was replaced by a
+// regular file, leaving its target unchanged.
+func checkNotFollowed(t *testing.T, path, target string) {
+	t.Helper()
+	if got := readFile(t, target); got != "secret" {
+		t.Errorf("target of symlink was overwritten with %q", got)
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		t.Fatalf("failed to stat %s: %v", filepath.Base(path), err)
+	}
+	if !info.Mode().IsRegular() {
+		t.Errorf("%s is %v, want a regular file", filepath.Base(path), info.Mode())
+	}
+}
+
+func TestWritesDontFollowSymlinks(t *testing.T) {
+	setupFakeServer(t, "test", "")
+	registerServer(t, "test")
+	dir := common.ServerDirectory("test")
+
+	t.Run("player list", func(t *testing.T) {
+		path := filepath.Join(dir, whitelistFile)
+		target := plantSymlink(t, path)
+		entries := []listEntry{{UUID: "069a79f4-44e9-4726-a5be-fca90e38aaf5", Name: "Notch"}}
+		if err := writePlayerList("test", path, entries); err != nil {
+			t.Fatalf("writePlayerList() failed: %v", err)
+		}
+		checkNotFollowed(t, path, target)
+		got, err := readPlayerList(path)
+		if err != nil {
+			t.Fatalf("readPlayerList() failed: %v", err)
+		}
+		if len(got) != 1 || got[0].Name != "Notch" {
+			t.Errorf("readPlayerList() = %v, want %v", got, entries)
+		}
+	})
+
+	t.Run("jvm files", func(t *testing.T) {
+		path := filepath.Join(dir, jvmArgsFile)
+		target := plantSymlink(t, path)
+		if err := writeIfChanged(path, []byte("-Xmx4G\n"), 0644); err != nil {
+			t.Fatalf("writeIfChanged() failed: %v", err)
+		}
+		checkNotFollowed(t, path, target)
+	})
+
+	t.Run("copies", func(t *testing.T) {
+		src := filepath.Join(dir, "src.json")
+		if err := os.WriteFile(src, []byte("[]"), 0644); err != nil {
+			t.Fatalf("failed to write source: %v", err)
+		}
+		dst := filepath.Join(dir, "dst.json")
+		target := plantSymlink(t, dst)
+		if err := replaceWithCopy(src, dst); err != nil {
+			t.Fatalf("replaceWithCopy() failed: %v", err)
+		}
+		checkNotFollowed(t, dst, target)
+
+		// Linked sources aren't copied, as their target may not be readable
+		// by the server's user.
+		linked := filepath.Join(dir, "linked.json")
+		plantSymlink(t, linked)
+		if err := replaceWithCopy(linked, filepath.Join(dir, "copy.json")); err == nil {
+			t.Errorf("replaceWithCopy() copied a symlink")
+		}
+	})
+}
+
+// notchUUID is the UUID servers in offline mode give Notch.
+const notchUUID = "b50ad385-829d-3141-a216-7e7d7539ba7f"
+
+// writeJSON writes the value as JSON to the file in the server directory.
+func writeJSON(t *testing.T, server, file string, v any) {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("failed to marshal %s: %v", file, err)
+	}
+	if err := os.WriteFile(filepath.Join(common.ServerDirectory(server), file), b, 0644); err != nil {
+		t.Fatalf("failed to write %s: %v", file, err)
+	}
+}
+
+// readEntries returns the entries of the player list of the server, without
+// the creation times of bans.
+func readEntries(t *testing.T, server, file string) []listEntry {
+	t.Helper()
+	entries, err := readPlayerList(filepath.Join(common.ServerDirectory(server), file))
+	if err != nil {
+		t.Fatalf("readPlayerList() failed: %v", err)
+	}
+	for i := range entries {
+		entries[i].Created = ""
+	}
+	return entries
+}
+
+func TestOfflineUUID(t *testing.T) {
+	if got := offlineUUID("Notch"); got != notchUUID {
+		t.Errorf("offlineUUID(%q) = %q, want %q", "Notch", got, notchUUID)
+	}
+}
+
+func TestManagePlayersStopped(t *testing.T) {
+	notBypassing := false
+	tests := []struct {
+		name string
+		// props is the server.properties of the server.
+		props string
+		// files are the player files of the server before the change.
+		files map[string][]listEntry
+		// userCache is the user cache of the server.
+		userCache []userCacheEntry
+		req       PlayerRequest
+		// file is the player list checked after the change.
+		file    string
+		wantMsg string
+		want    []listEntry
+		wantErr bool
+	}{
+		{
+			name:    "whitelist add",
+			req:     PlayerRequest{Action: PlayerWhitelist, Players: []string{"Notch"}},
+			file:    whitelistFile,
+			wantMsg: "added Notch to whitelist.json",
+			want:    []listEntry{{UUID: notchUUID, Name: "Notch"}},
+		},
+		{
+			name:    "whitelist duplicate add",
+			files:   map[string][]listEntry{whitelistFile: {{UUID: notchUUID, Name: "Notch"}}},
+			req:     PlayerRequest{Action: PlayerWhitelist, Players: []string{"notch"}},
+			file:    whitelistFile,
+			wantMsg: "Notch is already on whitelist.json",
+			want:    []listEntry{{UUID: notchUUID, Name: "Notch"}},
+		},
+		{
+			name:    "whitelist remove",
+			files:   map[string][]listEntry{whitelistFile: {{UUID: notchUUID, Name: "Notch"}, {UUID: "1", Name: "jeb_"}}},
+			req:     PlayerRequest{Action: PlayerWhitelist, Players: []string{"Notch"}, Remove: true},
+			file:    whitelistFile,
+			wantMsg: "removed Notch from whitelist.json",
+			want:    []listEntry{{UUID: "1", Name: "jeb_"}},
+		},
+		{
+			name:    "whitelist remove missing",
+			req:     PlayerRequest{Action: PlayerWhitelist, Players: []string{"Notch"}, Remove: true},
+			file:    whitelistFile,
+			wantMsg: "Notch is not on whitelist.json",
+		},
+		{
+			name:      "whitelist add from user cache",
+			props:     "online-mode=true\n",
+			userCache: []userCacheEntry{{Name: "Notch", UUID: "069a79f4-44e9-4726-a5be-fca90e38aaf5"}},
+			req:       PlayerRequest{Action: PlayerWhitelist, Players: []string{"notch"}},
+			file:      whitelistFile,
+			wantMsg:   "added Notch to whitelist.json",
+			want:      []listEntry{{UUID: "069a79f4-44e9-4726-a5be-fca90e38aaf5", Name: "Notch"}},
+		},
+		{
+			name:    "whitelist add unknown player in online mode",
+			props:   "online-mode=true\n",
+			req:     PlayerRequest{Action: PlayerWhitelist, Players: []string{"Notch"}},
+			file:    whitelistFile,
+			wantErr: true,
+		},
+		{
+			name:    "op add",
+			req:     PlayerRequest{Action: PlayerOp, Players: []string{"Notch"}},
+			file:    opsFile,
+			wantMsg: "added Notch to ops.json",
+			want:    []listEntry{{UUID: notchUUID, Name: "Notch", Level: 4, BypassesPlayerLimit: &notBypassing}},
+		},
+		{
+			name:    "op add with permission level",
+			props:   "online-mode=false\nop-permission-level=2\n",
+			req:     PlayerRequest{Action: PlayerOp, Players: []string{"Notch"}},
+			file:    opsFile,
+			wantMsg: "added Notch to ops.json",
+			want:    []listEntry{{UUID: notchUUID, Name: "Notch", Level: 2, BypassesPlayerLimit: &notBypassing}},
+		},
+		{
+			name:    "op duplicate add",
+			files:   map[string][]listEntry{opsFile: {{UUID: notchUUID, Name: "Notch", Level: 3}}},
+			req:     PlayerRequest{Action: PlayerOp, Players: []string{"Notch"}},
+			file:    opsFile,
+			wantMsg: "Notch is already on ops.json",
+			want:    []listEntry{{UUID: notchUUID, Name: "Notch", Level: 3}},
+		},
+		{
+			name:    "op remove",
+			files:   map[string][]listEntry{opsFile: {{UUID: notchUUID, Name: "Notch", Level: 4}}},
+			req:     PlayerRequest{Action: PlayerOp, Players: []string{"Notch"}, Remove: true},
+			file:    opsFile,
+			wantMsg: "removed Notch from ops.json",
+			want:    []listEntry{},
+		},
+		{
+			name:    "ban",
+			req:     PlayerRequest{Action: PlayerBan, Players: []string{"Notch"}, Reason: "Griefing"},
+			file:    bannedPlayersFile,
+			wantMsg: "added Notch to banned-players.json",
+			want:    []listEntry{{UUID: notchUUID, Name: "Notch", Source: SourceManager, Expires: "forever", Reason: "Griefing"}},
+		},
+		{
+			name:    "ban default reason",
+			req:     PlayerRequest{Action: PlayerBan, Players: []string{"Notch"}},
+			file:    bannedPlayersFile,
+			wantMsg: "added Notch to banned-players.json",
+			want:    []listEntry{{UUID: notchUUID, Name: "Notch", Source: SourceManager, Expires: "forever", Reason: defaultBanReason}},
+		},
+		{
+			name:    "ban duplicate",
+			files:   map[string][]listEntry{bannedPlayersFile: {{UUID: notchUUID, Name: "Notch", Reason: "Griefing"}}},
+			req:     PlayerRequest{Action: PlayerBan, Players: []string{"Notch"}, Reason: "Again"},
+			file:    bannedPlayersFile,
+			wantMsg: "Notch is already on banned-players.json",
+			want:    []listEntry{{UUID: notchUUID, Name: "Notch", Reason: "Griefing"}},
+		},
+		{
+			name:    "pardon",
+			files:   map[string][]listEntry{bannedPlayersFile: {{UUID: notchUUID, Name: "Notch", Reason: "Griefing"}}},
+			req:     PlayerRequest{Action: PlayerPardon, Players: []string{"Notch"}},
+			file:    bannedPlayersFile,
+			wantMsg: "removed Notch from banned-players.json",
+			want:    []listEntry{},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			setupFakeServer(t, "test", cmp.Or(tc.props, "online-mode=false\n"))
+			registerServer(t, "test")
+			for file, entries := range tc.files {
+				writeJSON(t, "test", file, entries)
+			}
+			if tc.userCache != nil {
+				writeJSON(t, "test", userCacheFile, tc.userCache)
+			}
+
+			tc.req.Servers = []string{"test"}
+			var msgs []string
+			err := ManagePlayers(context.Background(), tc.req, func(msg string) error {
+				msgs = append(msgs, msg)
+				return nil
+			})
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("ManagePlayers() succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ManagePlayers() failed: %v", err)
+			}
+			if want := "test: " + tc.wantMsg; len(msgs) != 1 || msgs[0] != want {
+				t.Errorf("ManagePlayers() wrote %q, want %q", msgs, want)
+			}
+			if got := readEntries(t, "test", tc.file); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("%s = %+v, want %+v", tc.file, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSyncWhitelists(t *testing.T) {
+	setupFakeServer(t, "lobby", "online-mode=false\n")
+	whitelists := map[string][]listEntry{
+		"lobby":    {{UUID: notchUUID, Name: "Notch"}, {UUID: "1", Name: "jeb_"}},
+		"survival": {{UUID: "1", Name: "JEB_"}, {UUID: "2", Name: "Dinnerbone"}},
+		"creative": nil,
+	}
+	for server, entries := range whitelists {
+		registerServer(t, server)
+		dir := common.ServerDirectory(server)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("failed to create server directory: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "server.properties"), []byte("online-mode=false\n"), 0644); err != nil {
+			t.Fatalf("failed to write server.properties: %v", err)
+		}
+		if entries != nil {
+			writeJSON(t, server, whitelistFile, entries)
+		}
+	}
+	// Added players are resolved through the user caches of all servers.
+	writeJSON(t, "survival", userCacheFile, []userCacheEntry{{Name: "Grumm", UUID: "3"}})
+
+	req := PlayerRequest{Action: PlayerWhitelist, Players: []string{"grumm", "Alex"}, Servers: []string{"creative", "lobby", "survival"}, Sync: true}
+	var msgs []string
+	if err := ManagePlayers(context.Background(), req, func(msg string) error {
+		msgs = append(msgs, msg)
+		return nil
+	}); err != nil {
+		t.Fatalf("ManagePlayers() failed: %v", err)
+	}
+	want := []listEntry{
+		{UUID: offlineUUID("Alex"), Name: "Alex"},
+		{UUID: "2", Name: "Dinnerbone"},
+		{UUID: "3", Name: "Grumm"},
+		{UUID: "1", Name: "jeb_"},
+		{UUID: notchUUID, Name: "Notch"},
+	}
+	for server := range whitelists {
+		if got := readEntries(t, server, whitelistFile); !reflect.DeepEqual(got, want) {
+			t.Errorf("whitelist of %s = %+v, want %+v", server, got, want)
+		}
+	}
+	if len(msgs) != len(whitelists) || !strings.Contains(msgs[0], "wrote 5 players") {
+		t.Errorf("ManagePlayers() wrote %q, want a message per server", msgs)
+	}
+
+	// Removed players are removed from every server.
+	req = PlayerRequest{Action: PlayerWhitelist, Players: []string{"NOTCH"}, Remove: true, Servers: req.Servers, Sync: true}
+	if err := ManagePlayers(context.Background(), req, func(string) error { return nil }); err != nil {
+		t.Fatalf("ManagePlayers() failed: %v", err)
+	}
+	want = want[:len(want)-1]
+	for server := range whitelists {
+		if got := readEntries(t, server, whitelistFile); !reflect.DeepEqual(got, want) {
+			t.Errorf("whitelist of %s after removal = %+v, want %+v", server, got, want)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/common"
+	"github.com/dranilew/minecraft-server-manager/src/lib/logger"
+	"github.com/dranilew/minecraft-server-manager/src/lib/server/properties"
+)
+
+const (
+	// portBlockSize is the number of ports reserved for each server: one each
+	// for the game, query and RCON.
+	portBlockSize = 3
+	// maxPort is the highest port that is allocated.
+	maxPort = 65535
+)
+
+// Ports are the ports used by a server.
+type Ports struct {
+	// Game is the port players connect to.
+	Game int
+	// Query is the UDP port answering GameSpy4 queries.
+	Query int
+	// RCON is the port accepting remote console connections.
+	RCON int
+}
+
+// String formats the ports for display.
+func (p Ports) String() string {
+	return fmt.Sprintf("game %d, query %d, rcon %d", p.Game, p.Query, p.RCON)
+}
+
+// namedPort is a port along with its name.
+type namedPort struct {
+	name string
+	port int
+}
+
+// list returns the ports along with their names.
+func (p Ports) list() []namedPort {
+	return []namedPort{{"game", p.Game}, {"query", p.Query}, {"rcon", p.RCON}}
+}
+
+// PortsOf returns the ports of the server.
+func PortsOf(status *common.ServerStatus) Ports {
+	return Ports{Game: status.Port, Query: status.QueryPort, RCON: status.RCONPort}
+}
+
+// SetPortsRequest is a request to reassign the ports of a server.
+type SetPortsRequest struct {
+	// Server is the server whose ports to change.
+	Server string
+	// Ports are the new ports. Zero ports are left unchanged.
+	Ports Ports
+}
+
+// PortConflict is a port used by more than one server.
+type PortConflict struct {
+	// Port is the conflicting port.
+	Port int
+	// Servers are the servers using the port.
+	Servers []string
+}
+
+// PortConflicts returns the ports used by more than one registered server.
+// The caller must hold the lock.
+func PortConflicts() []PortConflict {
+	users := make(map[int][]string)
+	for name, status := range common.ServerStatuses {
+		for _, p := range PortsOf(status).list() {
+			if p.port != 0 && !slices.Contains(users[p.port], name) {
+				users[p.port] = append(users[p.port], name)
+			}
+		}
+	}
+	var conflicts []PortConflict
+	for port, servers := range users {
+		if len(servers) > 1 {
+			slices.Sort(servers)
+			conflicts = append(conflicts, PortConflict{Port: port, Servers: servers})
+		}
+	}
+	slices.SortFunc(conflicts, func(a, b PortConflict) int { return a.Port - b.Port })
+	return conflicts
+}
+
+// usedPorts returns the ports of all registered servers except the given
+// one, mapped to the server using them. The caller must hold the lock.
+func usedPorts(except string) map[int]string {
+	used := make(map[int]string)
+	for name, status := range common.ServerStatuses {
+		if name == except {
+			continue
+		}
+		for _, p := range PortsOf(status).list() {
+			if p.port != 0 {
+				used[p.port] = name
+			}
+		}
+	}
+	return used
+}
+
+// portAvailable returns whether nothing on the host is bound to the port.
+// The game and RCON ports use TCP, and the query port uses UDP.
+func portAvailable(port int, udp bool) bool {
+	addr := net.JoinHostPort("", strconv.Itoa(port))
+	if udp {
+		conn, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return false
+	}
+	l.Close()
+	return true
+}
+
+// allocatePorts returns the first free block of ports above the base port.
+// Ports are free if no other server uses them, and nothing on the host is
+// bound to them. The caller must hold the lock.
+func allocatePorts(server string) (Ports, error) {
+	used := usedPorts(server)
+	for port := baseServerPort; port+portBlockSize-1 <= maxPort; port++ {
+		ports := Ports{Game: port, Query: port + 1, RCON: port + 2}
+		if checkPorts(ports, used) == nil {
+			return ports, nil
+		}
+	}
+	return Ports{}, fmt.Errorf("no free block of %d ports above %d", portBlockSize, baseServerPort)
+}
+
+// allocatePort returns the first free port above the base port that isn't
+// part of the given ports. The caller must hold the lock.
+func allocatePort(server string, ports Ports, udp bool) (int, error) {
+	used := usedPorts(server)
+	for port := baseServerPort; port <= maxPort; port++ {
+		if _, ok := used[port]; ok || slices.Contains([]int{ports.Game, ports.Query, ports.RCON}, port) {
+			continue
+		}
+		if portAvailable(port, udp) {
+			return port, nil
+		}
+	}
+	return 0, fmt.Errorf("no free port above %d", baseServerPort)
+}
+
+// checkPorts returns an error if any of the ports is used by another server,
+// or something on the host is bound to it.
+func checkPorts(ports Ports, used map[int]string) error {
+	seen := make(map[int]string)
+	for _, p := range ports.list() {
+		name, port := p.name, p.port
+		if port < 1 || port > maxPort {
+			return fmt.Errorf("%s port %d is out of range", name, port)
+		}
+		if other, ok := seen[port]; ok {
+			return fmt.Errorf("%s port %d is also the %s port", name, port, other)
+		}
+		seen[port] = name
+		if user, ok := used[port]; ok {
+			return fmt.Errorf("%s port %d is used by server %q", name, port, user)
+		}
+		if !portAvailable(port, name == "query") {
+			return fmt.Errorf("%s port %d is already in use on the host", name, port)
+		}
+	}
+	return nil
+}
+
+// completePorts allocates the missing query and RCON ports of servers
+// registered before they were tracked. The caller must hold the lock.
+func completePorts(server string, ports Ports) (Ports, error) {
+	var err error
+	if ports.Query == 0 {
+		if ports.Query, err = allocatePort(server, ports, true); err != nil {
+			return Ports{}, err
+		}
+	}
+	if ports.RCON == 0 {
+		if ports.RCON, err = allocatePort(server, ports, false); err != nil {
+			return Ports{}, err
+		}
+	}
+	return ports, nil
+}
+
+// reservePorts returns the ports of the server about to start, and whether it
+// is a new server. New servers are registered with a freshly allocated block
+// of ports, and missing ports of existing servers are allocated. An error is
+// returned if any port conflicts with another server or is in use.
+func reservePorts(server string) (Ports, bool, error) {
+	common.ServerStatusesMu.Lock()
+	defer common.ServerStatusesMu.Unlock()
+	status, ok := common.ServerStatuses[server]
+	if !ok {
+		ports, err := allocatePorts(server)
+		if err != nil {
+			return Ports{}, false, err
+		}
+		common.ServerStatuses[server] = &common.ServerStatus{
+			Name:      server,
+			Port:      ports.Game,
+			QueryPort: ports.Query,
+			RCONPort:  ports.RCON,
+		}
+		return ports, true, nil
+	}
+
+	ports, err := completePorts(server, PortsOf(status))
+	if err != nil {
+		return Ports{}, false, err
+	}
+	if err := checkPorts(ports, usedPorts(server)); err != nil {
+		return Ports{}, false, err
+	}
+	status.QueryPort = ports.Query
+	status.RCONPort = ports.RCON
+	return ports, false, nil
+}
+
+// SetPorts reassigns the ports of a stopped server.
+func SetPorts(ctx context.Context, req SetPortsRequest) error {
+	runningServers, err := GetRunningServers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get running servers: %v", err)
+	}
+	if slices.Contains(runningServers, req.Server) {
+		return fmt.Errorf("server %q is running, stop it before changing its ports", req.Server)
+	}
+	// Sleeping servers are listened on by the manager itself.
+	closeWakeListener(req.Server)
+
+	common.ServerStatusesMu.Lock()
+	status, ok := common.ServerStatuses[req.Server]
+	if !ok {
+		common.ServerStatusesMu.Unlock()
+		return fmt.Errorf("server %q is not registered", req.Server)
+	}
+	ports := PortsOf(status)
+	if req.Ports.Game != 0 {
+		ports.Game = req.Ports.Game
+	}
+	if req.Ports.Query != 0 {
+		ports.Query = req.Ports.Query
+	}
+	if req.Ports.RCON != 0 {
+		ports.RCON = req.Ports.RCON
+	}
+	ports, err = completePorts(req.Server, ports)
+	if err == nil {
+		err = checkPorts(ports, usedPorts(req.Server))
+	}
+	if err != nil {
+		common.ServerStatusesMu.Unlock()
+		return err
+	}
+	status.Port = ports.Game
+	status.QueryPort = ports.Query
+	status.RCONPort = ports.RCON
+	common.ServerStatusesMu.Unlock()
+
+	logger.Printf("%q: Setting ports to %v", req.Server, ports)
+	if err := setPorts(req.Server, ports); err != nil {
+		return err
+	}
+	return common.UpdateServerStatus()
+}
+
+// setPorts modifies the server's server.properties file to use the ports.
+func setPorts(server string, ports Ports) error {
+	path := filepath.Join(common.ServerDirectory(server), properties.FileName)
+	props, err := properties.Load(path)
+	if err != nil {
+		return fmt.Errorf("failed to load %q server.properties: %v", server, err)
+	}
+	props.Set("server-port", strconv.Itoa(ports.Game))
+	props.Set("query.port", strconv.Itoa(ports.Query))
+	props.Set("rcon.port", strconv.Itoa(ports.RCON))
+	if err := props.Save(path); err != nil {
+		return fmt.Errorf("failed to write %q server.properties: %v", server, err)
+	}
+	return nil
+}
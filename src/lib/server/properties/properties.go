@@ -0,0 +1,325 @@
+// Package properties reads and edits Java properties files, like the
+// server.properties file of minecraft servers, preserving comments, blank
+// lines and the order of keys.
+package properties
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/fileutil"
+)
+
+// FileName is the name of the properties file of a minecraft server.
+const FileName = "server.properties"
+
+// Properties is a parsed properties file.
+type Properties struct {
+	entries []*entry
+	// newline is the line ending used by the file.
+	newline string
+	// trailingNewline indicates whether the file ends with a line ending.
+	trailingNewline bool
+	// latin1 indicates whether the file is encoded in ISO-8859-1 instead of
+	// UTF-8.
+	latin1 bool
+}
+
+// entry is a comment, a blank line or a key-value pair. Entries that weren't
+// changed are written back exactly as they were read.
+type entry struct {
+	// raw is the original text of the entry, without the final line ending.
+	// This is empty for entries that were changed.
+	raw string
+	// isProperty indicates whether the entry is a key-value pair.
+	isProperty bool
+	key        string
+	value      string
+}
+
+// Parse parses the contents of a properties file.
+func Parse(data []byte) (*Properties, error) {
+	p := &Properties{newline: "\n", trailingNewline: true}
+	content := string(data)
+	if !utf8.Valid(data) {
+		// Java traditionally writes properties in ISO-8859-1.
+		p.latin1 = true
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		content = string(runes)
+	}
+	if strings.Contains(content, "\r\n") {
+		p.newline = "\r\n"
+	}
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	if content == "" {
+		return p, nil
+	}
+	p.trailingNewline = strings.HasSuffix(content, "\n")
+	lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimLeft(line, " \t\f")
+		if trimmed == "" || trimmed[0] == '#' || trimmed[0] == '!' {
+			p.entries = append(p.entries, &entry{raw: line})
+			continue
+		}
+
+		// Lines ending with an odd number of backslashes continue on the next
+		// line, whose leading whitespace is ignored.
+		raw := []string{line}
+		logical := trimmed
+		for continues(logical) && i+1 < len(lines) {
+			i++
+			raw = append(raw, lines[i])
+			logical = logical[:len(logical)-1] + strings.TrimLeft(lines[i], " \t\f")
+		}
+		if continues(logical) {
+			logical = logical[:len(logical)-1]
+		}
+
+		key, value, err := splitProperty(logical)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		p.entries = append(p.entries, &entry{raw: strings.Join(raw, "\n"), isProperty: true, key: key, value: value})
+	}
+	return p, nil
+}
+
+// Load reads and parses the properties file at the given path.
+func Load(path string) (*Properties, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", filepath.Base(path), err)
+	}
+	p, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", filepath.Base(path), err)
+	}
+	return p, nil
+}
+
+// continues returns whether the line ends with an odd number of backslashes.
+func continues(line string) bool {
+	n := 0
+	for i := len(line) - 1; i >= 0 && line[i] == '\\'; i-- {
+		n++
+	}
+	return n%2 == 1
+}
+
+// splitProperty splits a logical line into its unescaped key and value. The
+// key ends at the first unescaped '=', ':' or whitespace.
+func splitProperty(line string) (string, string, error) {
+	end := len(line)
+	for i := 0; i < len(line); i++ {
+		if line[i] == '\\' {
+			i++
+			continue
+		}
+		if strings.IndexByte("=: \t\f", line[i]) >= 0 {
+			end = i
+			break
+		}
+	}
+	rest := strings.TrimLeft(line[end:], " \t\f")
+	if rest != "" && (rest[0] == '=' || rest[0] == ':') {
+		rest = strings.TrimLeft(rest[1:], " \t\f")
+	}
+
+	key, err := unescape(line[:end])
+	if err != nil {
+		return "", "", err
+	}
+	value, err := unescape(rest)
+	if err != nil {
+		return "", "", err
+	}
+	return key, value, nil
+}
+
+// unescape resolves the escape sequences in the string.
+func unescape(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 'f':
+			b.WriteByte('\f')
+		case 'u':
+			if i+5 > len(s) {
+				return "", fmt.Errorf("malformed \\u escape in %q", s)
+			}
+			r, err := strconv.ParseUint(s[i+1:i+5], 16, 16)
+			if err != nil {
+				return "", fmt.Errorf("malformed \\u escape in %q", s)
+			}
+			i += 4
+			// Characters outside the BMP are escaped as surrogate pairs.
+			if utf16.IsSurrogate(rune(r)) && i+7 <= len(s) && s[i+1:i+3] == `\u` {
+				if low, err := strconv.ParseUint(s[i+3:i+7], 16, 16); err == nil {
+					if pair := utf16.DecodeRune(rune(r), rune(low)); pair != utf8.RuneError {
+						b.WriteRune(pair)
+						i += 6
+						continue
+					}
+				}
+			}
+			b.WriteRune(rune(r))
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// escape escapes the string like Java's Properties.store, so that it is read
+// back unchanged. Keys additionally escape spaces.
+func escape(s string, isKey bool) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch r {
+		case '\\':
+			b.WriteString(`\\`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '=', ':', '#', '!':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case ' ':
+			if isKey || i == 0 {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(r)
+		default:
+			if r < 0x20 || r > 0x7e {
+				// Non-ASCII characters are escaped, so that the file reads the
+				// same in ISO-8859-1 and UTF-8.
+				for _, unit := range utf16Units(r) {
+					fmt.Fprintf(&b, `\u%04X`, unit)
+				}
+				continue
+			}
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// utf16Units returns the UTF-16 code units of the rune.
+func utf16Units(r rune) []rune {
+	if r < 0x10000 {
+		return []rune{r}
+	}
+	r -= 0x10000
+	return []rune{0xd800 + (r>>10)&0x3ff, 0xdc00 + r&0x3ff}
+}
+
+// find returns the last entry of the key, as later entries override earlier ones.
+func (p *Properties) find(key string) *entry {
+	for i := len(p.entries) - 1; i >= 0; i-- {
+		if e := p.entries[i]; e.isProperty && e.key == key {
+			return e
+		}
+	}
+	return nil
+}
+
+// Get returns the value of the key, and whether it is set.
+func (p *Properties) Get(key string) (string, bool) {
+	if e := p.find(key); e != nil {
+		return e.value, true
+	}
+	return "", false
+}
+
+// Set sets the value of the key in place, or appends it if it isn't set.
+func (p *Properties) Set(key, value string) {
+	if e := p.find(key); e != nil {
+		if e.value != value {
+			e.value = value
+			e.raw = ""
+		}
+		return
+	}
+	p.entries = append(p.entries, &entry{isProperty: true, key: key, value: value})
+}
+
+// Unset removes the key, and returns whether it was set.
+func (p *Properties) Unset(key string) bool {
+	n := len(p.entries)
+	p.entries = slices.DeleteFunc(p.entries, func(e *entry) bool { return e.isProperty && e.key == key })
+	return len(p.entries) != n
+}
+
+// Keys returns the keys in the order they appear in the file.
+func (p *Properties) Keys() []string {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, e := range p.entries {
+		if e.isProperty && !seen[e.key] {
+			seen[e.key] = true
+			keys = append(keys, e.key)
+		}
+	}
+	return keys
+}
+
+// Bytes returns the contents of the properties file.
+func (p *Properties) Bytes() []byte {
+	var lines []string
+	for _, e := range p.entries {
+		raw := e.raw
+		if raw == "" && e.isProperty {
+			raw = escape(e.key, true) + "=" + escape(e.value, false)
+		}
+		lines = append(lines, strings.ReplaceAll(raw, "\n", p.newline))
+	}
+	content := strings.Join(lines, p.newline)
+	if p.trailingNewline && len(lines) > 0 {
+		content += p.newline
+	}
+	if !p.latin1 {
+		return []byte(content)
+	}
+	// Changed entries are escaped to ASCII, so all runes fit in a byte.
+	var data []byte
+	for _, r := range content {
+		data = append(data, byte(r))
+	}
+	return data
+}
+
+// Save atomically writes the properties file to the given path.
+func (p *Properties) Save(path string) error {
+	return fileutil.WriteFile(path, p.Bytes(), 0644)
+}
@@ -0,0 +1,179 @@
+package properties
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// serverProperties is a typical server.properties file written by the server.
+const serverProperties = `#Minecraft server properties
+#Thu Jan 01 00:00:00 UTC 2026
+allow-flight=false
+difficulty=easy
+enable-rcon=false
+level-name=world
+motd=A Minecraft Server
+server-port=25565
+white-list=false
+`
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "server properties", data: serverProperties},
+		{name: "empty", data: ""},
+		{name: "crlf", data: "#comment\r\nmotd=Hello\r\nserver-port=25565\r\n"},
+		{name: "no trailing newline", data: "motd=Hello\nserver-port=25565"},
+		{name: "blank lines and comments", data: "\n# comment\n! bang comment\n   \n\tmotd=Hello\n\n"},
+		{name: "separators", data: "a=1\nb = 2\nc:3\nd 4\ne\t\t5\nf\n"},
+		{name: "escapes", data: "motd=\\u00a7aGreen \\= \\: \\\\ \\t\nkey\\ with\\ spaces=value\n"},
+		{name: "continuation lines", data: "motd=first \\\n    second \\\n\tthird\nnext=1\n"},
+		{name: "duplicate keys", data: "motd=one\nmotd=two\n"},
+		{name: "utf-8", data: "motd=Café ✓\n"},
+		{name: "latin-1", data: "motd=Caf\xe9\n"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), FileName)
+			if err := os.WriteFile(path, []byte(tc.data), 0644); err != nil {
+				t.Fatalf("failed to write %s: %v", FileName, err)
+			}
+			p, err := Load(path)
+			if err != nil {
+				t.Fatalf("Load() failed: %v", err)
+			}
+			if err := p.Save(path); err != nil {
+				t.Fatalf("Save() failed: %v", err)
+			}
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("failed to read %s: %v", FileName, err)
+			}
+			if !bytes.Equal(got, []byte(tc.data)) {
+				t.Errorf("Save() wrote %q, want %q", got, tc.data)
+			}
+		})
+	}
+}
+
+func TestGet(t *testing.T) {
+	p, err := Parse([]byte("motd=\\u00a7aGreen\nemoji=hi \\uD83D\\uDE00\nmulti=a \\\n  b\nsep : value\ndup=one\ndup=two\n"))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	tests := map[string]string{
+		"motd":  "\u00a7aGreen",
+		"emoji": "hi 😀",
+		"multi": "a b",
+		"sep":   "value",
+		"dup":   "two",
+	}
+	for key, want := range tests {
+		if got, ok := p.Get(key); !ok || got != want {
+			t.Errorf("Get(%q) = (%q, %t), want (%q, true)", key, got, ok, want)
+		}
+	}
+	if _, ok := p.Get("missing"); ok {
+		t.Errorf("Get(%q) found a value", "missing")
+	}
+}
+
+func TestSetRoundTrip(t *testing.T) {
+	values := []string{
+		"A Minecraft Server",
+		"§aGreen §lBold",
+		"hi 😀",
+		"𝄞 and ✓",
+		"key=value: with\ttabs\\",
+		" leading space",
+	}
+	for _, value := range values {
+		p, err := Parse(nil)
+		if err != nil {
+			t.Fatalf("Parse() failed: %v", err)
+		}
+		p.Set("motd", value)
+		path := filepath.Join(t.TempDir(), FileName)
+		if err := p.Save(path); err != nil {
+			t.Fatalf("Save() failed: %v", err)
+		}
+		loaded, err := Load(path)
+		if err != nil {
+			t.Fatalf("Load() failed: %v", err)
+		}
+		if got, _ := loaded.Get("motd"); got != value {
+			t.Errorf("Get() after Set(%q) and Save = %q", value, got)
+		}
+	}
+}
+
+func TestEdit(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		edit func(p *Properties)
+		want string
+	}{
+		{
+			name: "set keeps other lines",
+			data: serverProperties,
+			edit: func(p *Properties) { p.Set("difficulty", "hard") },
+			want: `#Minecraft server properties
+#Thu Jan 01 00:00:00 UTC 2026
+allow-flight=false
+difficulty=hard
+enable-rcon=false
+level-name=world
+motd=A Minecraft Server
+server-port=25565
+white-list=false
+`,
+		},
+		{
+			name: "set same value keeps line",
+			data: "motd = Hello\n",
+			edit: func(p *Properties) { p.Set("motd", "Hello") },
+			want: "motd = Hello\n",
+		},
+		{
+			name: "set appends new key",
+			data: "motd=Hello\r\n",
+			edit: func(p *Properties) { p.Set("pvp", "true") },
+			want: "motd=Hello\r\npvp=true\r\n",
+		},
+		{
+			name: "set escapes",
+			data: "motd=Hello\n",
+			edit: func(p *Properties) { p.Set("motd", "§a=Green:") },
+			want: "motd=\\u00A7a\\=Green\\:\n",
+		},
+		{
+			name: "set escapes latin-1",
+			data: "motd=Caf\xe9\n",
+			edit: func(p *Properties) { p.Set("name", "✓") },
+			want: "motd=Caf\xe9\nname=\\u2713\n",
+		},
+		{
+			name: "unset",
+			data: "motd=one\npvp=true\nmotd=two\n",
+			edit: func(p *Properties) { p.Unset("motd") },
+			want: "pvp=true\n",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := Parse([]byte(tc.data))
+			if err != nil {
+				t.Fatalf("Parse() failed: %v", err)
+			}
+			tc.edit(p)
+			if got := string(p.Bytes()); got != tc.want {
+				t.Errorf("Bytes() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"flag"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/common"
+	"github.com/dranilew/minecraft-server-manager/src/lib/logger"
+)
+
+var (
+	// crashWindow is the window in which recovery restarts are counted.
+	crashWindow = flag.Duration("crash-window", 15*time.Minute, "Window in which recovery restarts of a server are counted towards the crash-loop limit.")
+	// crashMaxRestarts is the number of recovery restarts within the window
+	// after which a server is marked as failed.
+	crashMaxRestarts = flag.Int("crash-max-restarts", 5, "Number of recovery restarts within the crash window after which a server is marked as failed and no longer recovered.")
+	// crashBackoff is the delay before the second recovery restart within
+	// the window. It doubles with every further restart.
+	crashBackoff = flag.Duration("crash-backoff", 10*time.Second, "Delay before recovering a server that already crashed within the crash window. This doubles with every further crash.")
+	// crashMaxBackoff is the longest delay between recovery restarts.
+	crashMaxBackoff = flag.Duration("crash-max-backoff", 5*time.Minute, "Longest delay between recovery restarts of a server.")
+)
+
+// ShouldRecover records that the server has stopped unexpectedly, and returns
+// whether it should be restarted now. Restarts within the crash window are
+// delayed with exponential backoff, and the server is marked as failed once
+// it has been restarted too often.
+func ShouldRecover(server string) bool {
+	recover, changed := shouldRecover(server, time.Now())
+	if changed {
+		if err := common.UpdateServerStatus(); err != nil {
+			logger.Printf("Failed to update server status: %v", err)
+		}
+	}
+	return recover
+}
+
+// shouldRecover implements ShouldRecover, and additionally returns whether
+// the status of the server has changed.
+func shouldRecover(server string, now time.Time) (bool, bool) {
+	common.ServerStatusesMu.Lock()
+	defer common.ServerStatusesMu.Unlock()
+	status, ok := common.ServerStatuses[server]
+	if !ok || status.State == common.StateFailed {
+		return false, false
+	}
+
+	changed := status.SetState(common.StateCrashed)
+	policy := recoveryPolicyOf(server)
+	if !*policy.Enabled {
+		if changed {
+			logger.Printf("Server %q stopped unexpectedly, recovery is disabled by its %s", server, ManifestFile)
+		}
+		return false, changed
+	}
+	if status.NextRetry.IsZero() {
+		// Only count restarts within the window.
+		status.Restarts = slices.DeleteFunc(status.Restarts, func(t time.Time) bool {
+			return now.Sub(t) > policy.Window
+		})
+		if len(status.Restarts) >= policy.MaxRestarts {
+			logger.Printf("Server %q was restarted %d times within %s, marking it as failed", server, len(status.Restarts), formatDuration(policy.Window))
+			status.SetState(common.StateFailed)
+			return false, true
+		}
+		status.NextRetry = now.Add(recoveryBackoff(policy, len(status.Restarts)))
+		changed = true
+		if len(status.Restarts) > 0 {
+			logger.Printf("Server %q stopped unexpectedly %d times within %s, retrying at %s", server, len(status.Restarts)+1, formatDuration(policy.Window), status.NextRetry.Format(time.TimeOnly))
+		}
+	}
+	if now.Before(status.NextRetry) {
+		return false, changed
+	}
+	status.Restarts = append(status.Restarts, now)
+	status.NextRetry = time.Time{}
+	status.SetState(common.StateRecovering)
+	return true, true
+}
+
+// recoveryBackoff returns the delay before recovering a server that has
+// already been restarted the given number of times within the window.
+func recoveryBackoff(policy RecoveryPolicy, restarts int) time.Duration {
+	if restarts == 0 {
+		return 0
+	}
+	backoff := policy.Backoff
+	for range restarts - 1 {
+		backoff *= 2
+		if backoff >= policy.MaxBackoff {
+			return policy.MaxBackoff
+		}
+	}
+	return min(backoff, policy.MaxBackoff)
+}
+
+// ResetFailed clears the failed state and restart count of the servers, so
+// that they are recovered again.
+func ResetFailed(servers ...string) error {
+	common.ServerStatusesMu.Lock()
+	for _, server := range servers {
+		status, ok := common.ServerStatuses[server]
+		if !ok {
+			common.ServerStatusesMu.Unlock()
+			return fmt.Errorf("server %q is not registered", server)
+		}
+		if status.State == common.StateFailed {
+			status.SetState(common.StateCrashed)
+		}
+		status.Restarts = nil
+		status.NextRetry = time.Time{}
+	}
+	common.ServerStatusesMu.Unlock()
+	return common.UpdateServerStatus()
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/common"
+	"github.com/dranilew/minecraft-server-manager/src/lib/logger"
+)
+
+// archiveDir is the directory archived servers are moved to.
+var archiveDir = flag.String("archivedir", "/etc/minecraft/archive", "Location to move archived minecraft servers to")
+
+// ArchiveRequest is a request to archive a server.
+type ArchiveRequest struct {
+	// Server is the server to archive.
+	Server string
+	// Bucket is the location to which to save the final backup.
+	Bucket string
+	// SkipBackup skips the final backup.
+	SkipBackup bool
+}
+
+// Register registers the servers in the modpack directory without starting
+// them. Each server is given a free block of ports.
+func Register(servers ...string) error {
+	var errs []error
+	var registered bool
+	for _, server := range servers {
+		if err := register(server); err != nil {
+			errs = append(errs, fmt.Errorf("failed to register server %q: %v", server, err))
+			continue
+		}
+		registered = true
+	}
+	if registered {
+		errs = append(errs, common.UpdateServerStatus(), common.UpdateBackupStatus())
+	}
+	return errors.Join(errs...)
+}
+
+// register registers a single server without persisting the statuses.
+func register(server string) error {
+	if !serverNameRegex.MatchString(server) {
+		return fmt.Errorf("invalid server name")
+	}
+	dir := common.ServerDirectory(server)
+	if _, err := os.Stat(filepath.Join(dir, runScript)); err != nil {
+		return fmt.Errorf("%s not found in %s", runScript, dir)
+	}
+
+	common.ServerStatusesMu.Lock()
+	if _, ok := common.ServerStatuses[server]; ok {
+		common.ServerStatusesMu.Unlock()
+		return fmt.Errorf("server is already registered")
+	}
+	ports, err := allocatePorts(server)
+	if err == nil {
+		err = seedProperties(dir, server, ports)
+	}
+	if err != nil {
+		common.ServerStatusesMu.Unlock()
+		return err
+	}
+	status := &common.ServerStatus{Name: server, Port: ports.Game, QueryPort: ports.Query, RCONPort: ports.RCON}
+	status.SetState(common.StateStopped)
+	common.ServerStatuses[server] = status
+	common.ServerStatusesMu.Unlock()
+
+	common.BackupStatusesMu.Lock()
+	common.BackupStatuses[server] = true
+	common.BackupStatusesMu.Unlock()
+	logger.Printf("Registered server %q with ports %v", server, ports)
+	return nil
+}
+
+// Unregister removes the stopped servers from the manager. Their directories
+// are left in place.
+func Unregister(ctx context.Context, servers ...string) error {
+	runningServers, err := GetRunningServers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get running servers: %v", err)
+	}
+	var errs []error
+	for _, server := range servers {
+		if slices.Contains(runningServers, server) {
+			errs = append(errs, fmt.Errorf("server %q is running, stop it before unregistering it", server))
+			continue
+		}
+		common.ServerStatusesMu.Lock()
+		_, ok := common.ServerStatuses[server]
+		common.ServerStatusesMu.Unlock()
+		if !ok {
+			errs = append(errs, fmt.Errorf("server %q is not registered", server))
+			continue
+		}
+		if err := forget(server); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		logger.Printf("Unregistered server %q", server)
+	}
+	return errors.Join(errs...)
+}
+
+// forget removes the server from the server and backup statuses, and drops
+// everything the manager tracks about it.
+func forget(server string) error {
+	common.ServerStatusesMu.Lock()
+	delete(common.ServerStatuses, server)
+	common.ServerStatusesMu.Unlock()
+	common.BackupStatusesMu.Lock()
+	delete(common.BackupStatuses, server)
+	common.BackupStatusesMu.Unlock()
+
+	currentConfigurationsMu.Lock()
+	delete(currentConfigurations, server)
+	currentConfigurationsMu.Unlock()
+	knownCrashReportsMu.Lock()
+	delete(knownCrashReports, server)
+	knownCrashReportsMu.Unlock()
+	stateCursor.forget(server)
+	watchdogCursor.forget(server)
+	forgetManifest(server)
+
+	if err := common.UpdateServerStatus(); err != nil {
+		return fmt.Errorf("failed to update server status: %v", err)
+	}
+	if err := common.UpdateBackupStatus(); err != nil {
+		return fmt.Errorf("failed to update backup status: %v", err)
+	}
+	return nil
+}
+
+// Archive stops the server, takes a final backup with the given function,
+// moves the server to the archive directory and unregisters it. Progress is
+// written to out.
+func Archive(ctx context.Context, req ArchiveRequest, backup func(context.Context, string) error, out func(string) error) error {
+	dir := common.ServerDirectory(req.Server)
+	if _, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("server %q not found: %v", req.Server, err)
+	}
+
+	// Keep the manager from starting the server again while it is archived.
+	common.ServerStatusesMu.Lock()
+	status, registered := common.ServerStatuses[req.Server]
+	if registered {
+		status.ShouldRun = false
+		status.NextRetry = time.Time{}
+	}
+	common.ServerStatusesMu.Unlock()
+	if registered {
+		if err := common.UpdateServerStatus(); err != nil {
+			return fmt.Errorf("failed to update server status: %v", err)
+		}
+	}
+
+	runningServers, err := GetRunningServers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get running servers: %v", err)
+	}
+	if slices.Contains(runningServers, req.Server) {
+		if err := out(fmt.Sprintf("Stopping server %q", req.Server)); err != nil {
+			return err
+		}
+		if err := Stop(ctx, StopRequest{Servers: []string{req.Server}}); err != nil {
+			return err
+		}
+		if runningServers, err = GetRunningServers(ctx); err != nil {
+			return fmt.Errorf("failed to get running servers: %v", err)
+		}
+		if slices.Contains(runningServers, req.Server) {
+			return fmt.Errorf("server %q did not stop", req.Server)
+		}
+	}
+
+	if !req.SkipBackup {
+		if err := out(fmt.Sprintf("Creating final backup of server %q", req.Server)); err != nil {
+			return err
+		}
+		if err := backup(ctx, req.Server); err != nil {
+			return fmt.Errorf("failed to create final backup: %v", err)
+		}
+	}
+
+	if err := os.MkdirAll(*archiveDir, 0755); err != nil {
+		return fmt.Errorf("failed to create archive directory: %v", err)
+	}
+	dest := filepath.Join(*archiveDir, fmt.Sprintf("%s-%s", req.Server, time.Now().Format("20060102-150405")))
+	if err := os.Rename(dir, dest); err != nil {
+		return fmt.Errorf("failed to move server to the archive: %v", err)
+	}
+	if err := forget(req.Server); err != nil {
+		return err
+	}
+	logger.Printf("Archived server %q to %q", req.Server, dest)
+	return out(fmt.Sprintf("Archived server %q to %s", req.Server, dest))
+}
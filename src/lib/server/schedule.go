@@ -0,0 +1,66 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/common"
+	"github.com/dranilew/minecraft-server-manager/src/lib/cron"
+)
+
+// ScheduleRequest is a request to change the restart schedule of a server.
+type ScheduleRequest struct {
+	// Server is the server whose restart schedule to change.
+	Server string
+	// Schedule is the new restart schedule.
+	Schedule common.RestartSchedule
+	// Clear removes the restart schedule.
+	Clear bool
+}
+
+// NextRestart returns the first time after the given time at which the
+// server should be restarted according to the schedule.
+func NextRestart(schedule *common.RestartSchedule, after time.Time) (time.Time, error) {
+	cronSchedule, err := cron.Parse(schedule.Cron)
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc := time.Local
+	if schedule.TimeZone != "" {
+		if loc, err = time.LoadLocation(schedule.TimeZone); err != nil {
+			return time.Time{}, fmt.Errorf("invalid time zone %q: %v", schedule.TimeZone, err)
+		}
+	}
+	next := cronSchedule.Next(after.In(loc))
+	if next.IsZero() {
+		return time.Time{}, fmt.Errorf("cron expression %q never activates", schedule.Cron)
+	}
+	return next, nil
+}
+
+// SetRestartSchedule changes the restart schedule of a registered server.
+func SetRestartSchedule(req ScheduleRequest) error {
+	var next time.Time
+	if !req.Clear {
+		var err error
+		if next, err = NextRestart(&req.Schedule, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	common.ServerStatusesMu.Lock()
+	status, ok := common.ServerStatuses[req.Server]
+	if !ok {
+		common.ServerStatusesMu.Unlock()
+		return fmt.Errorf("server %q is not registered", req.Server)
+	}
+	if req.Clear {
+		status.RestartSchedule = nil
+	} else {
+		schedule := req.Schedule
+		status.RestartSchedule = &schedule
+	}
+	status.NextRestart = next
+	common.ServerStatusesMu.Unlock()
+	return common.UpdateServerStatus()
+}
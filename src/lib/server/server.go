@@ -11,16 +11,14 @@ import (
 	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dranilew/minecraft-server-manager/src/lib/common"
 	"github.com/dranilew/minecraft-server-manager/src/lib/logger"
-	"github.com/dranilew/minecraft-server-manager/src/lib/run"
 )
 
 const (
-	// killServerTimeout is the number of seconds to wait for a server to stop before force-killing it.
-	killServerTimeout = 15
 	// This is the base server port. All other server ports are incremented above this.
 	baseServerPort = 25565
 	// crashReportsDir is the directory containing crash reports.
@@ -36,37 +34,12 @@ var (
 
 // GetRunningServers gets the list of servers running on the machine.
 func GetRunningServers(ctx context.Context) ([]string, error) {
-	opts := run.Options{
-		Name: "screen",
-		Args: []string{
-			"-ls",
-		},
-		OutputType: run.OutputCombined,
-		ExecMode:   run.ExecModeSync,
-	}
-	res, _ := run.WithContext(ctx, opts)
-	if res == nil { // Errors when nothing is found.
-		return nil, nil
-	}
-	lines := strings.Split(res.Output, "\n")
-
-	// Find the servers running on the machine.
-	var servers []string
-	for _, line := range lines {
-		if !strings.Contains(line, "server") {
-			continue
-		}
-
-		// First field is the PID.MODPACK.server name.
-		screenName := strings.Fields(line)[0]
-		// What we want is the MODPACK name.
-		serverName := strings.Split(screenName, ".")[1]
-		servers = append(servers, serverName)
-	}
-	return servers, nil
+	return Backend().Running(ctx)
 }
 
-// AllServers returns all possible servers, located in the base server directory.
+// AllServers returns all possible servers, located in the base server
+// directory. Hidden directories, like the staging directories of servers being
+// created, are skipped.
 func AllServers() ([]string, error) {
 	dirEntries, err := os.ReadDir(*common.ModpackLocation)
 	if err != nil {
@@ -74,13 +47,43 @@ func AllServers() ([]string, error) {
 	}
 	var res []string
 	for _, entry := range dirEntries {
-		if entry.IsDir() { // Only care about directories.
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") { // Only care about directories.
 			res = append(res, entry.Name())
 		}
 	}
 	return res, nil
 }
 
+// TagRequest is a request to change the tags of a server.
+type TagRequest struct {
+	// Server is the server to tag.
+	Server string
+	// Tags are the tags to add or remove.
+	Tags []string
+	// Remove removes the tags instead of adding them.
+	Remove bool
+}
+
+// Tag adds or removes tags of a registered server.
+func Tag(req TagRequest) error {
+	common.ServerStatusesMu.Lock()
+	status, ok := common.ServerStatuses[req.Server]
+	if !ok {
+		common.ServerStatusesMu.Unlock()
+		return fmt.Errorf("server %q is not registered", req.Server)
+	}
+	for _, tag := range req.Tags {
+		if req.Remove {
+			status.Tags = slices.DeleteFunc(status.Tags, func(t string) bool { return t == tag })
+		} else if !slices.Contains(status.Tags, tag) {
+			status.Tags = append(status.Tags, tag)
+		}
+	}
+	slices.Sort(status.Tags)
+	common.ServerStatusesMu.Unlock()
+	return common.UpdateServerStatus()
+}
+
 // Notify notifies the server with the given message.
 func Notify(ctx context.Context, server string, message string) error {
 	runningServers, err := GetRunningServers(ctx)
@@ -89,20 +92,10 @@ func Notify(ctx context.Context, server string, message string) error {
 	}
 	if !slices.Contains(runningServers, server) {
 		logger.Printf("Server %q is not running, skipping notification", server)
+		return nil
 	}
-	opts := run.Options{
-		Name: "screen",
-		Args: []string{
-			"-S",
-			server,
-			"-X",
-			"stuff",
-			fmt.Sprintf("/say %s^M", message),
-		},
-		OutputType: run.OutputNone,
-	}
-	if _, err := run.WithContext(ctx, opts); err != nil {
-		return fmt.Errorf("failed to notify server %q: %v", server, message)
+	if _, err := RunCommand(ctx, server, fmt.Sprintf("say %s", message)); err != nil {
+		return fmt.Errorf("failed to notify server %q: %v", server, err)
 	}
 	return nil
 }
@@ -115,76 +108,14 @@ func ForceSave(ctx context.Context, server string) error {
 	}
 	if !slices.Contains(runningServers, server) {
 		logger.Printf("Server %q is not running, skipping notification", server)
+		return nil
 	}
-	opts := run.Options{
-		Name: "screen",
-		Args: []string{
-			"-S",
-			server,
-			"-X",
-			"stuff",
-			"/save-all^M",
-		},
-		OutputType: run.OutputNone,
-	}
-	if _, err := run.WithContext(ctx, opts); err != nil {
+	if _, err := RunCommand(ctx, server, "save-all"); err != nil {
 		return fmt.Errorf("failed to force-save server %q: %v", server, err)
 	}
 	return nil
 }
 
-// determinePort determines the port to use for the server.
-// The boolean indicates whether the server is a new server.
-func determinePort(server string) (int, bool) {
-	// Server already has a port assigned.
-	status, ok := common.ServerStatuses[server]
-	if ok {
-		return status.Port, false
-	}
-	port := baseServerPort
-
-	// Determine the first available port.
-	common.ServerStatusesMu.Lock()
-	defer common.ServerStatusesMu.Unlock()
-	isValid := func(port int) bool {
-		for _, v := range common.ServerStatuses {
-			if v.Port == port {
-				return false
-			}
-		}
-		return true
-	}
-
-	for !isValid(port) {
-		port++
-	}
-	return port, true
-}
-
-// setPort modifies the server's server.properties file to use the new port.
-func setPort(server string, port int) error {
-	serverDir := common.ServerDirectory(server)
-	propertiesFile := filepath.Join(serverDir, "server.properties")
-	properties, err := os.ReadFile(propertiesFile)
-	if err != nil {
-		return fmt.Errorf("failed to read %q server.properties: %v", server, err)
-	}
-	lines := strings.Split(string(properties), "\n")
-
-	// Replace the proper lines in the server.properties file.
-	var resLines []string
-	for _, line := range lines {
-		if strings.HasPrefix(line, "server-port") {
-			line = fmt.Sprintf("server-port=%d", port)
-		}
-		resLines = append(resLines, line)
-	}
-	if err := os.WriteFile(propertiesFile, []byte(strings.Join(resLines, "\n")), 0755); err != nil {
-		return fmt.Errorf("failed to write %q server.properties: %v", server, err)
-	}
-	return nil
-}
-
 // Start starts all the servers.
 func Start(ctx context.Context, servers ...string) error {
 	runningServers, err := GetRunningServers(ctx)
@@ -201,20 +132,18 @@ func Start(ctx context.Context, servers ...string) error {
 		}
 
 		started = true
-		logger.Printf("%q: Determining port for server...", server)
-		port, isNew := determinePort(server)
-		common.ServerStatusesMu.Lock()
+		// Sleeping servers have their port held by the wake listener.
+		releaseWakeListener(server)
+		logger.Printf("%q: Determining ports for server...", server)
+		ports, isNew, err := reservePorts(server)
+		if err != nil {
+			return fmt.Errorf("failed to determine ports for server %q: %v", server, err)
+		}
+		logger.Printf("%q: Setting ports to %v", server, ports)
+		if err := setPorts(server, ports); err != nil {
+			return fmt.Errorf("failed to set ports for server %q: %v", server, err)
+		}
 		if isNew {
-			logger.Printf("%q: Setting port to %d", server, port)
-			if err := setPort(server, port); err != nil {
-				common.ServerStatusesMu.Unlock()
-				return fmt.Errorf("failed to set port for server %q: %v", server, err)
-			}
-			common.ServerStatuses[server] = &common.ServerStatus{
-				Name: server,
-				Port: port,
-			}
-
 			// Create and update the backup status for the new server.
 			common.BackupStatusesMu.Lock()
 			common.BackupStatuses[server] = true
@@ -223,32 +152,39 @@ func Start(ctx context.Context, servers ...string) error {
 			if err := common.UpdateBackupStatus(); err != nil {
 				return fmt.Errorf("failed to update backup status: %v", err)
 			}
-		} else {
-			logger.Printf("Got port %d for server %q", port, server)
 		}
-		common.ServerStatuses[server].ShouldRun = true
-		common.ServerStatuses[server].StartTime = time.Now()
+		common.ServerStatusesMu.Lock()
+		status := common.ServerStatuses[server]
+		status.ShouldRun = true
+		status.StartTime = time.Now()
+		status.Health = ""
+		status.IdleSince = time.Time{}
+		// Servers restarted after crashing stay recovering until ready.
+		if status.State != common.StateRecovering {
+			status.SetState(common.StateStarting)
+		}
 		common.ServerStatusesMu.Unlock()
+		stateCursor.reset(server)
+
+		// Loader updates may overwrite the files the JVM settings are rendered to.
+		if file, changed, err := applyJVM(server); err != nil {
+			logger.Printf("Failed to apply JVM settings of server %q: %v", server, err)
+		} else if changed {
+			logger.Printf("Reapplied the JVM settings of server %q to %s, which no longer matched them", server, file)
+		}
 
 		// Start the server.
-		entry := filepath.Join(common.ServerDirectory(server), "run.sh")
-		opts := run.Options{
-			Name: "screen",
-			Args: []string{
-				"-S",
-				fmt.Sprintf("%s.server", server),
-				"-d",
-				"-m",
-				"./run.sh",
-			},
-			Dir:        common.ServerDirectory(server),
-			OutputType: run.OutputCombined,
-			ExecMode:   run.ExecModeDetach,
+		cred, err := prepareUser(server)
+		if err != nil {
+			return fmt.Errorf("failed to start server %s: %v", server, err)
 		}
-		if _, err := run.WithContext(ctx, opts); err != nil {
+		entry := filepath.Join(common.ServerDirectory(server), "run.sh")
+		startCgroup(server)
+		if err := Backend().Start(ctx, server, common.ServerDirectory(server), cred); err != nil {
 			return fmt.Errorf("failed to start server %s: %v", server, err)
 		}
 		logger.Printf("Started server %q from %q", server, entry)
+		placeCgroup(ctx, server)
 	}
 	if started {
 		// Only update status if a new server is started.
@@ -260,67 +196,67 @@ func Start(ctx context.Context, servers ...string) error {
 	return nil
 }
 
-// Stop stops all the specified servers.
-func Stop(ctx context.Context, servers ...string) error {
+// Stop stops all the specified servers according to their stop policy.
+func Stop(ctx context.Context, req StopRequest) error {
 	runningServers, err := GetRunningServers(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to get currently running servers")
 	}
-	var stopped bool
+	var stopped atomic.Bool
 	var wg sync.WaitGroup
-	for _, server := range servers {
+	for _, server := range req.Servers {
 		// Stop/kill each specified server in their own go routines.
 		wg.Go(func() {
-			// If the server is already not running, we do nothing.
+			// If the server is already not running, we only keep it from being
+			// recovered or woken.
 			if !slices.Contains(runningServers, server) {
+				common.ServerStatusesMu.Lock()
+				if status, ok := common.ServerStatuses[server]; ok {
+					stopped.Store(true)
+					status.ShouldRun = false
+					status.NextRetry = time.Time{}
+					status.Restarts = nil
+					status.SetState(common.StateStopped)
+				}
+				common.ServerStatusesMu.Unlock()
 				return
 			}
 
 			// Server shouldn't run anymore. Reset start time.
-			stopped = true
 			common.ServerStatusesMu.Lock()
-			common.ServerStatuses[server].ShouldRun = false
-			common.ServerStatuses[server].StartTime = time.Time{}
-			common.ServerStatusesMu.Unlock()
-
-			// Attempt to stop the server naturally.
-			opts := run.Options{
-				Name: "screen",
-				Args: []string{
-					"-S",
-					server,
-					"-X",
-					"stuff",
-					"stop^M",
-				},
-				OutputType: run.OutputCombined,
-				ExecMode:   run.ExecModeDetach,
-			}
-			if _, err := run.WithContext(ctx, opts); err != nil {
-				logger.Printf("Failed to stop server %q: %v", server, err)
+			status, ok := common.ServerStatuses[server]
+			if !ok {
+				common.ServerStatusesMu.Unlock()
+				logger.Printf("Server %q is not registered, skipping stop", server)
 				return
 			}
-
-			// Poll the list to see if it's stopped. If it's no longer there, we're good.
-			// Otherwise, we wait until a specified timeout before force-killing the server.
-			currentServers, err := GetRunningServers(ctx)
-			if err != nil {
-				logger.Printf("failed to get currently running servers: %v", err)
-				return
+			stopped.Store(true)
+			status.ShouldRun = false
+			status.StartTime = time.Time{}
+			status.NextRetry = time.Time{}
+			status.Health = ""
+			status.IdleSince = time.Time{}
+			status.SetState(common.StateStopping)
+			policy := effectiveStopPolicy(status)
+			common.ServerStatusesMu.Unlock()
+			if err := common.UpdateServerStatus(); err != nil {
+				logger.Printf("Failed to update server status: %v", err)
 			}
-			var counter int
-			for slices.Contains(currentServers, server) && counter < killServerTimeout {
-				time.Sleep(time.Second)
-				counter++
-				currentServers, err = GetRunningServers(ctx)
-				if err != nil {
-					logger.Printf("failed to get currently running servers: %v", err)
+
+			if err := stopServer(ctx, server, policy, req.Now); err != nil {
+				logger.Printf("Failed to stop server %q: %v", server, err)
+				// Leave stopping for whatever state the process is really in.
+				running, err := GetRunningServers(ctx)
+				if err != nil || slices.Contains(running, server) {
+					common.ServerStatusesMu.Lock()
+					status.SetState(common.StateRunning)
+					common.ServerStatusesMu.Unlock()
+					return
 				}
 			}
-			if counter >= killServerTimeout {
-				logger.Printf("Server did not exit within timeout, force-killing...")
-				Kill(ctx, false, server)
-			}
+			common.ServerStatusesMu.Lock()
+			status.SetState(common.StateStopped)
+			common.ServerStatusesMu.Unlock()
 
 			// Enable backups one last time.
 			common.BackupStatusesMu.Lock()
@@ -329,7 +265,7 @@ func Stop(ctx context.Context, servers ...string) error {
 		})
 	}
 	wg.Wait()
-	if stopped {
+	if stopped.Load() {
 		// Only update if an existing server is actually stopped.
 		if err := common.UpdateServerStatus(); err != nil {
 			return fmt.Errorf("failed to update server status: %v", err)
@@ -339,11 +275,11 @@ func Stop(ctx context.Context, servers ...string) error {
 }
 
 // Restart stops and starts all the specified servers.
-func Restart(ctx context.Context, servers ...string) error {
-	if err := Stop(ctx, servers...); err != nil {
+func Restart(ctx context.Context, req StopRequest) error {
+	if err := Stop(ctx, req); err != nil {
 		return fmt.Errorf("failed to stop servers: %v", err)
 	}
-	if err := Start(ctx, servers...); err != nil {
+	if err := Start(ctx, req.Servers...); err != nil {
 		return fmt.Errorf("failed to start servers: %v", err)
 	}
 	return nil
@@ -352,23 +288,17 @@ func Restart(ctx context.Context, servers ...string) error {
 // Kill force-stops the server. This should be avoided unless the server
 // fails to shut down the normal way.
 func Kill(ctx context.Context, recover bool, server string) error {
-	if !recover {
-		common.ServerStatusesMu.Lock()
-		common.ServerStatuses[server].ShouldRun = false
-		common.ServerStatusesMu.Unlock()
-	}
-	killOpts := run.Options{
-		Name: "screen",
-		Args: []string{
-			"-S",
-			server,
-			"-X",
-			"quit",
-		},
-		OutputType: run.OutputNone,
-		ExecMode:   run.ExecModeAsync,
+	common.ServerStatusesMu.Lock()
+	if status, ok := common.ServerStatuses[server]; ok {
+		if recover {
+			status.SetState(common.StateCrashed)
+		} else {
+			status.ShouldRun = false
+			status.SetState(common.StateStopped)
+		}
 	}
-	if _, err := run.WithContext(ctx, killOpts); err != nil {
+	common.ServerStatusesMu.Unlock()
+	if err := Backend().Kill(ctx, server); err != nil {
 		return fmt.Errorf("failed to force-kill server %q: %v", server, err)
 	}
 	return nil
@@ -384,6 +314,9 @@ func Recover(ctx context.Context, server string) error {
 		}
 		return nil
 	}
+	if err := recordCrashes(server, reports); err != nil {
+		logger.Printf("Failed to record crashes of server %q: %v", server, err)
+	}
 	for _, report := range reports {
 		if report.IsDir() {
 			continue
@@ -399,11 +332,17 @@ func Recover(ctx context.Context, server string) error {
 
 		// If the server crashed in the last 30 seconds, attempt to restart the server.
 		common.ServerStatusesMu.Lock()
-		srvRecoveryState := common.ServerStatuses[server].Recovering
+		status, ok := common.ServerStatuses[server]
+		if !ok {
+			// The server was unregistered in the meantime.
+			common.ServerStatusesMu.Unlock()
+			return nil
+		}
+		srvRecoveryState := status.Recovering
 		common.ServerStatusesMu.Unlock()
 		if time.Since(crashTime) < recoveryTime && !srvRecoveryState {
 			common.ServerStatusesMu.Lock()
-			common.ServerStatuses[server].Recovering = true
+			status.Recovering = true
 			common.ServerStatusesMu.Unlock()
 			logger.Printf("Crash detected for server %q", server)
 			if err := Kill(ctx, true, server); err != nil {
@@ -414,10 +353,12 @@ func Recover(ctx context.Context, server string) error {
 
 				// Reset Recovering to false.
 				common.ServerStatusesMu.Lock()
-				common.ServerStatuses[server].Recovering = false
+				status.Recovering = false
 				common.ServerStatusesMu.Unlock()
 			}()
-			return Start(ctx, server)
+			// The manager restarts the killed server, subject to the
+			// crash-loop backoff.
+			return nil
 		}
 	}
 	return nil
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/common"
+)
+
+func TestStopFailure(t *testing.T) {
+	fake := setupFakeServer(t, "test", "")
+	registerServer(t, "test")
+	fake.running = []string{"test"}
+	fake.killErr = errors.New("kill failed")
+	common.ServerStatusesMu.Lock()
+	common.ServerStatuses["test"].ShouldRun = true
+	common.ServerStatuses["test"].State = common.StateRunning
+	common.ServerStatuses["test"].StopPolicy = &common.StopPolicy{Warnings: []time.Duration{}, Timeout: time.Millisecond}
+	common.ServerStatusesMu.Unlock()
+
+	if err := Stop(context.Background(), StopRequest{Servers: []string{"test"}}); err != nil {
+		t.Fatalf("Stop() failed: %v", err)
+	}
+	common.ServerStatusesMu.Lock()
+	state := common.ServerStatuses["test"].State
+	common.ServerStatusesMu.Unlock()
+	if state != common.StateRunning {
+		t.Errorf("state after failed stop = %s, want %s", state, common.StateRunning)
+	}
+}
+
+func TestStopNotRunning(t *testing.T) {
+	for _, state := range []common.State{common.StateCrashed, common.StateRecovering, common.StateFailed, common.StateSleeping} {
+		t.Run(string(state), func(t *testing.T) {
+			setupFakeServer(t, "test", "")
+			registerServer(t, "test")
+			common.ServerStatusesMu.Lock()
+			status := common.ServerStatuses["test"]
+			status.ShouldRun = true
+			status.State = state
+			status.Restarts = []time.Time{time.Now()}
+			status.NextRetry = time.Now().Add(time.Minute)
+			common.ServerStatusesMu.Unlock()
+
+			if err := Stop(context.Background(), StopRequest{Servers: []string{"test"}}); err != nil {
+				t.Fatalf("Stop() failed: %v", err)
+			}
+			common.ServerStatusesMu.Lock()
+			defer common.ServerStatusesMu.Unlock()
+			// The recovery only restarts servers that should run.
+			if status.ShouldRun || status.State != common.StateStopped || len(status.Restarts) != 0 || !status.NextRetry.IsZero() {
+				t.Errorf("status after stop = (should run %t, %s, %d restarts, retry at %v), want a stopped server", status.ShouldRun, status.State, len(status.Restarts), status.NextRetry)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/common"
+	"github.com/dranilew/minecraft-server-manager/src/lib/logger"
+	"github.com/dranilew/minecraft-server-manager/src/lib/status"
+)
+
+var (
+	// readyLogRegex matches the log line of a server that finished starting,
+	// like "Done (12.345s)! For help, type "help"".
+	readyLogRegex = regexp.MustCompile(`Done \([0-9.,]+s\)!`)
+	// stoppingLogRegex matches the log line of a server that is stopping,
+	// like when stop was typed into the console.
+	stoppingLogRegex = regexp.MustCompile(`Stopping (the )?server$`)
+
+	// stateCursor tracks the log lines scanned for state changes.
+	stateCursor = newLogCursor()
+)
+
+// States are all valid server states.
+var States = []common.State{
+	common.StateStopped,
+	common.StateStarting,
+	common.StateRunning,
+	common.StateStopping,
+	common.StateCrashed,
+	common.StateRecovering,
+	common.StateFailed,
+	common.StateSleeping,
+}
+
+// ParseState parses the name of a server state.
+func ParseState(s string) (common.State, error) {
+	state := common.State(s)
+	if !slices.Contains(States, state) {
+		return "", fmt.Errorf("invalid state %q, must be one of %v", s, States)
+	}
+	return state, nil
+}
+
+// UpdateStates advances the lifecycle state of all registered servers from
+// their processes and console events. Crashes are detected by the manager's
+// recovery instead.
+func UpdateStates(ctx context.Context, runningServers []string) error {
+	common.ServerStatusesMu.Lock()
+	servers := make(map[string]common.ServerStatus)
+	for k, v := range common.ServerStatuses {
+		servers[k] = *v
+	}
+	common.ServerStatusesMu.Unlock()
+
+	var changed bool
+	for name, s := range servers {
+		next := nextState(ctx, name, s, slices.Contains(runningServers, name))
+		if next == s.State {
+			continue
+		}
+		common.ServerStatusesMu.Lock()
+		if status, ok := common.ServerStatuses[name]; ok && status.State == s.State {
+			logger.Printf("Server %q is now %s, was %s for %s", name, next, s.State, formatDuration(time.Since(s.StateSince).Round(time.Second)))
+			changed = status.SetState(next) || changed
+		}
+		common.ServerStatusesMu.Unlock()
+	}
+	if changed {
+		if err := common.UpdateServerStatus(); err != nil {
+			return fmt.Errorf("failed to update server status: %v", err)
+		}
+	}
+	return nil
+}
+
+// nextState returns the state the server should move to.
+func nextState(ctx context.Context, server string, s common.ServerStatus, running bool) common.State {
+	switch s.State {
+	case common.StateStarting, common.StateRecovering:
+		if !running {
+			// Either a crash, or the process hasn't shown up yet.
+			return s.State
+		}
+		if ready(ctx, server, s) {
+			return common.StateRunning
+		}
+	case common.StateRunning:
+		if !running && !s.ShouldRun {
+			return common.StateStopped
+		}
+		if running && stopping(server) {
+			return common.StateStopping
+		}
+	case common.StateStopping:
+		if !running {
+			return common.StateStopped
+		}
+	case common.StateCrashed, common.StateFailed:
+		// Only the recovery leaves these states.
+	case common.StateSleeping:
+		// Sleeping servers are woken by starting them.
+		if running {
+			return common.StateRunning
+		}
+	default:
+		// Servers from before states were tracked, or stopped servers that
+		// were started outside of the manager.
+		if running {
+			return common.StateRunning
+		}
+		return common.StateStopped
+	}
+	return s.State
+}
+
+// ready returns whether the starting server is ready for players. Servers are
+// ready once they log that they're done starting, or answer status pings.
+func ready(ctx context.Context, server string, s common.ServerStatus) bool {
+	// The previous log may still be in place right after starting, so ignore
+	// lines logged before the server started.
+	since := s.StartTime.Truncate(time.Second)
+	var done bool
+	err := stateCursor.scan(server, true, func(line string, modTime time.Time) error {
+		if t, ok := logLineTime(line, modTime); ok && t.Before(since) {
+			return nil
+		}
+		done = done || readyLogRegex.MatchString(line)
+		return nil
+	})
+	if err != nil {
+		logger.Debugf("Failed to scan logs of server %q: %v", server, err)
+	}
+	if done {
+		return true
+	}
+	_, err = status.Online(ctx, uint16(s.Port))
+	return err == nil
+}
+
+// stopping returns whether the running server logged that it is stopping.
+func stopping(server string) bool {
+	var stopping bool
+	err := stateCursor.scan(server, false, func(line string, _ time.Time) error {
+		stopping = stopping || stoppingLogRegex.MatchString(line)
+		return nil
+	})
+	if err != nil {
+		logger.Debugf("Failed to scan logs of server %q: %v", server, err)
+	}
+	return stopping
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/common"
+	"github.com/dranilew/minecraft-server-manager/src/lib/logger"
+	"github.com/dranilew/minecraft-server-manager/src/lib/status"
+)
+
+const (
+	// stopPollInterval is the interval at which a stopping server is polled.
+	stopPollInterval = time.Second
+)
+
+var (
+	// stopWarnings is the default list of times before stopping at which players are warned.
+	stopWarnings = flag.String("stop-warnings", "1m,10s", "Comma-separated times before stopping a server at which online players are warned. Servers without players online are stopped right away.")
+	// stopTimeout is the default time to wait for a server to exit before force-killing it.
+	stopTimeout = flag.Duration("stop-timeout", time.Minute, "Time to wait for a server to exit after stopping it before force-killing it.")
+)
+
+// StopRequest is a request to stop or restart servers.
+type StopRequest struct {
+	// Servers is the list of servers to stop.
+	Servers []string
+	// Now skips the countdown warning players.
+	Now bool
+}
+
+// StopPolicyRequest is a request to change the stop policy of a server.
+type StopPolicyRequest struct {
+	// Server is the server whose stop policy to change.
+	Server string
+	// Policy is the new stop policy. Unset fields keep their current values.
+	Policy common.StopPolicy
+	// Reset resets the stop policy to the defaults.
+	Reset bool
+}
+
+// ParseWarnings parses a comma-separated list of durations. An empty string
+// results in an empty, non-nil list.
+func ParseWarnings(s string) ([]time.Duration, error) {
+	warnings := []time.Duration{}
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		d, err := time.ParseDuration(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid warning time %q: %v", field, err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("invalid warning time %q: must be positive", field)
+		}
+		warnings = append(warnings, d)
+	}
+	return warnings, nil
+}
+
+// DefaultStopPolicy returns the stop policy configured by flags.
+func DefaultStopPolicy() common.StopPolicy {
+	warnings, err := ParseWarnings(*stopWarnings)
+	if err != nil {
+		logger.Printf("Ignoring invalid --stop-warnings: %v", err)
+	}
+	return common.StopPolicy{Warnings: warnings, Timeout: *stopTimeout}
+}
+
+// effectiveStopPolicy merges the server's stop policy with its manifest and
+// the defaults.
+func effectiveStopPolicy(status *common.ServerStatus) common.StopPolicy {
+	policy := DefaultStopPolicy()
+	if status.StopPolicy != nil {
+		if status.StopPolicy.Warnings != nil {
+			policy.Warnings = status.StopPolicy.Warnings
+		}
+		if status.StopPolicy.Timeout > 0 {
+			policy.Timeout = status.StopPolicy.Timeout
+		}
+	}
+	if m := manifestOf(status.Name); m != nil && m.StopTimeout > 0 {
+		policy.Timeout = m.StopTimeout
+	}
+	return policy
+}
+
+// SetStopPolicy changes the stop policy of a registered server.
+func SetStopPolicy(req StopPolicyRequest) error {
+	if m := manifestOf(req.Server); m != nil && m.StopTimeout > 0 && req.Policy.Timeout > 0 {
+		return fmt.Errorf("stop timeout of server %q is set in its %s", req.Server, ManifestFile)
+	}
+	common.ServerStatusesMu.Lock()
+	status, ok := common.ServerStatuses[req.Server]
+	if !ok {
+		common.ServerStatusesMu.Unlock()
+		return fmt.Errorf("server %q is not registered", req.Server)
+	}
+	if req.Reset {
+		status.StopPolicy = nil
+	} else {
+		var policy common.StopPolicy
+		if status.StopPolicy != nil {
+			policy = *status.StopPolicy
+		}
+		if req.Policy.Warnings != nil {
+			policy.Warnings = req.Policy.Warnings
+		}
+		if req.Policy.Timeout > 0 {
+			policy.Timeout = req.Policy.Timeout
+		}
+		status.StopPolicy = &policy
+	}
+	common.ServerStatusesMu.Unlock()
+	return common.UpdateServerStatus()
+}
+
+// stopServer warns online players, saves the world, stops the server and
+// waits for it to exit. The server is force-killed if it doesn't exit in time.
+func stopServer(ctx context.Context, server string, policy common.StopPolicy, now bool) error {
+	if !now {
+		countdown(ctx, server, policy.Warnings)
+	}
+
+	if _, err := RunCommand(ctx, server, "save-all flush"); err != nil {
+		logger.Printf("Failed to save server %q before stopping: %v", server, err)
+	}
+	if _, err := RunCommand(ctx, server, "stop"); err != nil {
+		return err
+	}
+
+	// Poll the list to see if it's stopped. If it's no longer there, we're good.
+	// Otherwise, we wait until the timeout before force-killing the server.
+	deadline := time.Now().Add(policy.Timeout)
+	for time.Now().Before(deadline) {
+		currentServers, err := GetRunningServers(ctx)
+		if err != nil {
+			logger.Printf("failed to get currently running servers: %v", err)
+		} else if !slices.Contains(currentServers, server) {
+			return nil
+		}
+		time.Sleep(stopPollInterval)
+	}
+	logger.Printf("Server %q did not exit within %v, force-killing...", server, policy.Timeout)
+	return Kill(ctx, false, server)
+}
+
+// countdown warns online players at each of the warning times before
+// returning. It returns right away if no players are online.
+func countdown(ctx context.Context, server string, warnings []time.Duration) {
+	if len(warnings) == 0 {
+		return
+	}
+	common.ServerStatusesMu.Lock()
+	port := common.ServerStatuses[server].Port
+	common.ServerStatusesMu.Unlock()
+	if online, err := status.Online(ctx, uint16(port)); err != nil || online == 0 {
+		return
+	}
+
+	warnings = slices.Clone(warnings)
+	slices.Sort(warnings)
+	slices.Reverse(warnings)
+	stopAt := time.Now().Add(warnings[0])
+	for _, warning := range warnings {
+		time.Sleep(time.Until(stopAt.Add(-warning)))
+		if err := Notify(ctx, server, fmt.Sprintf("Server stopping in %s", formatDuration(warning))); err != nil {
+			logger.Printf("Failed to warn players of server %q: %v", server, err)
+		}
+	}
+	time.Sleep(time.Until(stopAt))
+}
+
+// formatDuration formats the duration without trailing zero units, like 5m
+// instead of 5m0s.
+func formatDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/common"
+	"github.com/dranilew/minecraft-server-manager/src/lib/logger"
+	"github.com/dranilew/minecraft-server-manager/src/lib/run"
+)
+
+const (
+	// systemUserPrefix is the prefix of system users created for servers.
+	systemUserPrefix = "mc-"
+	// maxUserNameLength is the longest user name useradd accepts.
+	maxUserNameLength = 32
+)
+
+// invalidUserNameRegex matches characters that aren't valid in user names.
+var invalidUserNameRegex = regexp.MustCompile(`[^a-z0-9_-]`)
+
+// UserRequest is a request to change the user a server runs as.
+type UserRequest struct {
+	// Server is the server whose user to change.
+	Server string
+	// User is the name of the user. If empty and Create is set, a user is
+	// named after the server.
+	User string
+	// Create creates the user as a system user if it doesn't exist.
+	Create bool
+	// Clear makes the server run as the manager's user again.
+	Clear bool
+}
+
+// SystemUserName returns the name of the system user created for the server.
+func SystemUserName(server string) string {
+	name := systemUserPrefix + invalidUserNameRegex.ReplaceAllString(strings.ToLower(server), "-")
+	if len(name) > maxUserNameLength {
+		name = name[:maxUserNameLength]
+	}
+	return name
+}
+
+// SetUser changes the user a registered server runs as, and gives the user
+// ownership of the server directory. Progress is written to out.
+func SetUser(ctx context.Context, req UserRequest, out func(string) error) error {
+	common.ServerStatusesMu.Lock()
+	_, ok := common.ServerStatuses[req.Server]
+	common.ServerStatusesMu.Unlock()
+	if !ok {
+		return fmt.Errorf("server %q is not registered", req.Server)
+	}
+
+	name := req.User
+	if !req.Clear {
+		if name == "" {
+			if !req.Create {
+				return fmt.Errorf("no user given")
+			}
+			name = SystemUserName(req.Server)
+		}
+		created, err := ensureUser(name, common.ServerDirectory(req.Server), req.Create)
+		if err != nil {
+			return err
+		}
+		if created {
+			if err := out(fmt.Sprintf("Created system user %q", name)); err != nil {
+				return err
+			}
+		}
+	}
+
+	common.ServerStatusesMu.Lock()
+	if status, ok := common.ServerStatuses[req.Server]; ok {
+		if req.Clear {
+			status.User = ""
+		} else {
+			status.User = name
+		}
+	}
+	common.ServerStatusesMu.Unlock()
+	if err := common.UpdateServerStatus(); err != nil {
+		return fmt.Errorf("failed to update server status: %v", err)
+	}
+	if req.Clear {
+		if err := out(fmt.Sprintf("Server %q runs as the manager's user, the owner of its files is left as is", req.Server)); err != nil {
+			return err
+		}
+	} else {
+		cred, err := serverCredential(req.Server)
+		if err != nil {
+			return err
+		}
+		if err := chownTree(common.ServerDirectory(req.Server), cred); err != nil {
+			return fmt.Errorf("failed to give user %q ownership of server %q: %v", name, req.Server, err)
+		}
+		if err := out(fmt.Sprintf("Server %q runs as user %q", req.Server, name)); err != nil {
+			return err
+		}
+	}
+
+	runningServers, err := GetRunningServers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get running servers: %v", err)
+	}
+	if slices.Contains(runningServers, req.Server) {
+		return out(fmt.Sprintf("Restart server %q for the change to take effect", req.Server))
+	}
+	return nil
+}
+
+// serverCredential returns the credential of the user the server runs as, or
+// nil if it runs as the manager's user.
+func serverCredential(server string) (*run.Credential, error) {
+	common.ServerStatusesMu.Lock()
+	var name string
+	if status, ok := common.ServerStatuses[server]; ok {
+		name = status.User
+	}
+	common.ServerStatusesMu.Unlock()
+	if name == "" {
+		return nil, nil
+	}
+	cred, err := lookupCredential(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up user %q of server %q: %v", name, server, err)
+	}
+	return cred, nil
+}
+
+// prepareUser returns the credential the server runs as, and makes sure its
+// user owns the server directory, including files written by the manager.
+func prepareUser(server string) (*run.Credential, error) {
+	cred, err := serverCredential(server)
+	if err != nil || cred == nil {
+		return nil, err
+	}
+	if err := chownTree(common.ServerDirectory(server), cred); err != nil {
+		return nil, fmt.Errorf("failed to fix ownership of server %q: %v", server, err)
+	}
+	logger.Debugf("Server %q runs as uid %d", server, cred.UID)
+	return cred, nil
+}
@@ -0,0 +1,188 @@
+//go:build linux
+
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/logger"
+	"github.com/dranilew/minecraft-server-manager/src/lib/run"
+	"golang.org/x/sys/unix"
+)
+
+// nologinShell is the login shell of system users created for servers.
+const nologinShell = "/usr/sbin/nologin"
+
+// lookupCredential returns the credential of the user, including its
+// supplementary groups.
+func lookupCredential(name string) (*run.Credential, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid uid %q: %v", u.Uid, err)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gid %q: %v", u.Gid, err)
+	}
+	if uid == 0 {
+		return nil, fmt.Errorf("user %q is root", name)
+	}
+	cred := &run.Credential{UID: uint32(uid), GID: uint32(gid)}
+	groupIDs, err := u.GroupIds()
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up groups: %v", err)
+	}
+	for _, id := range groupIDs {
+		g, err := strconv.ParseUint(id, 10, 32)
+		if err != nil || uint32(g) == cred.GID {
+			continue
+		}
+		cred.Groups = append(cred.Groups, uint32(g))
+	}
+	return cred, nil
+}
+
+// ensureUser makes sure the user exists, creating it as a system user with
+// the given home directory if requested. It returns whether it was created.
+func ensureUser(name, home string, create bool) (bool, error) {
+	_, err := user.Lookup(name)
+	if err == nil {
+		return false, nil
+	}
+	var unknown user.UnknownUserError
+	if !errors.As(err, &unknown) {
+		return false, fmt.Errorf("failed to look up user %q: %v", name, err)
+	}
+	if !create {
+		return false, fmt.Errorf("user %q does not exist, pass --create to create it", name)
+	}
+	opts := run.Options{
+		Name:       "useradd",
+		Args:       []string{"--system", "--user-group", "--no-create-home", "--home-dir", home, "--shell", nologinShell, name},
+		OutputType: run.OutputCombined,
+	}
+	if _, err := run.WithContext(context.Background(), opts); err != nil {
+		return false, fmt.Errorf("failed to create user %q: %v", name, err)
+	}
+	logger.Printf("Created system user %q", name)
+	return true, nil
+}
+
+// chownTree gives the credential's user and group ownership of everything in
+// the directory, or of the file. Entries that already have the right owner
+// are left alone. The user may swap directories for symbolic links while the
+// tree is walked, so directories are opened relative to their parent without
+// following links, and ownership is only changed relative to open
+// directories.
+func chownTree(dir string, cred *run.Credential) error {
+	fd, err := unix.Open(dir, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if errors.Is(err, unix.ENOTDIR) {
+		_, err := chownEntry(unix.AT_FDCWD, dir, dir, cred)
+		return err
+	}
+	if err != nil {
+		return &os.PathError{Op: "open", Path: dir, Err: err}
+	}
+	defer unix.Close(fd)
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		return &os.PathError{Op: "stat", Path: dir, Err: err}
+	}
+	if st.Uid != cred.UID || st.Gid != cred.GID {
+		if err := unix.Fchown(fd, int(cred.UID), int(cred.GID)); err != nil {
+			return &os.PathError{Op: "chown", Path: dir, Err: err}
+		}
+	}
+	return chownEntries(fd, dir, cred, true)
+}
+
+// chownEntries gives the credential's user and group ownership of the
+// entries of the open directory, and of their descendants. The manifest in
+// the top directory keeps its owner, as some of its settings are only
+// trusted if the manager's user owns it.
+func chownEntries(fd int, dir string, cred *run.Credential, top bool) error {
+	// Reading the entries closes the file, so read them from a copy.
+	dup, err := unix.Dup(fd)
+	if err != nil {
+		return &os.PathError{Op: "dup", Path: dir, Err: err}
+	}
+	f := os.NewFile(uintptr(dup), dir)
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if top && name == ManifestFile {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		isDir, err := chownEntry(fd, name, path, cred)
+		if err != nil {
+			return err
+		}
+		if !isDir {
+			continue
+		}
+		child, err := unix.Openat(fd, name, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+		if err != nil {
+			// The directory was removed or replaced in the meantime.
+			if errors.Is(err, unix.ENOENT) || errors.Is(err, unix.ENOTDIR) || errors.Is(err, unix.ELOOP) {
+				continue
+			}
+			return &os.PathError{Op: "open", Path: path, Err: err}
+		}
+		err = chownEntries(child, path, cred, false)
+		unix.Close(child)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chownEntry gives the credential's user and group ownership of the named
+// entry of the open directory without following links, and returns whether
+// it is a directory. Entries removed in the meantime are skipped.
+func chownEntry(fd int, name, path string, cred *run.Credential) (bool, error) {
+	var st unix.Stat_t
+	if err := unix.Fstatat(fd, name, &st, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		if errors.Is(err, unix.ENOENT) {
+			return false, nil
+		}
+		return false, &os.PathError{Op: "stat", Path: path, Err: err}
+	}
+	isDir := st.Mode&unix.S_IFMT == unix.S_IFDIR
+	if st.Uid == cred.UID && st.Gid == cred.GID {
+		return isDir, nil
+	}
+	// Files linked from elsewhere, like hard links to files of other users,
+	// are not the server's to own.
+	if !isDir && st.Nlink > 1 {
+		logger.Printf("Not changing the owner of %q, it has %d hard links", path, st.Nlink)
+		return false, nil
+	}
+	if err := unix.Fchownat(fd, name, int(cred.UID), int(cred.GID), unix.AT_SYMLINK_NOFOLLOW); err != nil && !errors.Is(err, unix.ENOENT) {
+		return false, &os.PathError{Op: "chown", Path: path, Err: err}
+	}
+	return isDir, nil
+}
+
+// managerOwned returns whether the file is owned by the manager's user, and
+// only writable by its owner.
+func managerOwned(info fs.FileInfo) bool {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	return ok && int(st.Uid) == os.Geteuid() && info.Mode().Perm()&0022 == 0
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/run"
+)
+
+// owner returns the uid of the file, without following symbolic links.
+func owner(t *testing.T, path string) uint32 {
+	t.Helper()
+	info, err := os.Lstat(path)
+	if err != nil {
+		t.Fatalf("failed to stat %s: %v", path, err)
+	}
+	return info.Sys().(*syscall.Stat_t).Uid
+}
+
+func TestChownTree(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("changing owners requires root")
+	}
+	cred := &run.Credential{UID: 65534, GID: 65534}
+	outside := t.TempDir()
+	secret := filepath.Join(outside, "secret")
+	if err := os.WriteFile(secret, []byte("secret"), 0600); err != nil {
+		t.Fatalf("failed to write %s: %v", secret, err)
+	}
+
+	dir := t.TempDir()
+	for _, sub := range []string{"world/region", "config"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatalf("failed to create %s: %v", sub, err)
+		}
+	}
+	files := []string{"run.sh", "world/level.dat", "world/region/r.0.0.mca"}
+	for _, name := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	// The manifest is written by the manager's user.
+	if err := os.WriteFile(filepath.Join(dir, ManifestFile), nil, 0644); err != nil {
+		t.Fatalf("failed to write %s: %v", ManifestFile, err)
+	}
+	// The server's user planted links to files outside of the server.
+	if err := os.Symlink(outside, filepath.Join(dir, "mods")); err != nil {
+		t.Fatalf("failed to plant symlink: %v", err)
+	}
+	if err := os.Link(secret, filepath.Join(dir, "config", "linked")); err != nil {
+		t.Fatalf("failed to plant hard link: %v", err)
+	}
+
+	if err := chownTree(dir, cred); err != nil {
+		t.Fatalf("chownTree() failed: %v", err)
+	}
+	for _, name := range append(files, ".", "world", "world/region", "config", "mods") {
+		if got := owner(t, filepath.Join(dir, name)); got != cred.UID {
+			t.Errorf("owner of %s = %d, want %d", name, got, cred.UID)
+		}
+	}
+	// Single files, like rewritten player lists, are changed too.
+	single := filepath.Join(outside, "whitelist.json")
+	if err := os.WriteFile(single, nil, 0644); err != nil {
+		t.Fatalf("failed to write %s: %v", single, err)
+	}
+	if err := chownTree(single, cred); err != nil {
+		t.Fatalf("chownTree() of a file failed: %v", err)
+	}
+	if got := owner(t, single); got != cred.UID {
+		t.Errorf("owner of %s = %d, want %d", single, got, cred.UID)
+	}
+
+	for _, path := range []string{outside, secret, filepath.Join(dir, ManifestFile)} {
+		if got := owner(t, path); got != 0 {
+			t.Errorf("chownTree() changed the owner of %s to %d", path, got)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+//go:build windows
+
+package server
+
+import (
+	"fmt"
+	"io/fs"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/run"
+)
+
+// lookupCredential is not supported on Windows.
+func lookupCredential(string) (*run.Credential, error) {
+	return nil, fmt.Errorf("not implemented on windows")
+}
+
+// ensureUser is not supported on Windows.
+func ensureUser(string, string, bool) (bool, error) {
+	return false, fmt.Errorf("not implemented on windows")
+}
+
+// chownTree is not supported on Windows.
+func chownTree(string, *run.Credential) error {
+	return fmt.Errorf("not implemented on windows")
+}
+
+// managerOwned returns true on Windows, where servers don't run as their own
+// users.
+func managerOwned(fs.FileInfo) bool {
+	return true
+}
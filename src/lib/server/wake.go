@@ -0,0 +1,343 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/common"
+	"github.com/dranilew/minecraft-server-manager/src/lib/logger"
+	"github.com/mcstatus-io/mcutil/v4/proto"
+)
+
+const (
+	// sleepingMOTD is the MOTD of sleeping servers.
+	sleepingMOTD = "Server is sleeping – join to wake it"
+	// wakingMessage is the message players are disconnected with when they
+	// wake a server.
+	wakingMessage = "Server is starting, try again in a minute"
+	// wakeConnTimeout is the time a client has to finish a ping or login.
+	wakeConnTimeout = 10 * time.Second
+	// wakeGrace is the time during which the port of a server that is being
+	// started isn't listened on again, even if it is still sleeping.
+	wakeGrace = 30 * time.Second
+	// maxPacketSize is the largest packet accepted from clients, which is
+	// plenty for a handshake or login start.
+	maxPacketSize = 4096
+
+	// nextStateStatus, nextStateLogin and nextStateTransfer are the states a
+	// client asks for in its handshake.
+	nextStateStatus   = 1
+	nextStateLogin    = 2
+	nextStateTransfer = 3
+	// legacyPing is the first byte of pings of clients before 1.7.
+	legacyPing = 0xFE
+)
+
+var (
+	// wakeOnConnect enables listening on the ports of sleeping servers.
+	wakeOnConnect = flag.Bool("wake-on-connect", true, "Answer pings on the ports of sleeping servers, and start a sleeping server when a player tries to join it.")
+
+	// wakeListeners are the listeners on the ports of sleeping servers, by
+	// server.
+	wakeListeners   = make(map[string]*wakeListener)
+	wakeListenersMu sync.Mutex
+	// wakeErrors are the last errors listening on the port of a server, so
+	// that they are reported once.
+	wakeErrors = make(map[string]string)
+	// wokenAt are the times at which servers were started while sleeping.
+	wokenAt = make(map[string]time.Time)
+)
+
+// wakeListener listens on the port of a sleeping server.
+type wakeListener struct {
+	// server is the sleeping server.
+	server string
+	// port is the game port of the server.
+	port int
+	// maxPlayers is the player limit shown to clients.
+	maxPlayers int
+	// listener accepts connections on the port.
+	listener net.Listener
+	// wake starts the server once.
+	wake sync.Once
+}
+
+// handshake is the first packet sent by clients since 1.7.
+type handshake struct {
+	// protocol is the protocol version of the client.
+	protocol int32
+	// nextState is the state the client asks for.
+	nextState int32
+}
+
+// statusResponse is the JSON status of a server.
+type statusResponse struct {
+	Version struct {
+		Name     string `json:"name"`
+		Protocol int32  `json:"protocol"`
+	} `json:"version"`
+	Players struct {
+		Max    int `json:"max"`
+		Online int `json:"online"`
+	} `json:"players"`
+	Description textComponent `json:"description"`
+}
+
+// textComponent is a chat message.
+type textComponent struct {
+	Text string `json:"text"`
+}
+
+// ServeSleeping listens on the ports of sleeping servers, and stops listening
+// for servers that are no longer sleeping.
+func ServeSleeping(ctx context.Context) error {
+	if !*wakeOnConnect {
+		return nil
+	}
+	common.ServerStatusesMu.Lock()
+	sleeping := make(map[string]int)
+	for name, status := range common.ServerStatuses {
+		if status.State == common.StateSleeping && status.ShouldRun {
+			sleeping[name] = status.Port
+		}
+	}
+	common.ServerStatusesMu.Unlock()
+
+	wakeListenersMu.Lock()
+	defer wakeListenersMu.Unlock()
+	for name, l := range wakeListeners {
+		if port, ok := sleeping[name]; !ok || port != l.port {
+			l.listener.Close()
+			delete(wakeListeners, name)
+		}
+	}
+	for name, woken := range wokenAt {
+		if _, ok := sleeping[name]; !ok || time.Since(woken) > wakeGrace {
+			delete(wokenAt, name)
+		}
+	}
+	var errs []error
+	for name, port := range sleeping {
+		if _, ok := wakeListeners[name]; ok {
+			continue
+		}
+		if _, ok := wokenAt[name]; ok {
+			// The server is starting, but not marked as starting yet.
+			continue
+		}
+		l, err := listenWake(ctx, name, port)
+		if err != nil {
+			if wakeErrors[name] != err.Error() {
+				wakeErrors[name] = err.Error()
+				errs = append(errs, err)
+			}
+			continue
+		}
+		delete(wakeErrors, name)
+		wakeListeners[name] = l
+	}
+	return errors.Join(errs...)
+}
+
+// releaseWakeListener stops listening on the port of the server, so that the
+// server can bind it.
+func releaseWakeListener(server string) {
+	wakeListenersMu.Lock()
+	defer wakeListenersMu.Unlock()
+	if l, ok := wakeListeners[server]; ok {
+		wokenAt[server] = time.Now()
+		l.listener.Close()
+		delete(wakeListeners, server)
+		logger.Debugf("Released port %d of server %q", l.port, server)
+	}
+}
+
+// closeWakeListener stops listening on the port of the server until the
+// next time sleeping servers are served, like when its ports change.
+func closeWakeListener(server string) {
+	wakeListenersMu.Lock()
+	defer wakeListenersMu.Unlock()
+	if l, ok := wakeListeners[server]; ok {
+		l.listener.Close()
+		delete(wakeListeners, server)
+	}
+}
+
+// listenWake listens on the port of the sleeping server.
+func listenWake(ctx context.Context, server string, port int) (*wakeListener, error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on port %d of sleeping server %q: %v", port, server, err)
+	}
+	l := &wakeListener{server: server, port: port, maxPlayers: 20, listener: listener}
+	if props, err := LoadProperties(server); err == nil {
+		if value, ok := props.Get("max-players"); ok {
+			if n, err := strconv.Atoi(value); err == nil {
+				l.maxPlayers = n
+			}
+		}
+	}
+	logger.Printf("Listening on port %d of sleeping server %q", port, server)
+	go l.serve(ctx)
+	return l, nil
+}
+
+// serve accepts connections until the listener is closed.
+func (l *wakeListener) serve(ctx context.Context) {
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				logger.Printf("Failed to accept connection on port %d of sleeping server %q: %v", l.port, l.server, err)
+			}
+			return
+		}
+		go l.handle(ctx, conn)
+	}
+}
+
+// handle answers a ping, or wakes the server on a login attempt.
+func (l *wakeListener) handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(wakeConnTimeout))
+	r := bufio.NewReader(conn)
+	if first, err := r.Peek(1); err == nil && first[0] == legacyPing {
+		conn.Write(legacyStatus(l.maxPlayers))
+		return
+	}
+
+	id, data, err := readPacket(r)
+	if err != nil || id != 0x00 {
+		return
+	}
+	hs, err := readHandshake(data)
+	if err != nil {
+		logger.Debugf("Invalid handshake from %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	switch hs.nextState {
+	case nextStateStatus:
+		if id, _, err := readPacket(r); err != nil || id != 0x00 {
+			return
+		}
+		var status statusResponse
+		status.Version.Name = string(common.StateSleeping)
+		status.Version.Protocol = hs.protocol
+		status.Players.Max = l.maxPlayers
+		status.Description.Text = sleepingMOTD
+		b, err := json.Marshal(status)
+		if err != nil {
+			return
+		}
+		if err := writePacket(conn, 0x00, string(b)); err != nil {
+			return
+		}
+		// Answer the ping with the same payload.
+		id, data, err := readPacket(r)
+		if err != nil || id != 0x01 {
+			return
+		}
+		var payload bytes.Buffer
+		proto.WriteVarInt(0x01, &payload)
+		payload.ReadFrom(data)
+		proto.WriteVarInt(int32(payload.Len()), conn)
+		conn.Write(payload.Bytes())
+	case nextStateLogin, nextStateTransfer:
+		b, _ := json.Marshal(textComponent{Text: wakingMessage})
+		writePacket(conn, 0x00, string(b))
+		l.wake.Do(func() {
+			logger.Printf("Player connecting from %s is waking server %q", conn.RemoteAddr(), l.server)
+			go func() {
+				if err := Start(ctx, l.server); err != nil {
+					logger.Printf("Failed to wake server %q: %v", l.server, err)
+				}
+			}()
+		})
+	}
+}
+
+// readPacket reads a length-prefixed packet, and returns its ID and data.
+func readPacket(r *bufio.Reader) (int32, *bytes.Reader, error) {
+	length, err := proto.ReadVarInt(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if length <= 0 || length > maxPacketSize {
+		return 0, nil, fmt.Errorf("invalid packet length %d", length)
+	}
+	b := make([]byte, length)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return 0, nil, err
+	}
+	data := bytes.NewReader(b)
+	id, err := proto.ReadVarInt(data)
+	if err != nil {
+		return 0, nil, err
+	}
+	return id, data, nil
+}
+
+// writePacket writes a packet holding a single string.
+func writePacket(w io.Writer, id int32, s string) error {
+	var payload bytes.Buffer
+	proto.WriteVarInt(id, &payload)
+	proto.WriteString(s, &payload)
+	if err := proto.WriteVarInt(int32(payload.Len()), w); err != nil {
+		return err
+	}
+	_, err := w.Write(payload.Bytes())
+	return err
+}
+
+// readHandshake parses the data of a handshake packet.
+func readHandshake(data *bytes.Reader) (handshake, error) {
+	var hs handshake
+	var err error
+	if hs.protocol, err = proto.ReadVarInt(data); err != nil {
+		return hs, err
+	}
+	// Skip the address the client connected to.
+	length, err := proto.ReadVarInt(data)
+	if err != nil {
+		return hs, err
+	}
+	if length < 0 || int(length) > data.Len() {
+		return hs, fmt.Errorf("invalid address length %d", length)
+	}
+	if _, err := data.Seek(int64(length), io.SeekCurrent); err != nil {
+		return hs, err
+	}
+	var port uint16
+	if err := binary.Read(data, binary.BigEndian, &port); err != nil {
+		return hs, err
+	}
+	if hs.nextState, err = proto.ReadVarInt(data); err != nil {
+		return hs, err
+	}
+	return hs, nil
+}
+
+// legacyStatus returns the response to a ping of a client before 1.7.
+func legacyStatus(maxPlayers int) []byte {
+	fields := []string{"§1", "127", string(common.StateSleeping), sleepingMOTD, "0", strconv.Itoa(maxPlayers)}
+	chars := utf16.Encode([]rune(strings.Join(fields, "\x00")))
+	b := []byte{0xFF}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(chars)))
+	for _, c := range chars {
+		b = binary.BigEndian.AppendUint16(b, c)
+	}
+	return b
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/common"
+	"github.com/mcstatus-io/mcutil/v4/options"
+	"github.com/mcstatus-io/mcutil/v4/proto"
+	"github.com/mcstatus-io/mcutil/v4/status"
+)
+
+// sendLogin sends a handshake asking to log in, followed by a login start.
+func sendLogin(conn net.Conn, port int) error {
+	var hs bytes.Buffer
+	proto.WriteVarInt(0x00, &hs)
+	proto.WriteVarInt(767, &hs)
+	proto.WriteString("localhost", &hs)
+	binary.Write(&hs, binary.BigEndian, uint16(port))
+	proto.WriteVarInt(nextStateLogin, &hs)
+
+	var login bytes.Buffer
+	proto.WriteVarInt(0x00, &login)
+	proto.WriteString("Notch", &login)
+	login.Write(make([]byte, 16))
+
+	for _, packet := range []*bytes.Buffer{&hs, &login} {
+		if err := proto.WriteVarInt(int32(packet.Len()), conn); err != nil {
+			return err
+		}
+		if _, err := conn.Write(packet.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readDisconnect reads the login disconnect packet, and returns its message.
+func readDisconnect(conn net.Conn) (string, error) {
+	id, data, err := readPacket(bufio.NewReader(conn))
+	if err != nil {
+		return "", err
+	}
+	if id != 0x00 {
+		return "", fmt.Errorf("packet ID = %d, want a disconnect", id)
+	}
+	reason, err := proto.ReadString(data)
+	if err != nil {
+		return "", err
+	}
+	var message textComponent
+	if err := json.Unmarshal([]byte(reason), &message); err != nil {
+		return "", err
+	}
+	return message.Text, nil
+}
+
+func TestWakeOnConnect(t *testing.T) {
+	fake := setupFakeServer(t, "test", "max-players=42\n")
+	registerServer(t, "test")
+	port := closedPort(t)
+	common.ServerStatusesMu.Lock()
+	common.ServerStatuses["test"].Port = port
+	common.ServerStatuses["test"].ShouldRun = true
+	common.ServerStatuses["test"].State = common.StateSleeping
+	common.ServerStatusesMu.Unlock()
+	t.Cleanup(func() {
+		wakeListenersMu.Lock()
+		defer wakeListenersMu.Unlock()
+		for name, l := range wakeListeners {
+			l.listener.Close()
+			delete(wakeListeners, name)
+		}
+		clear(wokenAt)
+	})
+
+	ctx := context.Background()
+	if err := ServeSleeping(ctx); err != nil {
+		t.Fatalf("ServeSleeping() failed: %v", err)
+	}
+
+	modern, err := status.Modern(ctx, "127.0.0.1", uint16(port), options.StatusModern{Timeout: 5 * time.Second, ProtocolVersion: 767, Ping: true})
+	if err != nil {
+		t.Fatalf("status.Modern() failed: %v", err)
+	}
+	if modern.MOTD.Clean != sleepingMOTD {
+		t.Errorf("MOTD = %q, want %q", modern.MOTD.Clean, sleepingMOTD)
+	}
+	if modern.Players.Max == nil || *modern.Players.Max != 42 || modern.Players.Online == nil || *modern.Players.Online != 0 {
+		t.Errorf("players = %v/%v, want 0/42", modern.Players.Online, modern.Players.Max)
+	}
+	legacy, err := status.Legacy(ctx, "127.0.0.1", uint16(port), options.StatusLegacy{Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("status.Legacy() failed: %v", err)
+	}
+	if legacy.MOTD.Clean != sleepingMOTD || legacy.Players.Max != 42 {
+		t.Errorf("legacy status = (%q, %d), want (%q, 42)", legacy.MOTD.Clean, legacy.Players.Max, sleepingMOTD)
+	}
+	if len(fake.started) != 0 {
+		t.Fatalf("pings started the server")
+	}
+
+	// Several players join at once, which starts the server once.
+	var conns []net.Conn
+	for range 3 {
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		if err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		conns = append(conns, conn)
+	}
+	for _, conn := range conns {
+		if err := sendLogin(conn, port); err != nil {
+			t.Fatalf("failed to send login: %v", err)
+		}
+		message, err := readDisconnect(conn)
+		if err != nil {
+			t.Fatalf("failed to read disconnect: %v", err)
+		}
+		if message != wakingMessage {
+			t.Errorf("disconnect message = %q, want %q", message, wakingMessage)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		fake.mu.Lock()
+		n := len(fake.started)
+		fake.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Give extra starts the chance to happen.
+	time.Sleep(200 * time.Millisecond)
+	fake.mu.Lock()
+	started := fake.started
+	fake.mu.Unlock()
+	if len(started) != 1 || started[0] != "test" {
+		t.Errorf("started servers = %q, want [test]", started)
+	}
+	if _, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port)); err == nil {
+		t.Errorf("port %d is still held after waking the server", port)
+	}
+}
+
+func TestSetPortsWhileSleeping(t *testing.T) {
+	setupFakeServer(t, "test", "")
+	registerServer(t, "test")
+	port := closedPort(t)
+	common.ServerStatusesMu.Lock()
+	status := common.ServerStatuses["test"]
+	status.Port, status.QueryPort, status.RCONPort = port, closedPort(t), closedPort(t)
+	status.ShouldRun = true
+	status.State = common.StateSleeping
+	common.ServerStatusesMu.Unlock()
+	t.Cleanup(func() { closeWakeListener("test") })
+
+	ctx := context.Background()
+	if err := ServeSleeping(ctx); err != nil {
+		t.Fatalf("ServeSleeping() failed: %v", err)
+	}
+	// Keeping the game port checks that it is available.
+	rconPort := closedPort(t)
+	if err := SetPorts(ctx, SetPortsRequest{Server: "test", Ports: Ports{Game: port, RCON: rconPort}}); err != nil {
+		t.Fatalf("SetPorts() failed: %v", err)
+	}
+	common.ServerStatusesMu.Lock()
+	got := status.RCONPort
+	common.ServerStatusesMu.Unlock()
+	if got != rconPort {
+		t.Errorf("RCON port = %d, want %d", got, rconPort)
+	}
+
+	// The port is listened on again afterwards.
+	if err := ServeSleeping(ctx); err != nil {
+		t.Fatalf("ServeSleeping() failed: %v", err)
+	}
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatalf("port %d of the sleeping server isn't listened on after changing ports: %v", port, err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,253 @@
+package server
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/dranilew/minecraft-server-manager/src/lib/common"
+	"github.com/dranilew/minecraft-server-manager/src/lib/fileutil"
+	"github.com/dranilew/minecraft-server-manager/src/lib/logger"
+	"github.com/dranilew/minecraft-server-manager/src/lib/run"
+	"github.com/dranilew/minecraft-server-manager/src/lib/status"
+)
+
+const (
+	// HealthHealthy means the server responds normally.
+	HealthHealthy = "healthy"
+	// HealthDegraded means the server is lagging or partially unresponsive.
+	HealthDegraded = "degraded"
+	// HealthUnresponsive means the server is hung.
+	HealthUnresponsive = "unresponsive"
+
+	// diagnosticsDir is the directory in the server directory containing the
+	// diagnostics captured before force-restarting a hung server.
+	diagnosticsDir = "diagnostics"
+	// diagnosticsLogLines is the number of log lines included in diagnostics.
+	diagnosticsLogLines = 200
+	// consoleProbe is the command used to check whether the console responds.
+	consoleProbe = "list"
+	// consoleProbeTimeout is how long to wait for the console to respond.
+	consoleProbeTimeout = 10 * time.Second
+	// threadDumpTimeout is how long to wait for a thread dump.
+	threadDumpTimeout = 30 * time.Second
+)
+
+var (
+	// hangGrace is how long a server must be unresponsive before it is restarted.
+	hangGrace = flag.Duration("hang-grace", 3*time.Minute, "Time a server must be unresponsive before the watchdog captures diagnostics and force-restarts it.")
+	// hangStartupGrace is how long after starting a server it is not checked.
+	hangStartupGrace = flag.Duration("hang-startup-grace", 10*time.Minute, "Time after starting a server during which the watchdog doesn't check it, as modded servers start slowly.")
+
+	// lagLogRegex matches log lines of a server that can't keep up with its
+	// tick rate.
+	lagLogRegex = regexp.MustCompile(`Can't keep up!`)
+	// watchdogLogRegex matches log lines of the server's own watchdog
+	// detecting a stuck tick loop.
+	watchdogLogRegex = regexp.MustCompile(`A single server tick took|Considering it to be crashed|ServerHangWatchdog|Server Watchdog`)
+	// consoleProbeRegex matches the output of the console probe.
+	consoleProbeRegex = regexp.MustCompile(`(?i)players online`)
+
+	// watchdogCursor tracks the log lines scanned by the watchdog.
+	watchdogCursor = newLogCursor()
+)
+
+// healthCheck contains the signals collected for a health verdict.
+type healthCheck struct {
+	// pingErr is the error pinging the server, if any.
+	pingErr error
+	// consoleErr is the error if the console did not respond, if any.
+	consoleErr error
+	// lagging indicates the server logged that it can't keep up.
+	lagging bool
+	// watchdog indicates the server's own watchdog detected a stuck tick.
+	watchdog bool
+}
+
+// verdict returns the health of the server, and the reasons for it.
+func (c healthCheck) verdict() (string, []string) {
+	var reasons []string
+	if c.pingErr != nil {
+		reasons = append(reasons, fmt.Sprintf("status ping failed: %v", c.pingErr))
+	}
+	if c.consoleErr != nil {
+		reasons = append(reasons, fmt.Sprintf("console did not respond: %v", c.consoleErr))
+	}
+	if c.lagging {
+		reasons = append(reasons, "server can't keep up")
+	}
+	if c.watchdog {
+		reasons = append(reasons, "server watchdog detected a stuck tick")
+	}
+
+	switch {
+	case c.watchdog, c.pingErr != nil && c.consoleErr != nil:
+		return HealthUnresponsive, reasons
+	case len(reasons) > 0:
+		return HealthDegraded, reasons
+	default:
+		return HealthHealthy, nil
+	}
+}
+
+// CheckHealth determines the health of a running server. A server that has
+// been unresponsive for longer than the grace period is force-killed after
+// capturing diagnostics, which makes the manager recover it.
+func CheckHealth(ctx context.Context, server string) error {
+	common.ServerStatusesMu.Lock()
+	s, ok := common.ServerStatuses[server]
+	if !ok || !s.ShouldRun || time.Since(s.StartTime) < *hangStartupGrace {
+		common.ServerStatusesMu.Unlock()
+		return nil
+	}
+	port := s.Port
+	common.ServerStatusesMu.Unlock()
+
+	var check healthCheck
+	var err error
+	if check.lagging, check.watchdog, err = scanLogSignals(server); err != nil {
+		logger.Debugf("Failed to scan logs of server %q: %v", server, err)
+	}
+	if _, check.pingErr = status.Online(ctx, uint16(port)); check.pingErr != nil || check.watchdog {
+		// Only probe the console when needed, as it clutters the logs.
+		check.consoleErr = probeConsole(ctx, server)
+	}
+	health, reasons := check.verdict()
+
+	now := time.Now()
+	var restart bool
+	common.ServerStatusesMu.Lock()
+	if s, ok = common.ServerStatuses[server]; !ok {
+		common.ServerStatusesMu.Unlock()
+		return nil
+	}
+	changed := s.Health != health
+	s.Health = health
+	switch {
+	case health != HealthUnresponsive:
+		changed = changed || !s.UnhealthySince.IsZero()
+		s.UnhealthySince = time.Time{}
+	case s.UnhealthySince.IsZero():
+		s.UnhealthySince = now
+		changed = true
+	case now.Sub(s.UnhealthySince) >= *hangGrace:
+		s.UnhealthySince = time.Time{}
+		restart = true
+		changed = true
+	}
+	unhealthySince := s.UnhealthySince
+	common.ServerStatusesMu.Unlock()
+
+	if changed {
+		if health == HealthHealthy {
+			logger.Printf("Server %q is healthy", server)
+		} else {
+			logger.Printf("Server %q is %s: %s", server, health, strings.Join(reasons, "; "))
+		}
+		if err := common.UpdateServerStatus(); err != nil {
+			return fmt.Errorf("failed to update server status: %v", err)
+		}
+	}
+	if !restart {
+		if health == HealthUnresponsive {
+			logger.Debugf("Server %q has been unresponsive since %s", server, unhealthySince.Format(time.TimeOnly))
+		}
+		return nil
+	}
+
+	logger.Printf("Server %q has been unresponsive for %s, force-restarting it", server, formatDuration(*hangGrace))
+	path, err := captureDiagnostics(ctx, server, reasons)
+	if err != nil {
+		logger.Printf("Failed to capture diagnostics of server %q: %v", server, err)
+	} else {
+		logger.Printf("Captured diagnostics of server %q to %q", server, path)
+	}
+	return Kill(ctx, true, server)
+}
+
+// probeConsole checks whether the server's console responds to a command.
+func probeConsole(ctx context.Context, server string) error {
+	ctx, cancel := context.WithTimeout(ctx, consoleProbeTimeout)
+	defer cancel()
+	output, err := CaptureCommand(ctx, server, consoleProbe)
+	if err != nil {
+		return err
+	}
+	if !consoleProbeRegex.MatchString(output) {
+		return fmt.Errorf("no response to %q", consoleProbe)
+	}
+	return nil
+}
+
+// scanLogSignals reads the lines the server logged since the last scan, and
+// returns whether it logged that it is lagging or that its watchdog fired.
+func scanLogSignals(server string) (bool, bool, error) {
+	var lagging, watchdog bool
+	err := watchdogCursor.scan(server, false, func(line string, _ time.Time) error {
+		lagging = lagging || lagLogRegex.MatchString(line)
+		watchdog = watchdog || watchdogLogRegex.MatchString(line)
+		return nil
+	})
+	return lagging, watchdog, err
+}
+
+// captureDiagnostics writes thread dumps of the server's java processes and
+// its latest log lines to a file in the diagnostics directory, and returns
+// the path of the file.
+func captureDiagnostics(ctx context.Context, server string, reasons []string) (string, error) {
+	dir := common.ServerDirectory(server)
+	var b strings.Builder
+	fmt.Fprintf(&b, "Server %q was unresponsive at %s\n", server, time.Now().Format(time.DateTime))
+	for _, reason := range reasons {
+		fmt.Fprintf(&b, "- %s\n", reason)
+	}
+
+	pids, err := javaProcesses(dir)
+	if err != nil {
+		fmt.Fprintf(&b, "\nFailed to find java processes: %v\n", err)
+	}
+	// jstack can only attach to JVMs of its own user.
+	cred, err := serverCredential(server)
+	if err != nil {
+		fmt.Fprintf(&b, "\n%v\n", err)
+	}
+	for _, pid := range pids {
+		fmt.Fprintf(&b, "\n==> Thread dump of process %d <==\n", pid)
+		opts := run.Options{
+			Name:       "jstack",
+			Args:       []string{"-l", fmt.Sprint(pid)},
+			OutputType: run.OutputCombined,
+			Timeout:    threadDumpTimeout,
+			Credential: cred,
+		}
+		res, err := run.WithContext(ctx, opts)
+		if err != nil {
+			fmt.Fprintf(&b, "Failed to capture thread dump: %v\n", err)
+			continue
+		}
+		b.WriteString(res.Output)
+	}
+
+	lines, _, err := lastLines(filepath.Join(dir, logsDir, latestLog), diagnosticsLogLines)
+	if err != nil {
+		fmt.Fprintf(&b, "\nFailed to read logs: %v\n", err)
+	}
+	fmt.Fprintf(&b, "\n==> Last %d log lines <==\n", len(lines))
+	for _, line := range lines {
+		b.WriteString(line + "\n")
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, diagnosticsDir), 0755); err != nil {
+		return "", fmt.Errorf("failed to create diagnostics directory: %v", err)
+	}
+	path := filepath.Join(dir, diagnosticsDir, fmt.Sprintf("hang-%s.txt", time.Now().Format("2006-01-02_15.04.05")))
+	if err := fileutil.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		return "", fmt.Errorf("failed to write diagnostics: %v", err)
+	}
+	return path, nil
+}
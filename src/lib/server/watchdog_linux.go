@@ -0,0 +1,103 @@
+//go:build linux
+
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// process is a process on the host.
+type process struct {
+	pid  int
+	comm string
+}
+
+// javaProcesses returns the java processes running in the given directory.
+func javaProcesses(dir string) ([]int, error) {
+	procs, err := processesIn(dir)
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, p := range procs {
+		if p.comm == "java" {
+			pids = append(pids, p.pid)
+		}
+	}
+	return pids, nil
+}
+
+// processesIn returns the processes whose working directory is the given
+// directory.
+func processesIn(dir string) ([]process, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, err
+	}
+	var procs []process
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		procDir := filepath.Join("/proc", entry.Name())
+		if cwd, err := os.Readlink(filepath.Join(procDir, "cwd")); err != nil || cwd != dir {
+			continue
+		}
+		comm, err := os.ReadFile(filepath.Join(procDir, "comm"))
+		if err != nil {
+			continue
+		}
+		procs = append(procs, process{pid: pid, comm: strings.TrimSpace(string(comm))})
+	}
+	return procs, nil
+}
+
+// processTree returns the process and all its descendants.
+func processTree(root int) ([]int, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	children := make(map[int][]int)
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		stat, err := os.ReadFile(filepath.Join("/proc", entry.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+		// The command name may contain spaces, so parse after its closing
+		// parenthesis: state, then the parent's pid.
+		i := strings.LastIndexByte(string(stat), ')')
+		if i < 0 {
+			continue
+		}
+		fields := strings.Fields(string(stat[i+1:]))
+		if len(fields) < 2 {
+			continue
+		}
+		ppid, err := strconv.Atoi(fields[1])
+		if err != nil {
+			continue
+		}
+		children[ppid] = append(children[ppid], pid)
+	}
+	if _, err := os.Stat(filepath.Join("/proc", strconv.Itoa(root))); err != nil {
+		return nil, err
+	}
+	pids := []int{root}
+	for i := 0; i < len(pids); i++ {
+		pids = append(pids, children[pids[i]]...)
+	}
+	return pids, nil
+}
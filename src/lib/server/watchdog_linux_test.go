@@ -0,0 +1,36 @@
+package server
+
+import (
+	"os"
+	"os/exec"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestProcessTree(t *testing.T) {
+	// The child of the shell is a grandchild of the test.
+	cmd := exec.Command("sh", "-c", "sleep 10 & wait")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start shell: %v", err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	var pids []int
+	for range 100 {
+		var err error
+		if pids, err = processTree(os.Getpid()); err != nil {
+			t.Fatalf("processTree() failed: %v", err)
+		}
+		if len(pids) >= 3 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(pids) < 3 || pids[0] != os.Getpid() || !slices.Contains(pids, cmd.Process.Pid) {
+		t.Errorf("processTree() = %v, want the test, the shell %d and its child", pids, cmd.Process.Pid)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build windows
+
+package server
+
+import "fmt"
+
+// javaProcesses is not supported on Windows.
+func javaProcesses(string) ([]int, error) {
+	return nil, fmt.Errorf("not implemented on windows")
+}
+
+// processTree is not supported on Windows.
+func processTree(int) ([]int, error) {
+	return nil, fmt.Errorf("not implemented on windows")
+}
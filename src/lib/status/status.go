@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"regexp"
+	"sync"
 
 	"github.com/dranilew/minecraft-server-manager/src/lib/logger"
 	"github.com/dranilew/minecraft-server-manager/src/lib/run"
@@ -12,33 +13,37 @@ import (
 )
 
 var (
-	// ServerIP is the IP of the server.
-	ServerIP string
+	// ServerIP is the IP of the server. It is looked up on first use.
+	ServerIP     string
+	serverIPOnce sync.Once
 	// ipRegex is the regex for the IP.
 	ipRegex = regexp.MustCompile("[0-9]+.[0-9]+.[0-9]+.[0-9]+")
 )
 
-func init() {
-	// Initialize the server IP.
-	opts := run.Options{
-		Name: "dig",
-		Args: []string{
-			"TXT",
-			"+short",
-			"o-o.myaddr.l.google.com",
-			"@ns1.google.com",
-		},
-	}
-	out, err := run.WithContext(context.Background(), opts)
-	if err != nil {
-		logger.Fatalf("Failed to get server IP: %v", err)
-	}
-	ServerIP = ipRegex.FindString(out.Output)
+// serverIP returns the IP of the server, and looks it up the first time.
+func serverIP() string {
+	serverIPOnce.Do(func() {
+		opts := run.Options{
+			Name: "dig",
+			Args: []string{
+				"TXT",
+				"+short",
+				"o-o.myaddr.l.google.com",
+				"@ns1.google.com",
+			},
+		}
+		out, err := run.WithContext(context.Background(), opts)
+		if err != nil {
+			logger.Fatalf("Failed to get server IP: %v", err)
+		}
+		ServerIP = ipRegex.FindString(out.Output)
+	})
+	return ServerIP
 }
 
 // Online gets the number of players online on the server.
 func Online(ctx context.Context, port uint16) (int, error) {
-	resp, err := status.Legacy(ctx, ServerIP, port)
+	resp, err := status.Legacy(ctx, serverIP(), port)
 	if err != nil {
 		return 0, fmt.Errorf("failed to get server status: %v", err)
 	}